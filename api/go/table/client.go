@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
 	"github.com/stevejiang/gotable/util"
 	"io"
 	"log"
@@ -55,6 +56,10 @@ var (
 	ErrInvPkgLen   = initErr(EcInvPkgLen, "pkg length out of range")
 	ErrInvScanNum  = initErr(EcInvScanNum, "scan request number out of range")
 	ErrScanEnded   = initErr(EcScanEnded, "already scan/dump to end")
+	ErrNoQuorum    = initErr(EcNoQuorum, "not enough slavers applied in time")
+	ErrNotApplied  = initErr(EcNotApplied, "session writes not applied in time")
+	ErrMoved       = initErr(EcMoved, "unit moved to another server")
+	ErrAborted     = initErr(EcAborted, "atomic write aborted")
 )
 
 // GoTable Error Code List
@@ -77,6 +82,10 @@ const (
 	EcInvPkgLen   = -71 // Pkg length should be less than 2MB
 	EcInvScanNum  = -72 // Scan request number out of range
 	EcScanEnded   = -73 // Already scan/dump to end
+	EcNoQuorum    = -74 // Written on master, but not enough slavers applied in time
+	EcNotApplied  = -75 // Slaver has not applied the writes of session in time
+	EcMoved       = -76 // The unit is not served here, refresh cluster map and retry
+	EcAborted     = -77 // Atomic MSet/MDel not written, as another KV failed
 )
 
 var tableErrors = make([]error, 256)
@@ -106,8 +115,9 @@ type Client struct {
 	authBM   *util.BitMap
 	seq      uint64
 	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	closing  bool          // user has called Close
+	shutdown bool          // server has told us to stop
+	catalog  *ctrl.Catalog // Cached catalog to resolve names
 }
 
 // Create a new connection Client to GoTable server.
@@ -153,7 +163,7 @@ func Dial(network, address string) (*Client, error) {
 // Create a new client Context with selected dbId.
 // All operations on the Context use the selected dbId.
 func (c *Client) NewContext(dbId uint8) *Context {
-	return &Context{c, dbId}
+	return &Context{cli: c, dbId: dbId}
 }
 
 // Close the connection.
@@ -246,6 +256,9 @@ func (c *Client) recv() {
 		}
 
 		if call != nil {
+			if call.sess != nil {
+				call.sess.Update(proto.GetLogSeq(pkg))
+			}
 			call.pkg = pkg
 			call.ready = true
 			call.done()
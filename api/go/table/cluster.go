@@ -0,0 +1,566 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"errors"
+	"github.com/stevejiang/gotable/ctrl"
+	"sync"
+)
+
+var (
+	ErrClosedCluster = errors.New("cluster is closed")
+	ErrNoUnitRange   = errors.New("unit not found in cluster map")
+)
+
+// A Cluster is a client of GoTable cluster.
+// It caches the cluster routing table read from the config server, and routes
+// every request to the master of the unit which the rowKey belongs to.
+// It's safe to use in multiple goroutines.
+type Cluster struct {
+	network string
+	cfgAddr string // Config server address
+	connNum int    // Max connections to every master
+
+	mtx    sync.RWMutex
+	cm     ctrl.ClusterMap
+	pools  map[string]*Pool // Master address => Pool
+	closed bool
+}
+
+// Create a new Cluster, and read the cluster map from the config server.
+func NewCluster(network, cfgAddr string, connNum int) (*Cluster, error) {
+	var c = new(Cluster)
+	c.network = network
+	c.cfgAddr = cfgAddr
+	c.connNum = connNum
+	c.pools = make(map[string]*Pool)
+
+	err := c.Refresh()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Refresh reads the cluster map from the config server.
+// It's called automatically when a server replies EcMoved.
+func (c *Cluster) Refresh() error {
+	cli, err := Dial(c.network, c.cfgAddr)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	var cc = CtrlContext(*cli.NewContext(0))
+	cm, err := cc.GetCluster()
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return ErrClosedCluster
+	}
+	if cm.Version <= c.cm.Version {
+		return nil
+	}
+
+	c.cm = cm
+
+	// Close the pools of servers no longer master
+	var masters = make(map[string]bool)
+	for _, r := range cm.Ranges {
+		masters[r.Master] = true
+	}
+	for addr, p := range c.pools {
+		if !masters[addr] {
+			p.Close()
+			delete(c.pools, addr)
+		}
+	}
+
+	return nil
+}
+
+// Get the cached cluster map.
+func (c *Cluster) ClusterMap() ctrl.ClusterMap {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.cm
+}
+
+// Close all connections.
+func (c *Cluster) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	for _, p := range c.pools {
+		p.Close()
+	}
+	c.pools = nil
+}
+
+// Create a new ClusterContext with selected dbId.
+func (c *Cluster) NewContext(dbId uint8) *ClusterContext {
+	return &ClusterContext{c: c, dbId: dbId}
+}
+
+// Get the connection to the master of the unit.
+func (c *Cluster) getClient(unitId uint16) (*Client, error) {
+	c.mtx.RLock()
+	if c.closed {
+		c.mtx.RUnlock()
+		return nil, ErrClosedCluster
+	}
+	var r = c.cm.Lookup(unitId)
+	if r == nil {
+		c.mtx.RUnlock()
+		return nil, ErrNoUnitRange
+	}
+	var addr = r.Master
+	var p = c.pools[addr]
+	c.mtx.RUnlock()
+
+	if p == nil {
+		c.mtx.Lock()
+		if c.closed {
+			c.mtx.Unlock()
+			return nil, ErrClosedCluster
+		}
+		p = c.pools[addr]
+		if p == nil {
+			p = NewPool([]Addr{{c.network, addr}}, c.connNum)
+			c.pools[addr] = p
+		}
+		c.mtx.Unlock()
+	}
+
+	return p.Get()
+}
+
+// Cluster Context with selected dbId, requests are routed by rowKey.
+// Multi-key requests are split by servers and sent in parallel.
+// It's safe to use in multiple goroutines.
+type ClusterContext struct {
+	c        *Cluster
+	dbId     uint8
+	password string // Authorize every connection before use
+}
+
+// Get the selected database ID of the ClusterContext.
+func (cc *ClusterContext) DatabaseId() uint8 {
+	return cc.dbId
+}
+
+// Auth sets the password of the selected database (or the admin password),
+// which is sent on every connection before use.
+func (cc *ClusterContext) Auth(password string) {
+	cc.password = password
+}
+
+// Get the Context of the connection to the master of the unit.
+func (cc *ClusterContext) context(unitId uint16) (*Context, error) {
+	cli, err := cc.c.getClient(unitId)
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx = cli.NewContext(cc.dbId)
+	if len(cc.password) > 0 {
+		err = ctx.Auth(cc.password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// Run f on the master of the rowKey. When EcMoved is replied, refresh the
+// cluster map and try again.
+func (cc *ClusterContext) do(tableId uint8, rowKey []byte,
+	f func(ctx *Context) error) error {
+	var unitId = ctrl.GetUnitId(cc.dbId, tableId, rowKey)
+	for i := 0; ; i++ {
+		ctx, err := cc.context(unitId)
+		if err == nil {
+			err = f(ctx)
+		}
+		if err != ErrMoved || i > 0 {
+			return err
+		}
+
+		err = cc.c.Refresh()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Get value&score of the key in default column space.
+func (cc *ClusterContext) Get(tableId uint8, rowKey, colKey []byte, cas uint32) (
+	value []byte, score int64, casReply uint32, err error) {
+	err = cc.do(tableId, rowKey, func(ctx *Context) error {
+		var err error
+		value, score, casReply, err = ctx.Get(tableId, rowKey, colKey, cas)
+		return err
+	})
+	return
+}
+
+// Get value&score of the key in "Z" sorted score column space.
+func (cc *ClusterContext) ZGet(tableId uint8, rowKey, colKey []byte, cas uint32) (
+	value []byte, score int64, casReply uint32, err error) {
+	err = cc.do(tableId, rowKey, func(ctx *Context) error {
+		var err error
+		value, score, casReply, err = ctx.ZGet(tableId, rowKey, colKey, cas)
+		return err
+	})
+	return
+}
+
+// Set key/value in default column space.
+func (cc *ClusterContext) Set(tableId uint8, rowKey, colKey, value []byte,
+	score int64, cas uint32) error {
+	return cc.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.Set(tableId, rowKey, colKey, value, score, cas)
+	})
+}
+
+// Set key/value in "Z" sorted socre column space.
+func (cc *ClusterContext) ZSet(tableId uint8, rowKey, colKey, value []byte,
+	score int64, cas uint32) error {
+	return cc.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.ZSet(tableId, rowKey, colKey, value, score, cas)
+	})
+}
+
+// Delete the key in default column space.
+func (cc *ClusterContext) Del(tableId uint8, rowKey, colKey []byte,
+	cas uint32) error {
+	return cc.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.Del(tableId, rowKey, colKey, cas)
+	})
+}
+
+// Delete the key in "Z" sorted socre column space.
+func (cc *ClusterContext) ZDel(tableId uint8, rowKey, colKey []byte,
+	cas uint32) error {
+	return cc.do(tableId, rowKey, func(ctx *Context) error {
+		return ctx.ZDel(tableId, rowKey, colKey, cas)
+	})
+}
+
+// Increase key/score in default column space.
+func (cc *ClusterContext) Incr(tableId uint8, rowKey, colKey []byte, score int64,
+	cas uint32) (newValue []byte, newScore int64, err error) {
+	err = cc.do(tableId, rowKey, func(ctx *Context) error {
+		var err error
+		newValue, newScore, err = ctx.Incr(tableId, rowKey, colKey, score, cas)
+		return err
+	})
+	return
+}
+
+// Increase key/score in "Z" sorted socre column space.
+func (cc *ClusterContext) ZIncr(tableId uint8, rowKey, colKey []byte, score int64,
+	cas uint32) (newValue []byte, newScore int64, err error) {
+	err = cc.do(tableId, rowKey, func(ctx *Context) error {
+		var err error
+		newValue, newScore, err = ctx.ZIncr(tableId, rowKey, colKey, score, cas)
+		return err
+	})
+	return
+}
+
+// Scan columns of the selected rowKey in default column space.
+func (cc *ClusterContext) Scan(tableId uint8, rowKey, colKey []byte,
+	asc bool, num int) (r ScanReply, err error) {
+	err = cc.do(tableId, rowKey, func(ctx *Context) error {
+		var err error
+		r, err = ctx.Scan(tableId, rowKey, colKey, asc, num)
+		return err
+	})
+	return
+}
+
+// Scan columns of the selected rowKey in "Z" sorted score space.
+func (cc *ClusterContext) ZScan(tableId uint8, rowKey, colKey []byte, score int64,
+	asc, orderByScore bool, num int) (r ScanReply, err error) {
+	err = cc.do(tableId, rowKey, func(ctx *Context) error {
+		var err error
+		r, err = ctx.ZScan(tableId, rowKey, colKey, score, asc, orderByScore, num)
+		return err
+	})
+	return
+}
+
+// (Z)Scan more records.
+func (cc *ClusterContext) ScanMore(last ScanReply) (r ScanReply, err error) {
+	err = cc.do(last.TableId, last.RowKey, func(ctx *Context) error {
+		var err error
+		r, err = ctx.ScanMore(last)
+		return err
+	})
+	return
+}
+
+// A multi-key request of ClusterContext
+type clusterMulti struct {
+	num int
+	key func(i int) (tableId uint8, rowKey []byte)
+	// Send the args of indexes ids to ctx
+	send func(ctx *Context, ids []int, done chan *Call) (*Call, error)
+	// Save the reply of indexes ids, return the indexes replied EcMoved
+	save func(r interface{}, ids []int) (moved []int)
+}
+
+// Split the request by masters, send them in parallel and wait for all replies.
+// The keys replied EcMoved are sent again after the cluster map is refreshed.
+func (cc *ClusterContext) doMulti(m *clusterMulti) error {
+	var ids = make([]int, m.num)
+	for i := 0; i < m.num; i++ {
+		ids[i] = i
+	}
+
+	for retry := 0; ; retry++ {
+		var groups = make(map[*Client][]int)
+		for _, i := range ids {
+			tableId, rowKey := m.key(i)
+			cli, err := cc.c.getClient(ctrl.GetUnitId(cc.dbId, tableId, rowKey))
+			if err != nil {
+				return err
+			}
+			groups[cli] = append(groups[cli], i)
+		}
+
+		var done = make(chan *Call, len(groups))
+		var pending = make(map[*Call][]int)
+		var err error
+		for cli, gids := range groups {
+			var ctx = cli.NewContext(cc.dbId)
+			if len(cc.password) > 0 {
+				err = ctx.Auth(cc.password)
+				if err != nil {
+					break
+				}
+			}
+
+			var call *Call
+			call, err = m.send(ctx, gids, done)
+			if err != nil {
+				break
+			}
+			pending[call] = gids
+		}
+
+		var moved []int
+		for n := len(pending); n > 0; n-- {
+			var call = <-done
+			r, e := call.Reply()
+			if e != nil {
+				err = e
+				continue
+			}
+			moved = append(moved, m.save(r, pending[call])...)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(moved) == 0 || retry > 0 {
+			return nil
+		}
+
+		err = cc.c.Refresh()
+		if err != nil {
+			return err
+		}
+		ids = moved
+	}
+}
+
+func (cc *ClusterContext) mGet(zop bool, args MGetArgs) ([]GetReply, error) {
+	var reply = make([]GetReply, len(args))
+	var m clusterMulti
+	m.num = len(args)
+	m.key = func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}
+	m.send = func(ctx *Context, ids []int, done chan *Call) (*Call, error) {
+		var a = make(MGetArgs, len(ids))
+		for j, i := range ids {
+			a[j] = args[i]
+		}
+		if zop {
+			return ctx.GoZmGet(a, done)
+		}
+		return ctx.GoMGet(a, done)
+	}
+	m.save = func(r interface{}, ids []int) (moved []int) {
+		for j, t := range r.([]GetReply) {
+			reply[ids[j]] = t
+			if t.ErrCode == EcMoved {
+				moved = append(moved, ids[j])
+			}
+		}
+		return
+	}
+
+	err := cc.doMulti(&m)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (cc *ClusterContext) mSet(zop bool, args MSetArgs) ([]SetReply, error) {
+	var reply = make([]SetReply, len(args))
+	var m clusterMulti
+	m.num = len(args)
+	m.key = func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}
+	m.send = func(ctx *Context, ids []int, done chan *Call) (*Call, error) {
+		var a = make(MSetArgs, len(ids))
+		for j, i := range ids {
+			a[j] = args[i]
+		}
+		if zop {
+			return ctx.GoZmSet(a, done)
+		}
+		return ctx.GoMSet(a, done)
+	}
+	m.save = func(r interface{}, ids []int) (moved []int) {
+		for j, t := range r.([]SetReply) {
+			reply[ids[j]] = t
+			if t.ErrCode == EcMoved {
+				moved = append(moved, ids[j])
+			}
+		}
+		return
+	}
+
+	err := cc.doMulti(&m)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (cc *ClusterContext) mDel(zop bool, args MDelArgs) ([]DelReply, error) {
+	var reply = make([]DelReply, len(args))
+	var m clusterMulti
+	m.num = len(args)
+	m.key = func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}
+	m.send = func(ctx *Context, ids []int, done chan *Call) (*Call, error) {
+		var a = make(MDelArgs, len(ids))
+		for j, i := range ids {
+			a[j] = args[i]
+		}
+		if zop {
+			return ctx.GoZmDel(a, done)
+		}
+		return ctx.GoMDel(a, done)
+	}
+	m.save = func(r interface{}, ids []int) (moved []int) {
+		for j, t := range r.([]DelReply) {
+			reply[ids[j]] = t
+			if t.ErrCode == EcMoved {
+				moved = append(moved, ids[j])
+			}
+		}
+		return
+	}
+
+	err := cc.doMulti(&m)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (cc *ClusterContext) mIncr(zop bool, args MIncrArgs) ([]IncrReply, error) {
+	var reply = make([]IncrReply, len(args))
+	var m clusterMulti
+	m.num = len(args)
+	m.key = func(i int) (uint8, []byte) {
+		return args[i].TableId, args[i].RowKey
+	}
+	m.send = func(ctx *Context, ids []int, done chan *Call) (*Call, error) {
+		var a = make(MIncrArgs, len(ids))
+		for j, i := range ids {
+			a[j] = args[i]
+		}
+		if zop {
+			return ctx.GoZmIncr(a, done)
+		}
+		return ctx.GoMIncr(a, done)
+	}
+	m.save = func(r interface{}, ids []int) (moved []int) {
+		for j, t := range r.([]IncrReply) {
+			reply[ids[j]] = t
+			if t.ErrCode == EcMoved {
+				moved = append(moved, ids[j])
+			}
+		}
+		return
+	}
+
+	err := cc.doMulti(&m)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (cc *ClusterContext) MGet(args MGetArgs) ([]GetReply, error) {
+	return cc.mGet(false, args)
+}
+
+func (cc *ClusterContext) ZmGet(args MGetArgs) ([]GetReply, error) {
+	return cc.mGet(true, args)
+}
+
+func (cc *ClusterContext) MSet(args MSetArgs) ([]SetReply, error) {
+	return cc.mSet(false, args)
+}
+
+func (cc *ClusterContext) ZmSet(args MSetArgs) ([]SetReply, error) {
+	return cc.mSet(true, args)
+}
+
+func (cc *ClusterContext) MDel(args MDelArgs) ([]DelReply, error) {
+	return cc.mDel(false, args)
+}
+
+func (cc *ClusterContext) ZmDel(args MDelArgs) ([]DelReply, error) {
+	return cc.mDel(true, args)
+}
+
+func (cc *ClusterContext) MIncr(args MIncrArgs) ([]IncrReply, error) {
+	return cc.mIncr(false, args)
+}
+
+func (cc *ClusterContext) ZmIncr(args MIncrArgs) ([]IncrReply, error) {
+	return cc.mIncr(true, args)
+}
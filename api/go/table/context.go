@@ -16,15 +16,21 @@ package table
 
 import (
 	"errors"
+	"fmt"
 	"github.com/stevejiang/gotable/api/go/table/proto"
 	"github.com/stevejiang/gotable/ctrl"
+	"sync/atomic"
+	"time"
 )
 
 // Connection Context to GoTable server.
 // It's safe to use in multiple goroutines.
 type Context struct {
-	cli  *Client
-	dbId uint8
+	cli     *Client
+	dbId    uint8
+	pkgFlag uint8    // Extra PkgFlag of write requests
+	atomic  bool     // Atomic MSet/MDel
+	sess    *Session // Session consistency if not nil
 }
 
 type Call struct {
@@ -34,7 +40,8 @@ type Call struct {
 	pkg   []byte
 	seq   uint64
 	cmd   uint8
-	ready bool // Ready to invoke Reply?
+	ready bool     // Ready to invoke Reply?
+	sess  *Session // Session to record the binlog seq of the write
 }
 
 // Get the underling connection Client of the Context.
@@ -47,6 +54,84 @@ func (c *Context) DatabaseId() uint8 {
 	return c.dbId
 }
 
+// SemiSync returns a copy of the Context whose writes are replied only after
+// they are applied by at least min_sync_slavers (server config) slavers.
+// ErrNoQuorum is returned if not enough slavers applied in time, but the
+// writes have succeeded on master.
+func (c *Context) SemiSync() *Context {
+	var sc = *c
+	sc.pkgFlag |= proto.FlagSemiSync
+	return &sc
+}
+
+// Atomic returns a copy of the Context whose MSet/MDel (and ZmSet/ZmDel)
+// write all KVs or none of them. All KVs are checked first, if any of them
+// fails, nothing is written, and the other KVs fail with EcAborted.
+func (c *Context) Atomic() *Context {
+	var sc = *c
+	sc.atomic = true
+	return &sc
+}
+
+// A Session provides read-your-writes consistency across Contexts, such as
+// one Context writing to master and another one reading from a slaver.
+// It records the binlog seq of the writes, and reads are served by the
+// server after the writes have been applied, or fail with ErrNotApplied.
+// It's safe to use in multiple goroutines.
+type Session struct {
+	lastSeq uint64
+}
+
+func NewSession() *Session {
+	return new(Session)
+}
+
+// LastSeq returns the binlog seq of the last write in the session.
+// It can be saved as a token, and restored by Update in another process.
+func (s *Session) LastSeq() uint64 {
+	return atomic.LoadUint64(&s.lastSeq)
+}
+
+// Update raises the last binlog seq of the session to seq.
+func (s *Session) Update(seq uint64) {
+	for {
+		var old = atomic.LoadUint64(&s.lastSeq)
+		if seq <= old || atomic.CompareAndSwapUint64(&s.lastSeq, old, seq) {
+			return
+		}
+	}
+}
+
+// WithSession returns a copy of the Context bound to the session.
+// Writes of the Context are recorded in the session, and Get/MGet/Scan wait
+// until the writes of the session are applied on the server.
+func (c *Context) WithSession(s *Session) *Context {
+	var sc = *c
+	sc.sess = s
+	return &sc
+}
+
+// setLogSeq asks for the binlog seq of writes, and sets the min binlog seq
+// of reads in the session.
+func (c *Context) setLogSeq(call *Call, pkgFlag *uint8, logSeq *uint64) {
+	if c.sess == nil {
+		return
+	}
+
+	switch call.cmd {
+	case proto.CmdGet, proto.CmdMGet, proto.CmdScan:
+		if seq := c.sess.LastSeq(); seq > 0 {
+			*pkgFlag |= proto.FlagLogSeq
+			*logSeq = seq
+		}
+	default:
+		if isWriteCmd(call.cmd) {
+			*pkgFlag |= proto.FlagLogSeq
+			call.sess = c.sess
+		}
+	}
+}
+
 func (c *Context) Auth(password string) error {
 	if c.cli.isAuthorized(c.dbId) {
 		return nil
@@ -393,6 +478,10 @@ func (c *Context) goOneOp(zop bool, cmd, tableId uint8,
 	if zop {
 		p.PkgFlag |= proto.FlagZop
 	}
+	if isWriteCmd(cmd) {
+		p.PkgFlag |= c.pkgFlag
+	}
+	c.setLogSeq(call, &p.PkgFlag, &p.LogSeq)
 
 	var pkgLen = p.Length()
 	if pkgLen > proto.MaxPkgLen {
@@ -413,6 +502,15 @@ func (c *Context) goOneOp(zop bool, cmd, tableId uint8,
 	return call, nil
 }
 
+func isWriteCmd(cmd uint8) bool {
+	switch cmd {
+	case proto.CmdSet, proto.CmdMSet, proto.CmdDel, proto.CmdMDel,
+		proto.CmdIncr, proto.CmdMIncr:
+		return true
+	}
+	return false
+}
+
 func (c *Context) GoPing(done chan *Call) (*Call, error) {
 	return c.goOneOp(false, proto.CmdPing, 0, nil, nil, nil, 0, 0, done)
 }
@@ -474,6 +572,13 @@ func (c *Context) goMultiOp(zop bool, args multiArgs, cmd uint8,
 	if zop {
 		p.PkgFlag |= proto.FlagZop
 	}
+	if isWriteCmd(cmd) {
+		p.PkgFlag |= c.pkgFlag
+	}
+	if c.atomic && (cmd == proto.CmdMSet || cmd == proto.CmdMDel) {
+		p.PkgFlag |= proto.FlagAtomic
+	}
+	c.setLogSeq(call, &p.PkgFlag, &p.LogSeq)
 
 	p.Kvs = make([]proto.KeyValue, args.length())
 	args.toKV(p.Kvs)
@@ -566,6 +671,7 @@ func (c *Context) goScan(zop bool, tableId uint8, rowKey, colKey []byte,
 			p.SetColSpace(proto.ColSpaceScore2)
 		}
 	}
+	c.setLogSeq(call, &p.PkgFlag, &p.LogSeq)
 
 	var pkgLen = p.Length()
 	if pkgLen > proto.MaxPkgLen {
@@ -693,6 +799,17 @@ func (c *CtrlContext) SlaveOf(host string) error {
 // Internal control command.
 // Migrate moves one unit data to another server on the fly.
 func (c *CtrlContext) Migrate(host string, unitId uint16) error {
+	return c.MigrateUnits(host, ctrl.UnitSet{unitId})
+}
+
+// Internal control command.
+// MigrateUnits moves the units data to another server in one migration.
+// Send it to the new server, host is the old server.
+func (c *CtrlContext) MigrateUnits(host string, units ctrl.UnitSet) error {
+	if len(units) == 0 {
+		return errors.New("no unit to migrate")
+	}
+
 	call := c.cli.newCall(proto.CmdMigrate, nil)
 	if call.err != nil {
 		return call.err
@@ -701,7 +818,45 @@ func (c *CtrlContext) Migrate(host string, unitId uint16) error {
 	var p ctrl.PkgMigrate
 	p.ClientReq = true
 	p.MasterAddr = host
+	p.Units = ctrl.NewUnitSet(units)
+	p.UnitId = p.Units[0]
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgMigrate)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// CancelMigrate stops migrating the unit to this server, and deletes the
+// migrated data of all units in the migration. Send it to the new server.
+// Send it to the old server after that to roll back a cutover not switched
+// in time, the units are writable on the old server again.
+func (c *CtrlContext) CancelMigrate(unitId uint16) error {
+	call := c.cli.newCall(proto.CmdMigrate, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	var p ctrl.PkgMigrate
+	p.ClientReq = true
 	p.UnitId = unitId
+	p.Cancel = true
 
 	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
 	if err != nil {
@@ -724,6 +879,107 @@ func (c *CtrlContext) Migrate(host string, unitId uint16) error {
 	return nil
 }
 
+// Internal control command.
+// Cutover finishes migrating the unit. Send it to the old server, which
+// refuses writes to the unit, waits for the new server to catch up in timeout,
+// switches the new server to normal status and deletes the unit data.
+// If timeout is 0, the server default is used.
+func (c *CtrlContext) Cutover(unitId uint16, timeout time.Duration) error {
+	call := c.cli.newCall(proto.CmdCutover, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	var p ctrl.PkgCutover
+	p.UnitId = unitId
+	p.Timeout = int(timeout / time.Millisecond)
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgCutover)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// MigStatus reads the progress of migrating the unit from the old server.
+// All units of the same migration share the progress.
+func (c *CtrlContext) MigStatus(unitId uint16) (ctrl.SlaverSync, error) {
+	st, err := c.ReplStatus()
+	if err != nil {
+		return ctrl.SlaverSync{}, err
+	}
+
+	for _, s := range st.Slavers {
+		if s.Migration && s.Units.Has(unitId) {
+			return s, nil
+		}
+	}
+	return ctrl.SlaverSync{}, fmt.Errorf("unit %d is not under migration", unitId)
+}
+
+// Internal control command.
+// GetCluster reads the cluster routing table from the config server.
+func (c *CtrlContext) GetCluster() (ctrl.ClusterMap, error) {
+	var p ctrl.PkgCluster
+	err := c.doCluster(&p)
+	return p.Map, err
+}
+
+// Internal control command.
+// SetCluster replaces the cluster routing table on the config server or the
+// master of a data node group, and returns the new version.
+// Admin privilege is required.
+func (c *CtrlContext) SetCluster(m ctrl.ClusterMap) (uint64, error) {
+	var p ctrl.PkgCluster
+	p.Set = true
+	p.Map = m
+	err := c.doCluster(&p)
+	return p.Map.Version, err
+}
+
+func (c *CtrlContext) doCluster(p *ctrl.PkgCluster) error {
+	call := c.cli.newCall(proto.CmdCluster, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgCluster)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	*p = *t
+	return nil
+}
+
 // Internal control command.
 // SlaverStatus reads migration/slaver status.
 func (c *CtrlContext) SlaverStatus(migration bool, unitId uint16) (int, error) {
@@ -760,13 +1016,24 @@ func (c *CtrlContext) SlaverStatus(migration bool, unitId uint16) (int, error) {
 // Internal control command.
 // DelUnit deletes one unit data.
 func (c *CtrlContext) DelUnit(unitId uint16) error {
+	return c.DelUnits(ctrl.UnitSet{unitId})
+}
+
+// Internal control command.
+// DelUnits deletes the units data, such as units of a failed migration.
+func (c *CtrlContext) DelUnits(units ctrl.UnitSet) error {
+	if len(units) == 0 {
+		return errors.New("no unit to delete")
+	}
+
 	call := c.cli.newCall(proto.CmdDelUnit, nil)
 	if call.err != nil {
 		return call.err
 	}
 
 	var p ctrl.PkgDelUnit
-	p.UnitId = unitId
+	p.Units = ctrl.NewUnitSet(units)
+	p.UnitId = p.Units[0]
 
 	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
 	if err != nil {
@@ -789,6 +1056,391 @@ func (c *CtrlContext) DelUnit(unitId uint16) error {
 	return nil
 }
 
+// Internal control command.
+// DropTable deletes all data of the table in the context DB, on this server
+// and all of its slavers. Admin privilege is required.
+func (c *CtrlContext) DropTable(tableId uint8) error {
+	var p ctrl.PkgDrop
+	p.TableId = tableId
+	return c.doDrop(&p)
+}
+
+// Internal control command.
+// DropDB deletes all data of the context DB, on this server and all of its
+// slavers. Admin privilege is required.
+func (c *CtrlContext) DropDB() error {
+	var p ctrl.PkgDrop
+	p.DropDB = true
+	return c.doDrop(&p)
+}
+
+func (c *CtrlContext) doDrop(p *ctrl.PkgDrop) error {
+	call := c.cli.newCall(proto.CmdDrop, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return call.err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgDrop)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// GetCatalog gets the table catalog.
+func (c *CtrlContext) GetCatalog() (ctrl.Catalog, error) {
+	var p ctrl.PkgCatalog
+	err := c.doCatalog(&p)
+	return p.Catalog, err
+}
+
+// Internal control command.
+// SetTableInfo adds the table to catalog or replaces the old one.
+// Admin privilege is required.
+func (c *CtrlContext) SetTableInfo(t ctrl.TableInfo) error {
+	var p ctrl.PkgCatalog
+	p.Set = true
+	p.Table = t
+	return c.doCatalog(&p)
+}
+
+// Internal control command.
+// SetDBInfo adds the DB to catalog or replaces the old one.
+// Admin privilege is required.
+func (c *CtrlContext) SetDBInfo(d ctrl.DBInfo) error {
+	var p ctrl.PkgCatalog
+	p.Set = true
+	p.IsDB = true
+	p.DB = d
+	return c.doCatalog(&p)
+}
+
+// Internal control command.
+// DelDBInfo removes the DB from catalog, the data is not touched.
+// Admin privilege is required.
+func (c *CtrlContext) DelDBInfo(dbId uint8) error {
+	var p ctrl.PkgCatalog
+	p.Del = true
+	p.IsDB = true
+	p.DB.DbId = dbId
+	return c.doCatalog(&p)
+}
+
+// Internal control command.
+// DelTableInfo removes the table from catalog, the data is not touched.
+// Admin privilege is required.
+func (c *CtrlContext) DelTableInfo(dbId, tableId uint8) error {
+	var p ctrl.PkgCatalog
+	p.Del = true
+	p.Table.DbId = dbId
+	p.Table.TableId = tableId
+	return c.doCatalog(&p)
+}
+
+func (c *CtrlContext) doCatalog(p *ctrl.PkgCatalog) error {
+	call := c.cli.newCall(proto.CmdCatalog, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return call.err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgCatalog)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	p.Catalog = t.Catalog
+
+	// The reply has the changed catalog, so names resolved later are not stale
+	if p.Set || p.Del {
+		c.cli.mtx.Lock()
+		c.cli.catalog = &t.Catalog
+		c.cli.mtx.Unlock()
+	}
+	return nil
+}
+
+// Internal control command.
+// ListTables lists the tables with data or in catalog of the context DB,
+// or of all DBs if allDB is true (admin privilege is required).
+// Size and number of keys of every table are estimated.
+func (c *CtrlContext) ListTables(allDB bool) ([]ctrl.TableStats, error) {
+	var p ctrl.PkgTableStats
+	p.AllDB = allDB
+	p.AllTable = true
+	err := c.doTableStats(&p)
+	return p.Tables, err
+}
+
+// Internal control command.
+// TableStats estimates size and number of keys of the table.
+func (c *CtrlContext) TableStats(tableId uint8) (ctrl.TableStats, error) {
+	var p ctrl.PkgTableStats
+	p.TableId = tableId
+	err := c.doTableStats(&p)
+	if err != nil {
+		return ctrl.TableStats{}, err
+	}
+	if len(p.Tables) != 1 {
+		return ctrl.TableStats{}, errors.New("invalid table stats reply")
+	}
+	return p.Tables[0], nil
+}
+
+func (c *CtrlContext) doTableStats(p *ctrl.PkgTableStats) error {
+	call := c.cli.newCall(proto.CmdTableSt, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return call.err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgTableStats)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	p.Tables = t.Tables
+	return nil
+}
+
+// Internal control command.
+// CompactDB runs manual compaction over the context DB on the server.
+// It returns when the compaction finishes. Admin privilege is required.
+func (c *CtrlContext) CompactDB() error {
+	var p ctrl.PkgCompact
+	p.Scope = ctrl.CompactDB
+	return c.doCompact(&p)
+}
+
+// Internal control command.
+// CompactTable runs manual compaction over the table of the context DB.
+// Admin privilege is required.
+func (c *CtrlContext) CompactTable(tableId uint8) error {
+	var p ctrl.PkgCompact
+	p.Scope = ctrl.CompactTable
+	p.TableId = tableId
+	return c.doCompact(&p)
+}
+
+// Internal control command.
+// CompactUnits runs manual compaction over units [startUnitId, endUnitId].
+// Admin privilege is required.
+func (c *CtrlContext) CompactUnits(startUnitId, endUnitId uint16) error {
+	var p ctrl.PkgCompact
+	p.Scope = ctrl.CompactUnits
+	p.StartUnit = startUnitId
+	p.EndUnit = endUnitId
+	return c.doCompact(&p)
+}
+
+// Internal control command.
+// CompactAll runs manual compaction over all data on the server.
+// Admin privilege is required.
+func (c *CtrlContext) CompactAll() error {
+	var p ctrl.PkgCompact
+	p.Scope = ctrl.CompactAll
+	return c.doCompact(&p)
+}
+
+func (c *CtrlContext) doCompact(p *ctrl.PkgCompact) error {
+	call := c.cli.newCall(proto.CmdCompact, nil)
+	if call.err != nil {
+		return call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return call.err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return err
+	}
+
+	t := r.(*ctrl.PkgCompact)
+	if t.ErrMsg != "" {
+		return errors.New(t.ErrMsg)
+	}
+	return nil
+}
+
+// Internal control command.
+// GetProperty gets the RocksDB property of every column family, such as
+// "rocksdb.stats", "rocksdb.levelstats" and "rocksdb.estimate-num-keys".
+// Admin privilege is required.
+func (c *CtrlContext) GetProperty(name string) ([]ctrl.PropertyValue, error) {
+	call := c.cli.newCall(proto.CmdProperty, nil)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var p ctrl.PkgProperty
+	p.Name = name
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return nil, call.err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.(*ctrl.PkgProperty)
+	if t.ErrMsg != "" {
+		return nil, errors.New(t.ErrMsg)
+	}
+	return t.Values, nil
+}
+
+// Internal control command.
+// Backup creates an online consistent backup in dir on the server side.
+// It returns the binlog seq of the backup.
+func (c *CtrlContext) Backup(dir string) (uint64, error) {
+	call := c.cli.newCall(proto.CmdBackup, nil)
+	if call.err != nil {
+		return 0, call.err
+	}
+
+	var p ctrl.PkgBackup
+	p.Dir = dir
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return 0, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return 0, err
+	}
+
+	t := r.(*ctrl.PkgBackup)
+	if t.ErrMsg != "" {
+		return 0, errors.New(t.ErrMsg)
+	}
+	return t.LastSeq, nil
+}
+
+// Internal control command.
+// ReplStatus reads replication status of the server, including the status
+// of connecting to master and every connected slaver.
+func (c *CtrlContext) ReplStatus() (ctrl.PkgReplStatus, error) {
+	var p ctrl.PkgReplStatus
+	call := c.cli.newCall(proto.CmdReplSt, nil)
+	if call.err != nil {
+		return p, call.err
+	}
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return p, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return p, err
+	}
+
+	t := r.(*ctrl.PkgReplStatus)
+	if t.ErrMsg != "" {
+		return p, errors.New(t.ErrMsg)
+	}
+	return *t, nil
+}
+
+// Internal control command.
+// Promote turns a normal slaver into master, and returns the last binlog seq.
+// The slaver must have caught up with its master (SlaverReady), unless force
+// is true. Other slavers of the old master can SlaveOf the promoted server
+// and continue incremental sync.
+func (c *CtrlContext) Promote(force bool) (uint64, error) {
+	call := c.cli.newCall(proto.CmdPromote, nil)
+	if call.err != nil {
+		return 0, call.err
+	}
+
+	var p ctrl.PkgPromote
+	p.Force = force
+
+	pkg, err := ctrl.Encode(call.cmd, c.dbId, call.seq, &p)
+	if err != nil {
+		c.cli.errCall(call, err)
+		return 0, err
+	}
+
+	call.pkg = pkg
+	c.cli.sending <- call
+
+	r, err := (<-call.Done).Reply()
+	if err != nil {
+		return 0, err
+	}
+
+	t := r.(*ctrl.PkgPromote)
+	if t.ErrMsg != "" {
+		return 0, errors.New(t.ErrMsg)
+	}
+	return t.LastSeq, nil
+}
+
 func replyGet(call *Call, err error) ([]byte, int64, uint32, error) {
 	if err != nil {
 		return nil, 0, 0, err
@@ -999,6 +1651,26 @@ func (call *Call) Reply() (interface{}, error) {
 		return call.replyInnerCtrl(&ctrl.PkgSlaverStatus{})
 	case proto.CmdDelUnit:
 		return call.replyInnerCtrl(&ctrl.PkgDelUnit{})
+	case proto.CmdBackup:
+		return call.replyInnerCtrl(&ctrl.PkgBackup{})
+	case proto.CmdReplSt:
+		return call.replyInnerCtrl(&ctrl.PkgReplStatus{})
+	case proto.CmdPromote:
+		return call.replyInnerCtrl(&ctrl.PkgPromote{})
+	case proto.CmdCutover:
+		return call.replyInnerCtrl(&ctrl.PkgCutover{})
+	case proto.CmdCluster:
+		return call.replyInnerCtrl(&ctrl.PkgCluster{})
+	case proto.CmdDrop:
+		return call.replyInnerCtrl(&ctrl.PkgDrop{})
+	case proto.CmdCatalog:
+		return call.replyInnerCtrl(&ctrl.PkgCatalog{})
+	case proto.CmdTableSt:
+		return call.replyInnerCtrl(&ctrl.PkgTableStats{})
+	case proto.CmdCompact:
+		return call.replyInnerCtrl(&ctrl.PkgCompact{})
+	case proto.CmdProperty:
+		return call.replyInnerCtrl(&ctrl.PkgProperty{})
 	}
 
 	return nil, ErrUnknownCmd
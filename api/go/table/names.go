@@ -0,0 +1,176 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package table
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/ctrl"
+)
+
+// A table of a context resolved by name.
+// The methods are the same as Context, without the tableId parameter.
+type Table struct {
+	ctx     *Context
+	tableId uint8
+	name    string
+}
+
+// NewContextByName creates a context of the DB with the name in catalog.
+// The catalog is read from server once and cached in the client.
+func (c *Client) NewContextByName(name string) (*Context, error) {
+	var find = func(cat *ctrl.Catalog) bool {
+		return cat.FindDBName(name) != nil
+	}
+	cat, err := c.getCatalog(find)
+	if err != nil {
+		return nil, err
+	}
+
+	var d = cat.FindDBName(name)
+	if d == nil {
+		return nil, fmt.Errorf("DB %s not found in catalog", name)
+	}
+	return c.NewContext(d.DbId), nil
+}
+
+// RefreshCatalog reads the catalog from server again.
+// Names resolved before are not changed.
+func (c *Client) RefreshCatalog() error {
+	_, err := c.getCatalog(nil)
+	return err
+}
+
+// getCatalog returns the cached catalog, or reads it from server if not
+// cached, or find returns false on the cached one.
+// The cache is replaced when the catalog is changed by the client.
+func (c *Client) getCatalog(find func(*ctrl.Catalog) bool) (*ctrl.Catalog, error) {
+	c.mtx.Lock()
+	var cat = c.catalog
+	c.mtx.Unlock()
+	if cat != nil && find != nil && find(cat) {
+		return cat, nil
+	}
+
+	var cc = CtrlContext(*c.NewContext(0))
+	newCat, err := cc.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.catalog = &newCat
+	c.mtx.Unlock()
+	return &newCat, nil
+}
+
+// Table resolves the table of the context DB by name in catalog.
+// The catalog is read from server once and cached in the client.
+func (c *Context) Table(name string) (*Table, error) {
+	var find = func(cat *ctrl.Catalog) bool {
+		return cat.FindName(c.dbId, name) != nil
+	}
+	cat, err := c.cli.getCatalog(find)
+	if err != nil {
+		return nil, err
+	}
+
+	var t = cat.FindName(c.dbId, name)
+	if t == nil {
+		return nil, fmt.Errorf("table %s of DB %d not found in catalog",
+			name, c.dbId)
+	}
+	return &Table{c, t.TableId, name}, nil
+}
+
+// Context returns the context of the table.
+func (t *Table) Context() *Context {
+	return t.ctx
+}
+
+// TableId returns the id of the table.
+func (t *Table) TableId() uint8 {
+	return t.tableId
+}
+
+// Name returns the name of the table.
+func (t *Table) Name() string {
+	return t.name
+}
+
+func (t *Table) Get(rowKey, colKey []byte, cas uint32) (
+	value []byte, score int64, casReply uint32, err error) {
+	return t.ctx.Get(t.tableId, rowKey, colKey, cas)
+}
+
+func (t *Table) ZGet(rowKey, colKey []byte, cas uint32) (
+	value []byte, score int64, casReply uint32, err error) {
+	return t.ctx.ZGet(t.tableId, rowKey, colKey, cas)
+}
+
+func (t *Table) Set(rowKey, colKey, value []byte, score int64,
+	cas uint32) error {
+	return t.ctx.Set(t.tableId, rowKey, colKey, value, score, cas)
+}
+
+func (t *Table) ZSet(rowKey, colKey, value []byte, score int64,
+	cas uint32) error {
+	return t.ctx.ZSet(t.tableId, rowKey, colKey, value, score, cas)
+}
+
+func (t *Table) Del(rowKey, colKey []byte, cas uint32) error {
+	return t.ctx.Del(t.tableId, rowKey, colKey, cas)
+}
+
+func (t *Table) ZDel(rowKey, colKey []byte, cas uint32) error {
+	return t.ctx.ZDel(t.tableId, rowKey, colKey, cas)
+}
+
+func (t *Table) Incr(rowKey, colKey []byte, score int64,
+	cas uint32) (newValue []byte, newScore int64, err error) {
+	return t.ctx.Incr(t.tableId, rowKey, colKey, score, cas)
+}
+
+func (t *Table) ZIncr(rowKey, colKey []byte, score int64,
+	cas uint32) (newValue []byte, newScore int64, err error) {
+	return t.ctx.ZIncr(t.tableId, rowKey, colKey, score, cas)
+}
+
+func (t *Table) Scan(rowKey, colKey []byte, asc bool,
+	num int) (ScanReply, error) {
+	return t.ctx.Scan(t.tableId, rowKey, colKey, asc, num)
+}
+
+func (t *Table) ScanStart(rowKey []byte, asc bool,
+	num int) (ScanReply, error) {
+	return t.ctx.ScanStart(t.tableId, rowKey, asc, num)
+}
+
+func (t *Table) ZScan(rowKey, colKey []byte, score int64,
+	asc, orderByScore bool, num int) (ScanReply, error) {
+	return t.ctx.ZScan(t.tableId, rowKey, colKey, score, asc, orderByScore, num)
+}
+
+func (t *Table) ZScanStart(rowKey []byte, asc, orderByScore bool,
+	num int) (ScanReply, error) {
+	return t.ctx.ZScanStart(t.tableId, rowKey, asc, orderByScore, num)
+}
+
+func (t *Table) ScanMore(last ScanReply) (ScanReply, error) {
+	return t.ctx.ScanMore(last)
+}
+
+func (t *Table) Dump() (DumpReply, error) {
+	return t.ctx.DumpTable(t.tableId)
+}
@@ -96,7 +96,12 @@ func (kv *KeyValue) SetValue(value []byte) {
 // PkgFlag
 const (
 	// Common flags
-	FlagZop = 0x1 // if set, it is a "Z" op
+	FlagZop      = 0x1  // if set, it is a "Z" op
+	FlagSemiSync = 0x2  // if set, reply write after applied by enough slavers
+	FlagLogSeq   = 0x20 // if set, ddwLogSeq follows cPkgFlag
+
+	// MSet/MDel flags
+	FlagAtomic = 0x4 // if set, write all KVs or none of them
 
 	// (Z)Scan flags
 	FlagScanAsc      = 0x4  // if set, Scan in ASC order, else DESC order
@@ -110,17 +115,19 @@ const (
 )
 
 // Get, Set, Del, GetSet, GetDel, ZGet, ZSet, Sync
-// PKG=HEAD+cPkgFlag+KeyValue
+// PKG=HEAD+cPkgFlag+[ddwLogSeq]+KeyValue
 type PkgOneOp struct {
 	PkgHead
 	PkgFlag uint8
+	LogSeq  uint64 // Only if FlagLogSeq is set, see OverWriteLogSeq
 	KeyValue
 }
 
 // MGet, MSet, MDel, MZGet, MZSet, MZDel
-// PKG=HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]
+// PKG=HEAD+cPkgFlag+[ddwLogSeq]+cErrCode+wNum+KeyValue[wNum]
 type PkgMultiOp struct {
 	PkgFlag uint8
+	LogSeq  uint64 // Only if FlagLogSeq is set, see OverWriteLogSeq
 	ErrCode int8
 	PkgHead
 	Kvs []KeyValue
@@ -319,9 +326,56 @@ func (kv *KeyValue) Decode(pkg []byte) (int, error) {
 	return n, nil
 }
 
+// The binlog seq of a write is returned to client in the response if the
+// request has FlagLogSeq set. A read request with FlagLogSeq set and LogSeq
+// not 0 is served after the binlog seq has been applied.
+func OverWriteLogSeq(pkg []byte, logSeq uint64) {
+	if len(pkg) >= HeadSize+9 && pkg[HeadSize]&FlagLogSeq != 0 {
+		binary.BigEndian.PutUint64(pkg[HeadSize+1:], logSeq)
+	}
+}
+
+// GetLogSeq returns the LogSeq of PkgOneOp or PkgMultiOp, 0 if not set.
+func GetLogSeq(pkg []byte) uint64 {
+	if len(pkg) >= HeadSize+9 && pkg[HeadSize]&FlagLogSeq != 0 {
+		return binary.BigEndian.Uint64(pkg[HeadSize+1:])
+	}
+	return 0
+}
+
+func logSeqLength(pkgFlag uint8) int {
+	if pkgFlag&FlagLogSeq != 0 {
+		return 8
+	}
+	return 0
+}
+
+func encodeLogSeq(pkg []byte, pkgFlag uint8, logSeq uint64) (int, error) {
+	if pkgFlag&FlagLogSeq == 0 {
+		return 0, nil
+	}
+	if len(pkg) < 8 {
+		return 0, ErrPkgLen
+	}
+	binary.BigEndian.PutUint64(pkg, logSeq)
+	return 8, nil
+}
+
+func decodeLogSeq(pkg []byte, pkgFlag uint8, logSeq *uint64) (int, error) {
+	if pkgFlag&FlagLogSeq == 0 {
+		*logSeq = 0
+		return 0, nil
+	}
+	if len(pkg) < 8 {
+		return 0, ErrPkgLen
+	}
+	*logSeq = binary.BigEndian.Uint64(pkg)
+	return 8, nil
+}
+
 func (p *PkgOneOp) Length() int {
-	// PKG = HEAD+cPkgFlag+KeyValue
-	return HeadSize + 1 + p.KeyValue.Length()
+	// PKG = HEAD+cPkgFlag+[ddwLogSeq]+KeyValue
+	return HeadSize + 1 + logSeqLength(p.PkgFlag) + p.KeyValue.Length()
 }
 
 func (p *PkgOneOp) Encode(pkg []byte) (int, error) {
@@ -336,7 +390,13 @@ func (p *PkgOneOp) Encode(pkg []byte) (int, error) {
 	pkg[n] = p.PkgFlag
 	n += 1
 
-	m, err := p.KeyValue.Encode(pkg[n:])
+	m, err := encodeLogSeq(pkg[n:], p.PkgFlag, p.LogSeq)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	m, err = p.KeyValue.Encode(pkg[n:])
 	if err != nil {
 		return n, err
 	}
@@ -358,7 +418,13 @@ func (p *PkgOneOp) Decode(pkg []byte) (int, error) {
 	p.PkgFlag = pkg[n]
 	n += 1
 
-	m, err := p.KeyValue.Decode(pkg[n:])
+	m, err := decodeLogSeq(pkg[n:], p.PkgFlag, &p.LogSeq)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	m, err = p.KeyValue.Decode(pkg[n:])
 	if err != nil {
 		return n, err
 	}
@@ -368,8 +434,8 @@ func (p *PkgOneOp) Decode(pkg []byte) (int, error) {
 }
 
 func (p *PkgMultiOp) Length() int {
-	// PKG = HEAD+cPkgFlag+cErrCode+wNum+KeyValue[wNum]
-	var n = HeadSize + 4
+	// PKG = HEAD+cPkgFlag+[ddwLogSeq]+cErrCode+wNum+KeyValue[wNum]
+	var n = p.HeadLength()
 	for i := 0; i < len(p.Kvs); i++ {
 		n += p.Kvs[i].Length()
 	}
@@ -380,8 +446,15 @@ func (p *PkgMultiOp) SetErrCode(errCode int8) {
 	p.ErrCode = errCode
 }
 
-func (p *PkgMultiOp) Encode(pkg []byte) (int, error) {
-	var numKvs = len(p.Kvs)
+// HeadLength returns the package length without KeyValues.
+func (p *PkgMultiOp) HeadLength() int {
+	return HeadSize + 4 + logSeqLength(p.PkgFlag)
+}
+
+// EncodeHead encodes the package except KeyValues. numKvs KeyValues
+// should be encoded after it by the caller, and then OverWriteLen.
+// So KeyValues can be encoded one by one without building Kvs.
+func (p *PkgMultiOp) EncodeHead(pkg []byte, numKvs int) (int, error) {
 	if numKvs > MaxUint16 {
 		return 0, ErrKvArrayLen
 	}
@@ -391,16 +464,36 @@ func (p *PkgMultiOp) Encode(pkg []byte) (int, error) {
 		return n, err
 	}
 
-	if n+4 > len(pkg) {
+	if n+1 > len(pkg) {
 		return 0, ErrPkgLen
 	}
 	pkg[n] = p.PkgFlag
 	n += 1
+
+	m, err := encodeLogSeq(pkg[n:], p.PkgFlag, p.LogSeq)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	if n+3 > len(pkg) {
+		return 0, ErrPkgLen
+	}
 	pkg[n] = uint8(p.ErrCode)
 	n += 1
 	binary.BigEndian.PutUint16(pkg[n:], uint16(numKvs))
 	n += 2
 
+	return n, nil
+}
+
+func (p *PkgMultiOp) Encode(pkg []byte) (int, error) {
+	var numKvs = len(p.Kvs)
+	n, err := p.EncodeHead(pkg, numKvs)
+	if err != nil {
+		return n, err
+	}
+
 	for i := 0; i < numKvs; i++ {
 		m, err := p.Kvs[i].Encode(pkg[n:])
 		if err != nil {
@@ -419,11 +512,21 @@ func (p *PkgMultiOp) Decode(pkg []byte) (int, error) {
 		return 0, err
 	}
 
-	if n+4 > len(pkg) {
+	if n+1 > len(pkg) {
 		return n, ErrPkgLen
 	}
 	p.PkgFlag = pkg[n]
 	n += 1
+
+	m, err := decodeLogSeq(pkg[n:], p.PkgFlag, &p.LogSeq)
+	if err != nil {
+		return n, err
+	}
+	n += m
+
+	if n+3 > len(pkg) {
+		return n, ErrPkgLen
+	}
 	p.ErrCode = int8(pkg[n])
 	n += 1
 	var numKvs = int(binary.BigEndian.Uint16(pkg[n:]))
@@ -47,14 +47,25 @@ const (
 	CmdMIncr = 0x65
 
 	// Inner SYNC
-	CmdSync   = 0xB0 // Sync data
-	CmdSyncSt = 0xB1 // Sync status
+	CmdSync    = 0xB0 // Sync data
+	CmdSyncSt  = 0xB1 // Sync status
+	CmdSyncAck = 0xB2 // Slaver acknowledges applied sync data
 
 	// Inner CTRL
 	CmdSlaveOf  = 0xD0
 	CmdMigrate  = 0xD1 // Start/Stop migration
 	CmdSlaverSt = 0xD2 // Get migration/slaver status
 	CmdDelUnit  = 0xD3 // Delete unit data
+	CmdBackup   = 0xD4 // Create online backup
+	CmdReplSt   = 0xD5 // Get replication status
+	CmdPromote  = 0xD6 // Promote slaver to master
+	CmdCutover  = 0xD7 // Finish migration on the old server
+	CmdCluster  = 0xD8 // Get/Set cluster routing table
+	CmdDrop     = 0xD9 // Drop table/DB, replicated in binlog
+	CmdCatalog  = 0xDA // Get/Set table catalog
+	CmdTableSt  = 0xDB // List tables and get table statistics
+	CmdCompact  = 0xDC // Manual compaction
+	CmdProperty = 0xDD // Get RocksDB property
 )
 
 const (
@@ -0,0 +1,231 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// FileReader reads binlog records directly from the binlog files of a
+// directory. It does not need a running BinLog, so it can be used offline,
+// e.g. for point-in-time recovery on a copied data directory.
+type FileReader struct {
+	dir  string
+	idxs []uint64
+	pos  int // Next file position in idxs
+
+	binFile *os.File
+	binBufR *bufio.Reader
+	binName string
+	binOff  int64  // Size of the records read from the file
+	first   bool   // Next record is the first one of the file
+	lastSeq uint64 // Seq of the last record, 0 if none
+	tmFile  *os.File
+	tmBufR  *bufio.Reader
+
+	// temp variable
+	headBuf []byte
+	head    proto.PkgHead
+	tmBuf   []byte
+}
+
+func NewFileReader(dir string) (*FileReader, error) {
+	idxs, err := loadAllFilesIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var r = new(FileReader)
+	r.dir = dir
+	r.idxs = idxs
+	r.headBuf = make([]byte, proto.HeadSize)
+	r.tmBuf = make([]byte, timeEntrySize)
+
+	return r, nil
+}
+
+func (r *FileReader) Close() {
+	r.closeFile()
+	r.pos = len(r.idxs)
+}
+
+func (r *FileReader) closeFile() {
+	if r.binFile != nil {
+		r.binFile.Close()
+		r.binFile = nil
+		r.binBufR = nil
+	}
+	if r.tmFile != nil {
+		r.tmFile.Close()
+		r.tmFile = nil
+		r.tmBufR = nil
+	}
+}
+
+func (r *FileReader) openNextFile() error {
+	r.closeFile()
+
+	if r.pos >= len(r.idxs) {
+		return io.EOF
+	}
+
+	var idx = r.idxs[r.pos]
+	r.pos++
+
+	var err error
+	var name = getBinFileName(r.dir, idx)
+	r.binFile, err = os.Open(name)
+	if err != nil {
+		return err
+	}
+	r.binBufR = bufio.NewReader(r.binFile)
+	r.binName = name
+	r.binOff = 0
+	r.first = true
+
+	name = getTimeFileName(r.dir, idx)
+	r.tmFile, err = os.Open(name)
+	if err != nil {
+		// Old binlog file without time, or the time file is lost
+		log.Printf("open file failed: (%s) %s\n", name, err)
+		r.tmFile = nil
+	} else {
+		r.tmBufR = bufio.NewReader(r.tmFile)
+	}
+
+	return nil
+}
+
+// Next returns the next binlog record, its seq and the wall-clock time
+// when it was written. The time is zero if it is unknown.
+// It returns io.EOF after the last record. Only the last file can end with
+// a truncated record, which is being written or lost in a crash. A record
+// truncated in another file, or a seq gap between files, is an error, as
+// records would be skipped.
+func (r *FileReader) Next() ([]byte, uint64, time.Time, error) {
+	for {
+		if r.binBufR == nil {
+			err := r.openNextFile()
+			if err != nil {
+				return nil, 0, time.Time{}, err
+			}
+		}
+
+		pkg, err := proto.ReadPkg(r.binBufR, r.headBuf, &r.head, nil)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return nil, 0, time.Time{}, err
+			}
+			if r.pos < len(r.idxs) && r.truncated() {
+				return nil, 0, time.Time{}, fmt.Errorf(
+					"binlog file %s has a truncated record", r.binName)
+			}
+			r.closeFile()
+			continue
+		}
+		r.binOff += int64(len(pkg))
+
+		if r.first && r.lastSeq != 0 && r.head.Seq != r.lastSeq+1 {
+			return nil, 0, time.Time{}, fmt.Errorf(
+				"binlog file %s starts with seq %d, expected %d",
+				r.binName, r.head.Seq, r.lastSeq+1)
+		}
+		r.first = false
+		r.lastSeq = r.head.Seq
+
+		var tm time.Time
+		if r.tmBufR != nil {
+			_, err = io.ReadFull(r.tmBufR, r.tmBuf)
+			if err == nil && binary.BigEndian.Uint64(r.tmBuf) == r.head.Seq {
+				tm = time.Unix(0, int64(binary.BigEndian.Uint64(r.tmBuf[8:])))
+			} else {
+				// Time file mismatch, stop reading time for this file
+				r.tmFile.Close()
+				r.tmFile = nil
+				r.tmBufR = nil
+			}
+		}
+
+		return pkg, r.head.Seq, tm, nil
+	}
+}
+
+// truncated returns true if the bin file has bytes after the last record
+// read, which can only be a part of a record.
+func (r *FileReader) truncated() bool {
+	fi, err := r.binFile.Stat()
+	return err != nil || fi.Size() > r.binOff
+}
+
+// InitLogSeq creates the first binlog file in an empty directory with an
+// empty sync status record of seq, so that a server restored from a data
+// snapshot continues from seq. The directory must have no binlog file.
+func InitLogSeq(dir string, seq uint64) error {
+	err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	idxs, err := loadAllFilesIndex(dir)
+	if err != nil {
+		return err
+	}
+	if len(idxs) > 0 {
+		return fmt.Errorf("binlog directory %s is not empty", dir)
+	}
+
+	var one proto.PkgOneOp
+	one.Cmd = proto.CmdSyncSt
+	one.Seq = seq
+	var pkg = make([]byte, one.Length())
+	_, err = one.Encode(pkg)
+	if err != nil {
+		return err
+	}
+
+	var tm [timeEntrySize]byte
+	binary.BigEndian.PutUint64(tm[0:], seq)
+	binary.BigEndian.PutUint64(tm[8:], uint64(time.Now().UnixNano()))
+
+	err = writeFileSync(getTimeFileName(dir, 1), tm[:])
+	if err != nil {
+		return err
+	}
+
+	// Seq file is created when BinLog loads the bin file
+	return writeFileSync(getBinFileName(dir, 1), pkg)
+}
+
+func writeFileSync(name string, data []byte) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
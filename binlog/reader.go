@@ -82,6 +82,13 @@ func (r *Reader) Init(logSeq uint64) error {
 	r.bin.rseqs = append(r.bin.rseqs, r.rseq)
 	r.rseq.seq = logSeq
 
+	// The slaver is ahead of me, such as the slavers of a dead master
+	// after another slaver has been promoted
+	if logSeq > r.bin.logSeq && r.bin.logSeq >= MinNormalSeq {
+		r.bin.mtx.Unlock() // unlock immediately
+		return ErrLogMissing
+	}
+
 	var index = -1
 	for i, f := range r.bin.infos {
 		index = i
@@ -139,6 +146,10 @@ func (r *Reader) Init(logSeq uint64) error {
 				break
 			}
 		}
+
+		if logSeq > 0 && logSeq != MinNormalSeq && r.head.Seq != logSeq {
+			return ErrLogMissing
+		}
 	} else {
 		defer r.bin.mtx.Unlock() // wait until func finished
 
@@ -167,6 +178,12 @@ func (r *Reader) Init(logSeq uint64) error {
 				break
 			}
 		}
+
+		// The binlog is rebuilt if logSeq is skipped, such as a slaver
+		// has switched to another master
+		if logSeq > 0 && logSeq != MinNormalSeq && r.head.Seq != logSeq {
+			return ErrLogMissing
+		}
 	}
 
 	return nil
@@ -16,6 +16,7 @@ package binlog
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"github.com/stevejiang/gotable/api/go/table/proto"
@@ -37,6 +38,10 @@ const (
 	MinNormalSeq = uint64(1000000000000000000)
 )
 
+const (
+	timeEntrySize = 16 // ddwSeq+ddwUnixNano
+)
+
 type fileInfo struct {
 	Idx    uint64
 	MinSeq uint64
@@ -51,6 +56,8 @@ type readerSeq struct {
 type Request struct {
 	MasterSeq uint64
 	Pkg       []byte
+
+	seq uint64 // Binlog seq assigned by AddRequest
 }
 
 type BinLog struct {
@@ -61,6 +68,10 @@ type BinLog struct {
 
 	binFile *os.File
 	binBufW *bufio.Writer
+	tmFile  *os.File // Wall-clock time of every record in binFile
+	tmBufW  *bufio.Writer
+
+	addMtx sync.Mutex // Keep requests in reqChan ordered by seq
 
 	mtx       sync.Mutex // The following variables are protected by mtx
 	hasMaster bool       // Has master or not
@@ -68,7 +79,8 @@ type BinLog struct {
 	monitors  []Monitor
 
 	fileIdx uint64
-	logSeq  uint64
+	logSeq  uint64 // The last assigned seq
+	wrSeq   uint64 // The last written seq
 
 	memlog  []byte
 	usedLen int
@@ -121,6 +133,7 @@ func (bin *BinLog) init() error {
 		bin.fileIdx = bin.infos[len(bin.infos)-1].Idx
 		bin.logSeq = bin.infos[len(bin.infos)-1].MaxSeq
 	}
+	bin.wrSeq = bin.logSeq
 
 	return nil
 }
@@ -145,11 +158,21 @@ func (bin *BinLog) RemoveMonitor(m Monitor) {
 	bin.mtx.Unlock()
 }
 
+// AsMaster switches the binlog to master mode. A slaver's binlog is numbered
+// by the seq of its old master, so the seq keeps increasing from there, and
+// other slavers of the old master can continue incremental sync.
 func (bin *BinLog) AsMaster() {
 	bin.mtx.Lock()
 	bin.hasMaster = false
 	if bin.logSeq < MinNormalSeq {
 		bin.logSeq = MinNormalSeq
+		if len(bin.infos) > 0 {
+			var lastSeq = bin.infos[len(bin.infos)-1].MaxSeq
+			if lastSeq > bin.logSeq {
+				bin.logSeq = lastSeq
+			}
+		}
+		bin.wrSeq = bin.logSeq
 	}
 	bin.mtx.Unlock()
 }
@@ -157,7 +180,11 @@ func (bin *BinLog) AsMaster() {
 func (bin *BinLog) AsSlaver() {
 	bin.mtx.Lock()
 	bin.hasMaster = true
-	bin.logSeq = 0
+	// Keep increasing the seq of an unfinished full sync
+	if bin.logSeq >= MinNormalSeq {
+		bin.logSeq = 0
+		bin.wrSeq = 0
+	}
 	bin.mtx.Unlock()
 }
 
@@ -184,25 +211,65 @@ func (bin *BinLog) GetMasterSeq() (masterSeq uint64, valid bool) {
 	return
 }
 
+// GetLastLogSeq returns the last assigned seq, valid is true if all
+// requests have been written.
 func (bin *BinLog) GetLastLogSeq() (lastLogSeq uint64, valid bool) {
 	bin.mtx.Lock()
-	lastLogSeq, valid = bin.logSeq, len(bin.reqChan) == 0
+	lastLogSeq, valid = bin.logSeq, bin.logSeq == bin.wrSeq
 	bin.mtx.Unlock()
 	return
 }
 
-func (bin *BinLog) AddRequest(req *Request) {
+// IsWritten returns true if the request with seq has been written,
+// or all requests have been written.
+func (bin *BinLog) IsWritten(seq uint64) bool {
+	bin.mtx.Lock()
+	written := bin.wrSeq >= seq || bin.wrSeq == bin.logSeq
+	bin.mtx.Unlock()
+	return written
+}
+
+// AddRequest assigns a seq to the request and queues it to be written.
+// The seq is assigned in the caller goroutine, so the caller can bind the
+// seq to its write atomically.
+func (bin *BinLog) AddRequest(req *Request) uint64 {
+	bin.addMtx.Lock()
+	bin.mtx.Lock()
+	if bin.hasMaster && req.MasterSeq > 0 {
+		bin.logSeq = req.MasterSeq
+	} else {
+		bin.logSeq++
+	}
+	req.seq = bin.logSeq
+	bin.mtx.Unlock()
+
+	proto.OverWriteSeq(req.Pkg, req.seq)
 	bin.reqChan <- req
+	bin.addMtx.Unlock()
+
+	return req.seq
 }
 
 func (bin *BinLog) GetBinFileName(fileIdx uint64) string {
-	return fmt.Sprintf("%s/%06d.bin", bin.dir, fileIdx)
+	return getBinFileName(bin.dir, fileIdx)
 }
 
 func (bin *BinLog) GetSeqFileName(fileIdx uint64) string {
 	return fmt.Sprintf("%s/%06d.seq", bin.dir, fileIdx)
 }
 
+func (bin *BinLog) GetTimeFileName(fileIdx uint64) string {
+	return getTimeFileName(bin.dir, fileIdx)
+}
+
+func getBinFileName(dir string, fileIdx uint64) string {
+	return fmt.Sprintf("%s/%06d.bin", dir, fileIdx)
+}
+
+func getTimeFileName(dir string, fileIdx uint64) string {
+	return fmt.Sprintf("%s/%06d.tm", dir, fileIdx)
+}
+
 func (bin *BinLog) GoWriteBinLog() {
 	var ms []Monitor
 	var last1, last2 *Request
@@ -215,12 +282,10 @@ func (bin *BinLog) GoWriteBinLog() {
 				return
 			}
 
+			bin.doWrite(req, req.seq)
+
 			bin.mtx.Lock()
-			if bin.hasMaster && req.MasterSeq > 0 {
-				bin.logSeq = req.MasterSeq
-			} else {
-				bin.logSeq++
-			}
+			bin.wrSeq = req.seq
 			if bin.msChanged {
 				bin.msChanged = false
 				ms = make([]Monitor, len(bin.monitors))
@@ -232,20 +297,20 @@ func (bin *BinLog) GoWriteBinLog() {
 				ms.NewLogComming()
 			}
 
-			proto.OverWriteSeq(req.Pkg, bin.logSeq)
-			bin.doWrite(req, bin.logSeq)
-
 			last1 = req
 
 		case <-tick:
 			if bin.binBufW != nil {
 				bin.binBufW.Flush()
 			}
+			if bin.tmBufW != nil {
+				bin.tmBufW.Flush()
+			}
 
 			if last1 == nil {
 				if last2 != nil {
 					log.Printf("Write binlog: seq=%d, masterSeq=%d\n",
-						bin.logSeq, last2.MasterSeq)
+						last2.seq, last2.MasterSeq)
 					last2 = nil
 				}
 			} else {
@@ -263,6 +328,12 @@ func (bin *BinLog) doWrite(req *Request, logSeq uint64) error {
 			bin.binBufW = nil
 			bin.binFile.Close()
 			bin.binFile = nil
+			if bin.tmFile != nil {
+				bin.tmBufW.Flush()
+				bin.tmBufW = nil
+				bin.tmFile.Close()
+				bin.tmFile = nil
+			}
 
 			bin.mtx.Lock()
 			bin.usedLen = 0
@@ -291,6 +362,15 @@ func (bin *BinLog) doWrite(req *Request, logSeq uint64) error {
 
 		bin.binBufW = bufio.NewWriter(bin.binFile)
 
+		name = bin.GetTimeFileName(bin.fileIdx)
+		bin.tmFile, err = os.Create(name)
+		if err != nil {
+			// Not fatal, only point-in-time recovery by time is affected
+			log.Printf("create file failed: (%s) %s\n", name, err)
+		} else {
+			bin.tmBufW = bufio.NewWriter(bin.tmFile)
+		}
+
 		bin.mtx.Lock()
 		bin.infos = append(bin.infos, &fileInfo{bin.fileIdx, logSeq, 0, false})
 		bin.mtx.Unlock()
@@ -298,6 +378,12 @@ func (bin *BinLog) doWrite(req *Request, logSeq uint64) error {
 
 	copy(bin.memlog[bin.usedLen:], req.Pkg)
 	bin.binBufW.Write(req.Pkg)
+	if bin.tmBufW != nil {
+		var tm [timeEntrySize]byte
+		binary.BigEndian.PutUint64(tm[0:], logSeq)
+		binary.BigEndian.PutUint64(tm[8:], uint64(time.Now().UnixNano()))
+		bin.tmBufW.Write(tm[:])
+	}
 
 	bin.mtx.Lock()
 	bin.usedLen += len(req.Pkg)
@@ -336,11 +422,16 @@ func (bin *BinLog) deleteOldBinLogFiles(idxs []uint64) {
 	for _, idx := range idxs {
 		os.Remove(bin.GetBinFileName(idx))
 		os.Remove(bin.GetSeqFileName(idx))
+		os.Remove(bin.GetTimeFileName(idx))
 	}
 }
 
 func (bin *BinLog) loadAllFilesIndex() ([]uint64, error) {
-	dir, err := os.Open(bin.dir)
+	return loadAllFilesIndex(bin.dir)
+}
+
+func loadAllFilesIndex(binDir string) ([]uint64, error) {
+	dir, err := os.Open(binDir)
 	if err != nil {
 		return nil, err
 	}
@@ -419,6 +510,7 @@ func (bin *BinLog) fixSeqFile(idx uint64) (fileInfo, error) {
 	if fi.Idx == 0 {
 		os.Remove(bin.GetBinFileName(idx))
 		os.Remove(bin.GetSeqFileName(idx))
+		os.Remove(bin.GetTimeFileName(idx))
 		return fi, fmt.Errorf("no record in bin file id %d", idx)
 	}
 
@@ -5,8 +5,9 @@ DEPS_DIR=/tmp/gotable-deps
 #DEPS_DIR=$HOME/localws/gotable-deps
 DEPS_ULR=https://github.com/stevejiang/gotable-deps/raw/master
 
-ROCKSDB_VER=rocksdb-3.8
-ROCKSDB_URL=$DEPS_ULR/$ROCKSDB_VER.tar.gz
+# Checkpoint and other C APIs need rocksdb 5.x
+ROCKSDB_VER=rocksdb-5.18.4
+ROCKSDB_URL=https://github.com/facebook/rocksdb/archive/v5.18.4.tar.gz
 ROCKSDB=$DEPS_DIR/$ROCKSDB_VER
 
 download_rocksdb() {
@@ -35,6 +35,7 @@ var (
 	testCase = flag.String("t", "set,get", "Test cases: "+
 		"set,get,zset,zget,scan,zscan,incr,zincr,mset,zmset,mget,zmget")
 	rangeNum    = flag.Int("range", 10, "Scan/MGet/Mset range number")
+	missRate    = flag.Int("miss", 0, "Percent of Scan/ZScan on rows not existing")
 	histogram   = flag.Int("histogram", 0, "Print histogram of operation timings")
 	pipeline    = flag.Int("P", 0, "Pipeline number")
 	verbose     = flag.Int("v", 0, "Verbose mode, if enabled it will slow down the test")
@@ -278,8 +279,13 @@ func benchScan(cliPool *table.Pool, zop bool) {
 		startScore = -500000000
 	}
 
+	// Prefix bloom filters skip SST files without the row
 	var op = func(v int, p *OpParam) {
-		key := strconv.AppendInt(p.keyBuf, int64(v), 10)
+		var key = p.keyBuf
+		if v%100 < *missRate {
+			key = append(key, 'm')
+		}
+		key = strconv.AppendInt(key, int64(v), 10)
 		var rowKey = key[0 : len(key)-3]
 
 		scan(p.c, p.done, zop, rowKey, nil, startScore, *rangeNum)
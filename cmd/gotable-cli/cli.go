@@ -18,7 +18,9 @@ import (
 	"fmt"
 	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -47,7 +49,7 @@ func (c *client) auth(args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	dbId, err := getDatabaseId(args[0])
+	dbId, err := c.getDatabaseId(args[0])
 	if err != nil {
 		return err
 	}
@@ -78,7 +80,7 @@ func (c *client) use(args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	dbId, err := getDatabaseId(args[0])
+	dbId, err := c.getDatabaseId(args[0])
 	if err != nil {
 		return err
 	}
@@ -118,6 +120,561 @@ func (c *client) slaveOf(args []string) error {
 	return nil
 }
 
+func (c *client) backup(args []string) error {
+	//backup <dir>
+	//Examples:
+	//backup /data/backup/20150601
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	dir, err := extractString(args[0])
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	lastSeq, err := cc.Backup(dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("OK, lastSeq %d\n", lastSeq)
+	return nil
+}
+
+func (c *client) dropTable(args []string) error {
+	//droptable <tableId>
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	tableId, err := c.getTableId(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !confirm(fmt.Sprintf("Drop all data of table %d in DB %d", tableId,
+		c.dbId)) {
+		fmt.Println("Canceled")
+		return nil
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.DropTable(tableId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) dropDB(args []string) error {
+	//dropdb
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	if !confirm(fmt.Sprintf("Drop all data of DB %d", c.dbId)) {
+		fmt.Println("Canceled")
+		return nil
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err := cc.DropDB()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) tables(args []string) error {
+	//tables [all]
+	if len(args) > 1 || (len(args) == 1 && strings.ToLower(args[0]) != "all") {
+		return fmt.Errorf("invalid arguments")
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	ts, err := cc.ListTables(len(args) == 1)
+	if err != nil {
+		return err
+	}
+
+	for i, t := range ts {
+		fmt.Printf("%2d) db: %d, table: %d, name: %s, size: %d, keys: %d\n",
+			i, t.DbId, t.TableId, t.Name, t.Size, t.Keys)
+	}
+	return nil
+}
+
+func (c *client) tableStats(args []string) error {
+	//tablestats <tableId>
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	tableId, err := c.getTableId(args[0])
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	t, err := cc.TableStats(tableId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("db: %d, table: %d, name: %s, size: %d, keys: %d\n",
+		t.DbId, t.TableId, t.Name, t.Size, t.Keys)
+	return nil
+}
+
+func (c *client) compact(args []string) error {
+	//compact db|all
+	//compact table <tableId>
+	//compact units <startUnitId-endUnitId>
+	if len(args) < 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	var err error
+	switch args[0] {
+	case "db", "all":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		if args[0] == "db" {
+			err = cc.CompactDB()
+		} else {
+			err = cc.CompactAll()
+		}
+	case "table":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		var tableId uint8
+		tableId, err = c.getTableId(args[1])
+		if err == nil {
+			err = cc.CompactTable(tableId)
+		}
+	case "units":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid number of arguments (%d)", len(args))
+		}
+		var start, end uint16
+		start, end, err = parseUnits(args[1])
+		if err == nil {
+			err = cc.CompactUnits(start, end)
+		}
+	default:
+		return fmt.Errorf("invalid compaction scope %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) property(args []string) error {
+	//property <name>
+	//Examples:
+	//property rocksdb.stats
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	values, err := cc.GetProperty(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if strings.Contains(v.Value, "\n") {
+			fmt.Printf("[%s]\n%s\n", v.CF, strings.TrimRight(v.Value, "\n"))
+		} else {
+			fmt.Printf("[%s] %s\n", v.CF, v.Value)
+		}
+	}
+	return nil
+}
+
+func (c *client) catalog(args []string) error {
+	//catalog
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	cat, err := cc.GetCatalog()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %d\n", cat.Version)
+	for i, d := range cat.DBs {
+		fmt.Printf("%2d) db: %d, name: %s, desc: %q\n", i, d.DbId, d.Name, d.Desc)
+	}
+	for i, t := range cat.Tables {
+		fmt.Printf("%2d) db: %d, table: %d, name: %s, desc: %q, options: %v\n",
+			i, t.DbId, t.TableId, t.Name, t.Desc, t.Options)
+	}
+	return nil
+}
+
+func (c *client) setTable(args []string) error {
+	//settable <tableId> <name> [desc] [key=value ...]
+	//Examples:
+	//settable 1 users
+	//settable 2 profiles "user profiles" owner=web
+	if len(args) < 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	tableId, err := c.getTableId(args[0])
+	if err != nil {
+		return err
+	}
+
+	var t = ctrl.TableInfo{DbId: c.dbId, TableId: tableId}
+	t.Name, err = extractString(args[1])
+	if err != nil {
+		return err
+	}
+
+	for i, arg := range args[2:] {
+		str, err := extractString(arg)
+		if err != nil {
+			return err
+		}
+		if i == 0 && (str != arg || !strings.Contains(str, "=")) {
+			t.Desc = str
+			continue
+		}
+		var kv = strings.SplitN(str, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return fmt.Errorf("invalid option %s", arg)
+		}
+		if t.Options == nil {
+			t.Options = make(map[string]string)
+		}
+		t.Options[kv[0]] = kv[1]
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.SetTableInfo(t)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) setDB(args []string) error {
+	//setdb <name> [desc]
+	//Examples:
+	//setdb shop "online shop"
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var d = ctrl.DBInfo{DbId: c.dbId}
+	var err error
+	d.Name, err = extractString(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		d.Desc, err = extractString(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.SetDBInfo(d)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) unsetDB(args []string) error {
+	//unsetdb
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err := cc.DelDBInfo(c.dbId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) unsetTable(args []string) error {
+	//unsettable <tableId>
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	tableId, err := c.getTableId(args[0])
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.DelTableInfo(c.dbId, tableId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) replStatus(args []string) error {
+	//replstatus
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	st, err := cc.ReplStatus()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("lastSeq: %d\n", st.LastSeq)
+	if len(st.MasterAddr) > 0 {
+		fmt.Printf("master: %s, migration: %v, status: %d\n",
+			st.MasterAddr, st.Migration, st.Status)
+		fmt.Printf("  appliedSeq: %d, appliedBytes: %d, lastAck: %s\n",
+			st.AppliedSeq, st.AppliedBytes, formatAckTime(st.LastAck))
+	}
+	for i, s := range st.Slavers {
+		fmt.Printf("%2d) slaver: %s, migration: %v, fullSync: %v\n",
+			i, s.SlaverAddr, s.Migration, s.FullSync)
+		if s.Migration {
+			fmt.Printf("    units: %s\n", s.Units)
+		}
+		if s.FullSync {
+			fmt.Printf("    syncRecords: %d, syncBytes: %d\n",
+				s.SyncRecords, s.SyncBytes)
+		}
+		fmt.Printf("    ackSeq: %d, lag: %d records %.3fs, behind: %d bytes, "+
+			"lastAck: %s\n", s.AckSeq, s.LagRecords, s.LagSeconds,
+			s.BytesBehind, formatAckTime(s.LastAck))
+	}
+
+	return nil
+}
+
+func (c *client) promote(args []string) error {
+	//promote [force]
+	if len(args) > 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var force bool
+	if len(args) > 0 {
+		if args[0] != "force" {
+			return fmt.Errorf("invalid argument %q", args[0])
+		}
+		force = true
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	lastSeq, err := cc.Promote(force)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("OK, lastSeq %d\n", lastSeq)
+	return nil
+}
+
+func (c *client) migrate(args []string) error {
+	//migrate <host> <units>
+	//Examples:
+	//migrate 127.0.0.1:6688 10
+	//migrate 127.0.0.1:6688 0-4095,5000
+	if len(args) != 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	host, err := extractString(args[0])
+	if err != nil {
+		return err
+	}
+
+	units, err := ctrl.ParseUnitSet(args[1])
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.MigrateUnits(host, units)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) migCancel(args []string) error {
+	//migcancel <unitId>
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	unitId, err := getUnitId(args[0])
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.CancelMigrate(unitId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) migStatus(args []string) error {
+	//migstatus <unitId>
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	unitId, err := getUnitId(args[0])
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	s, err := cc.MigStatus(unitId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("slaver: %s, units: %s, fullSync: %v\n",
+		s.SlaverAddr, s.Units, s.FullSync)
+	fmt.Printf("  syncRecords: %d, syncBytes: %d\n", s.SyncRecords, s.SyncBytes)
+	fmt.Printf("  ackSeq: %d, lag: %d records %.3fs, behind: %d bytes, "+
+		"lastAck: %s\n", s.AckSeq, s.LagRecords, s.LagSeconds,
+		s.BytesBehind, formatAckTime(s.LastAck))
+	return nil
+}
+
+func (c *client) cutover(args []string) error {
+	//cutover <unitId> [timeoutMs]
+	//Examples:
+	//cutover 10
+	//cutover 10 3000
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	unitId, err := getUnitId(args[0])
+	if err != nil {
+		return err
+	}
+
+	var timeout int64
+	if len(args) > 1 {
+		timeout, err = strconv.ParseInt(args[1], 10, 32)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("<timeoutMs> %s is not a valid number", args[1])
+		}
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	err = cc.Cutover(unitId, time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+	return nil
+}
+
+func (c *client) cluster(args []string) error {
+	//cluster
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	m, err := cc.GetCluster()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %d\n", m.Version)
+	for i, r := range m.Ranges {
+		fmt.Printf("%2d) units: %d-%d, master: %s, slavers: %s\n", i,
+			r.StartUnitId, r.EndUnitId, r.Master, strings.Join(r.Slavers, ","))
+	}
+
+	return nil
+}
+
+func (c *client) setCluster(args []string) error {
+	//setcluster <startUnitId-endUnitId=master[,slaver...]> ...
+	//Examples:
+	//setcluster 0-8191=127.0.0.1:6688
+	//setcluster 0-4095=127.0.0.1:6688,127.0.0.1:6689 4096-8191=127.0.0.1:6690
+	if len(args) == 0 {
+		return fmt.Errorf("invalid number of arguments (%d)", len(args))
+	}
+
+	var m ctrl.ClusterMap
+	for _, arg := range args {
+		r, err := parseUnitRange(arg)
+		if err != nil {
+			return err
+		}
+		m.Ranges = append(m.Ranges, r)
+	}
+
+	err := m.Check()
+	if err != nil {
+		return err
+	}
+
+	var cc = table.CtrlContext(*c.c)
+	version, err := cc.SetCluster(m)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("OK, version %d\n", version)
+	return nil
+}
+
+func formatAckTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05.000")
+}
+
 func (c *client) ping() error {
 	// ping
 	start := time.Now()
@@ -141,7 +698,7 @@ func (c *client) get(zop bool, args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	tableId, err := getTableId(args[0])
+	tableId, err := c.getTableId(args[0])
 	if err != nil {
 		return err
 	}
@@ -182,7 +739,7 @@ func (c *client) set(zop bool, args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	tableId, err := getTableId(args[0])
+	tableId, err := c.getTableId(args[0])
 	if err != nil {
 		return err
 	}
@@ -227,7 +784,7 @@ func (c *client) del(zop bool, args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	tableId, err := getTableId(args[0])
+	tableId, err := c.getTableId(args[0])
 	if err != nil {
 		return err
 	}
@@ -261,7 +818,7 @@ func (c *client) incr(zop bool, args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	tableId, err := getTableId(args[0])
+	tableId, err := c.getTableId(args[0])
 	if err != nil {
 		return err
 	}
@@ -302,7 +859,7 @@ func (c *client) scan(args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	tableId, err := getTableId(args[0])
+	tableId, err := c.getTableId(args[0])
 	if err != nil {
 		return err
 	}
@@ -347,7 +904,7 @@ func (c *client) zscan(args []string) error {
 		return fmt.Errorf("invalid number of arguments (%d)", len(args))
 	}
 
-	tableId, err := getTableId(args[0])
+	tableId, err := c.getTableId(args[0])
 	if err != nil {
 		return err
 	}
@@ -403,7 +960,20 @@ func errCodeMsg(errCode int8) string {
 	}
 }
 
-func getTableId(arg string) (uint8, error) {
+// getTableId accepts tableId or table name of the selected DB.
+func (c *client) getTableId(arg string) (uint8, error) {
+	if !isName(arg) {
+		return parseTableId(arg)
+	}
+
+	t, err := c.c.Table(arg)
+	if err != nil {
+		return 0, err
+	}
+	return t.TableId(), nil
+}
+
+func parseTableId(arg string) (uint8, error) {
 	tableId, err := strconv.Atoi(arg)
 	if err != nil {
 		return 0, fmt.Errorf("<tableId> %s is not a number", arg)
@@ -416,7 +986,86 @@ func getTableId(arg string) (uint8, error) {
 	return uint8(tableId), nil
 }
 
-func getDatabaseId(arg string) (uint8, error) {
+func getUnitId(arg string) (uint16, error) {
+	unitId, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("<unitId> %s is not a number", arg)
+	}
+
+	if unitId < 0 || unitId >= ctrl.TotalUnitNum {
+		return 0, fmt.Errorf("<unitId> %s is out of range [0 ~ %d]",
+			arg, ctrl.TotalUnitNum-1)
+	}
+
+	return uint16(unitId), nil
+}
+
+// Parse unitId or startUnitId-endUnitId
+func parseUnits(arg string) (uint16, uint16, error) {
+	var units = strings.SplitN(arg, "-", 2)
+	start, err := getUnitId(units[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(units) == 1 {
+		return start, start, nil
+	}
+	end, err := getUnitId(units[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// Parse startUnitId-endUnitId=master[,slaver...]
+func parseUnitRange(arg string) (ctrl.UnitRange, error) {
+	var r ctrl.UnitRange
+	var fields = strings.SplitN(arg, "=", 2)
+	if len(fields) != 2 {
+		return r, fmt.Errorf("invalid unit range %s", arg)
+	}
+
+	var units = strings.SplitN(fields[0], "-", 2)
+	if len(units) != 2 {
+		return r, fmt.Errorf("invalid unit range %s", arg)
+	}
+	start, err := getUnitId(units[0])
+	if err != nil {
+		return r, err
+	}
+	end, err := getUnitId(units[1])
+	if err != nil {
+		return r, err
+	}
+
+	var hosts = strings.Split(fields[1], ",")
+	r.StartUnitId = start
+	r.EndUnitId = end
+	r.Master = hosts[0]
+	r.Slavers = hosts[1:]
+	return r, nil
+}
+
+// getDatabaseId accepts dbId or DB name.
+func (c *client) getDatabaseId(arg string) (uint8, error) {
+	if !isName(arg) {
+		return parseDatabaseId(arg)
+	}
+
+	ctx, err := c.c.Client().NewContextByName(arg)
+	if err != nil {
+		return 0, err
+	}
+	return ctx.DatabaseId(), nil
+}
+
+// Names start with a letter, while ids are numbers
+func isName(arg string) bool {
+	return len(arg) > 0 && (arg[0] >= 'a' && arg[0] <= 'z' ||
+		arg[0] >= 'A' && arg[0] <= 'Z')
+}
+
+func parseDatabaseId(arg string) (uint8, error) {
 	dbId, err := strconv.Atoi(arg)
 	if err != nil {
 		return 0, fmt.Errorf("<dbId> %s is not a number", arg)
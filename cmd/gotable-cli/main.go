@@ -94,6 +94,46 @@ func main() {
 			checkError(cli.use(fields[1:]))
 		case "slaveof":
 			checkError(cli.slaveOf(fields[1:]))
+		case "backup":
+			checkError(cli.backup(fields[1:]))
+		case "replstatus":
+			checkError(cli.replStatus(fields[1:]))
+		case "promote":
+			checkError(cli.promote(fields[1:]))
+		case "migrate":
+			checkError(cli.migrate(fields[1:]))
+		case "migcancel":
+			checkError(cli.migCancel(fields[1:]))
+		case "migstatus":
+			checkError(cli.migStatus(fields[1:]))
+		case "cutover":
+			checkError(cli.cutover(fields[1:]))
+		case "cluster":
+			checkError(cli.cluster(fields[1:]))
+		case "setcluster":
+			checkError(cli.setCluster(fields[1:]))
+		case "droptable":
+			checkError(cli.dropTable(fields[1:]))
+		case "dropdb":
+			checkError(cli.dropDB(fields[1:]))
+		case "tables":
+			checkError(cli.tables(fields[1:]))
+		case "tablestats":
+			checkError(cli.tableStats(fields[1:]))
+		case "compact":
+			checkError(cli.compact(fields[1:]))
+		case "property":
+			checkError(cli.property(fields[1:]))
+		case "catalog":
+			checkError(cli.catalog(fields[1:]))
+		case "settable":
+			checkError(cli.setTable(fields[1:]))
+		case "unsettable":
+			checkError(cli.unsetTable(fields[1:]))
+		case "setdb":
+			checkError(cli.setDB(fields[1:]))
+		case "unsetdb":
+			checkError(cli.unsetDB(fields[1:]))
 
 		case "?":
 			fallthrough
@@ -144,14 +184,50 @@ func writeHelp() {
 	fmt.Println(" zscan <tableId> <rowKey> <score> <colKey> [num]")
 	fmt.Println("                            zscan columns of rowKey in ASC order by score")
 	fmt.Println("slaveof [host]              be slave of master host ip:port")
+	fmt.Println("backup <dir>                create online backup in server directory")
+	fmt.Println("replstatus                  show replication status and lag")
+	fmt.Println("promote [force]             promote slaver to master")
+	fmt.Println("migrate <host> <units>      migrate units (such as 1-5,9) from host")
+	fmt.Println("migcancel <unitId>          cancel migrating unit to this server")
+	fmt.Println("migstatus <unitId>          show migration progress on old server")
+	fmt.Println("cutover <unitId> [timeoutMs]")
+	fmt.Println("                            move unit to new server on old server")
+	fmt.Println("cluster                     show cluster map of config server")
+	fmt.Println("setcluster <startUnitId-endUnitId=master[,slaver...]> ...")
+	fmt.Println("                            set cluster map of config server")
+	fmt.Println("droptable <tableId>         drop table in selected database")
+	fmt.Println("dropdb                      drop selected database")
+	fmt.Println("tables [all]                list tables of selected database with size")
+	fmt.Println("tablestats <tableId>        show estimated size and keys of table")
+	fmt.Println("compact db|all              compact selected database or all data")
+	fmt.Println("compact table <tableId>     compact table in selected database")
+	fmt.Println("compact units <startUnitId-endUnitId>")
+	fmt.Println("                            compact data of units")
+	fmt.Println("property <name>             show RocksDB property such as rocksdb.stats")
+	fmt.Println("catalog                     show table catalog")
+	fmt.Println("settable <tableId> <name> [desc] [key=value ...]")
+	fmt.Println("                            set table name, description and options")
+	fmt.Println("unsettable <tableId>        remove table from catalog")
+	fmt.Println("setdb <name> [desc]         set name and description of selected database")
+	fmt.Println("unsetdb                     remove selected database from catalog")
 	fmt.Println("  ping                      ping server")
 	fmt.Println(" clear                      clear the screen")
 	fmt.Println("  quit                      exit")
 	fmt.Println("")
+	fmt.Println("<dbId> and <tableId> can also be names set by setdb and settable.")
 	fmt.Println("Use the arrow up and down keys to walk through history.")
 	fmt.Println("")
 }
 
+// confirm asks the user to type "yes" before a dangerous operation.
+func confirm(prompt string) bool {
+	line, err := linenoise.Line(prompt + "? Type yes to confirm: ")
+	if err != nil {
+		return false
+	}
+	return strings.ToLower(strings.TrimSpace(line)) == "yes"
+}
+
 func writeUnrecognized() {
 	fmt.Println("Unrecognized command line. Use 'help'.")
 }
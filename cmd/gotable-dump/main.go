@@ -0,0 +1,148 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gotable-dump exports a DB or a table to a portable file with the DUMP API.
+// Every record is a JSON line, binary keys and values are base64 encoded.
+// Use gotable-restore to load the file back.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	address  = flag.String("h", "127.0.0.1:6688", "Server host address ip:port")
+	network  = flag.String("N", "tcp", "Server network: tcp, tcp4, tcp6, unix")
+	dbId     = flag.Int("db", 0, "DB to dump [0 ~ 254]")
+	tableId  = flag.Int("t", -1, "Table to dump [0 ~ 255], dump the whole DB if -1")
+	output   = flag.String("o", "", "Output file, stdout if empty")
+	password = flag.String("p", "", "Password for auth")
+	authDbId = flag.Int("pdb", -1, "DB to auth with password, the dumped DB if -1, "+
+		"255 for admin password")
+)
+
+// Dump record, one JSON line per record
+type Record struct {
+	DbId     uint8  `json:"db"`
+	TableId  uint8  `json:"table"`
+	ColSpace uint8  `json:"colSpace"`
+	RowKey   []byte `json:"rowKey"`
+	ColKey   []byte `json:"colKey"`
+	Value    []byte `json:"value"`
+	Score    int64  `json:"score"`
+}
+
+func main() {
+	flag.Parse()
+
+	if *dbId < 0 || *dbId >= proto.AdminDbId {
+		fmt.Printf("Invalid DB %d\n\n", *dbId)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *tableId < -1 || *tableId > proto.MaxUint8 {
+		fmt.Printf("Invalid table %d\n\n", *tableId)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	client, err := table.Dial(*network, *address)
+	if err != nil {
+		fmt.Printf("Dial failed: %s\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	var tc = client.NewContext(uint8(*dbId))
+	if len(*password) > 0 {
+		var ac = tc
+		if *authDbId >= 0 {
+			ac = client.NewContext(uint8(*authDbId))
+		}
+		err = ac.Auth(*password)
+		if err != nil {
+			fmt.Printf("Auth failed: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*output) > 0 {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Printf("Create file failed: %s\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var start = time.Now()
+	num, err := dump(tc, w)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dump failed after %d records: %s\n", num, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Dump %d records in %.2fs\n",
+		num, time.Since(start).Seconds())
+}
+
+func dump(tc *table.Context, w io.Writer) (int64, error) {
+	var bufW = bufio.NewWriter(w)
+	var en = json.NewEncoder(bufW)
+
+	var r table.DumpReply
+	var err error
+	if *tableId >= 0 {
+		r, err = tc.DumpTable(uint8(*tableId))
+	} else {
+		r, err = tc.DumpDB()
+	}
+
+	var num int64
+	var rec Record
+	rec.DbId = tc.DatabaseId()
+	for err == nil {
+		for _, kv := range r.Kvs {
+			rec.TableId = kv.TableId
+			rec.ColSpace = kv.ColSpace
+			rec.RowKey = kv.RowKey
+			rec.ColKey = kv.ColKey
+			rec.Value = kv.Value
+			rec.Score = kv.Score
+			err = en.Encode(&rec)
+			if err != nil {
+				return num, err
+			}
+			num++
+		}
+
+		if r.End {
+			return num, bufW.Flush()
+		}
+
+		r, err = tc.DumpMore(r)
+	}
+
+	return num, err
+}
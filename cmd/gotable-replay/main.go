@@ -0,0 +1,97 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gotable-replay restores a data snapshot to a point in time by replaying
+// binlog records onto it. It works on a copied data directory without a
+// running server, and can optionally start the server when finished.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/server"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	confFile  = flag.String("c", "", "Config file of the restored server, default config if empty")
+	binlogDir = flag.String("b", "", "Directory of the binlog files to replay")
+	backupDir = flag.String("backup", "", "Backup directory, replay records after its seq")
+	fromSeq   = flag.Uint64("from", 0, "Replay records after this snapshot seq (excluded)")
+	toSeq     = flag.Uint64("to", 0, "Stop after this seq (included), 0 means no limit")
+	toTime    = flag.String("time", "", "Stop before records written after this local time, "+
+		"such as \"2015-06-01 14:02:00\"")
+	serve = flag.Bool("serve", false, "Start the server after replay finished")
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func main() {
+	flag.Parse()
+
+	if len(*binlogDir) == 0 {
+		fmt.Printf("Binlog directory is required!\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Records already in the snapshot must not be applied again
+	var hasFrom bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "from" {
+			hasFrom = true
+		}
+	})
+	if hasFrom == (len(*backupDir) > 0) {
+		fmt.Printf("Either -from or -backup is required!\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var args server.ReplayArgs
+	args.BinLogDir = *binlogDir
+	args.BackupDir = *backupDir
+	args.FromSeq = *fromSeq
+	args.ToSeq = *toSeq
+	if len(*toTime) > 0 {
+		tm, err := time.ParseInLocation(timeLayout, *toTime, time.Local)
+		if err != nil {
+			tm, err = time.Parse(time.RFC3339, *toTime)
+		}
+		if err != nil {
+			fmt.Printf("Invalid time %s, use format \"%s\"\n\n", *toTime, timeLayout)
+			flag.Usage()
+			os.Exit(1)
+		}
+		args.ToTime = tm
+	}
+
+	conf, err := config.Load(*confFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err)
+	}
+
+	lastSeq, err := server.Replay(conf, &args)
+	if err != nil {
+		log.Fatalf("Replay failed at seq %d: %s", lastSeq, err)
+	}
+	fmt.Printf("Replay finished, last seq %d\n", lastSeq)
+
+	if *serve {
+		server.Run(conf)
+	}
+}
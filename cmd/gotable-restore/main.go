@@ -0,0 +1,311 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gotable-restore loads the file exported by gotable-dump back to a server
+// with pipelined MSET/ZMSET. The dbId and tableId can be remapped.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	address  = flag.String("h", "127.0.0.1:6688", "Server host address ip:port")
+	network  = flag.String("N", "tcp", "Server network: tcp, tcp4, tcp6, unix")
+	input    = flag.String("i", "", "Input file, stdin if empty")
+	cliNum   = flag.Int("c", 4, "Number of parallel clients")
+	pipeline = flag.Int("P", 8, "Pipeline number of every client")
+	batchNum = flag.Int("n", 100, "Max number of records in one MSET/ZMSET")
+	dbMap    = flag.String("dbmap", "", "Remap dbId, such as \"0:1,2:3\"")
+	tableMap = flag.String("tablemap", "", "Remap tableId, such as \"1:5\"")
+	password = flag.String("p", "", "Password for auth")
+	authDbId = flag.Int("pdb", -1, "DB to auth with password, the target DB if -1, "+
+		"255 for admin password")
+)
+
+// The same as the record of gotable-dump
+type Record struct {
+	DbId     uint8  `json:"db"`
+	TableId  uint8  `json:"table"`
+	ColSpace uint8  `json:"colSpace"`
+	RowKey   []byte `json:"rowKey"`
+	ColKey   []byte `json:"colKey"`
+	Value    []byte `json:"value"`
+	Score    int64  `json:"score"`
+}
+
+// Records to be restored with one MSET/ZMSET
+type batch struct {
+	dbId uint8
+	zop  bool
+	size int
+	args table.MSetArgs
+}
+
+// Keep the request package far below proto.MaxPkgLen
+const maxBatchSize = proto.MaxPkgLen / 4
+
+var (
+	totalNum  int64
+	failedNum int64
+)
+
+func main() {
+	flag.Parse()
+
+	if *cliNum <= 0 || *pipeline <= 0 || *batchNum <= 0 {
+		fmt.Printf("Invalid concurrency options\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dbIds, err := parseIdMap(*dbMap)
+	if err == nil {
+		for src, dst := range dbIds {
+			if dst == proto.AdminDbId {
+				err = fmt.Errorf("cannot restore DB %d to admin DB", src)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("Invalid dbmap: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	tableIds, err := parseIdMap(*tableMap)
+	if err != nil {
+		fmt.Printf("Invalid tablemap: %s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var r io.Reader = os.Stdin
+	if len(*input) > 0 {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Printf("Open file failed: %s\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var cliPool = table.NewPool([]table.Addr{{Network: *network, Address: *address}},
+		*cliNum)
+	defer cliPool.Close()
+
+	var start = time.Now()
+	var batchChan = make(chan *batch, *cliNum**pipeline)
+	var wg sync.WaitGroup
+	for i := 0; i < *cliNum; i++ {
+		client, err := cliPool.Get()
+		if err != nil {
+			fmt.Printf("Get connection client to host %s://%s failed!\n",
+				*network, *address)
+			os.Exit(1)
+		}
+
+		wg.Add(1)
+		go restore(client, batchChan, &wg)
+	}
+
+	err = readRecords(r, batchChan, &dbIds, &tableIds)
+	close(batchChan)
+	wg.Wait()
+
+	if err != nil {
+		fmt.Printf("Read records failed: %s\n", err)
+	}
+	fmt.Printf("Restore %d records in %.2fs, %d failed\n",
+		atomic.LoadInt64(&totalNum), time.Since(start).Seconds(),
+		atomic.LoadInt64(&failedNum))
+	if err != nil || atomic.LoadInt64(&failedNum) > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseIdMap parses "src:dst,src:dst" into a full map of uint8 ids.
+func parseIdMap(s string) ([256]uint8, error) {
+	var ids [256]uint8
+	for i := 0; i < len(ids); i++ {
+		ids[i] = uint8(i)
+	}
+
+	if len(s) == 0 {
+		return ids, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		var fields = strings.Split(strings.TrimSpace(pair), ":")
+		if len(fields) != 2 {
+			return ids, fmt.Errorf("invalid pair %q", pair)
+		}
+
+		src, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return ids, err
+		}
+		dst, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			return ids, err
+		}
+
+		ids[src] = uint8(dst)
+	}
+
+	return ids, nil
+}
+
+func readRecords(r io.Reader, batchChan chan *batch,
+	dbIds, tableIds *[256]uint8) error {
+	var de = json.NewDecoder(bufio.NewReader(r))
+	var last *batch
+	for {
+		var rec Record
+		var err = de.Decode(&rec)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		var zop bool
+		switch rec.ColSpace {
+		case proto.ColSpaceDefault:
+			zop = false
+		case proto.ColSpaceScore1:
+			zop = true
+		default:
+			fmt.Printf("Skip record with invalid colSpace %d\n", rec.ColSpace)
+			atomic.AddInt64(&failedNum, 1)
+			continue
+		}
+
+		var dbId = dbIds[rec.DbId]
+		var size = len(rec.RowKey) + len(rec.ColKey) + len(rec.Value) + 32
+		if last != nil && (last.dbId != dbId || last.zop != zop ||
+			len(last.args) >= *batchNum || last.size+size > maxBatchSize) {
+			batchChan <- last
+			last = nil
+		}
+
+		if last == nil {
+			last = &batch{dbId: dbId, zop: zop}
+			last.args = make(table.MSetArgs, 0, *batchNum)
+		}
+
+		last.args.Add(tableIds[rec.TableId], rec.RowKey, rec.ColKey,
+			rec.Value, rec.Score, 0)
+		last.size += size
+	}
+
+	if last != nil {
+		batchChan <- last
+	}
+
+	return nil
+}
+
+func restore(client *table.Client, batchChan chan *batch, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer client.Close()
+
+	var ctxs = make(map[uint8]*table.Context)
+	var done = make(chan *table.Call, *pipeline)
+	var pending = make(map[*table.Call]int) // Call => number of records
+	for b := range batchChan {
+		var call *table.Call
+		tc, err := getContext(client, ctxs, b.dbId)
+		if err == nil {
+			if b.zop {
+				call, err = tc.GoZmSet(b.args, done)
+			} else {
+				call, err = tc.GoMSet(b.args, done)
+			}
+		}
+
+		atomic.AddInt64(&totalNum, int64(len(b.args)))
+		if err != nil {
+			fmt.Printf("Restore DB %d failed: %s\n", b.dbId, err)
+			atomic.AddInt64(&failedNum, int64(len(b.args)))
+			continue
+		}
+
+		pending[call] = len(b.args)
+		if len(pending) >= *pipeline {
+			// Keep the pipeline full, send the next batch after one reply
+			waitReply(done, pending, *pipeline-1)
+		}
+	}
+
+	waitReply(done, pending, 0)
+}
+
+func getContext(client *table.Client, ctxs map[uint8]*table.Context,
+	dbId uint8) (*table.Context, error) {
+	if tc, ok := ctxs[dbId]; ok {
+		return tc, nil
+	}
+
+	var tc = client.NewContext(dbId)
+	if len(*password) > 0 {
+		var ac = tc
+		if *authDbId >= 0 {
+			ac = client.NewContext(uint8(*authDbId))
+		}
+		err := ac.Auth(*password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctxs[dbId] = tc
+	return tc, nil
+}
+
+// waitReply waits for replies until no more than left calls are pending.
+func waitReply(done chan *table.Call, pending map[*table.Call]int, left int) {
+	for len(pending) > left {
+		var call = <-done
+		var num = pending[call]
+		delete(pending, call)
+
+		r, err := call.Reply()
+		if err != nil {
+			fmt.Printf("Restore failed: %s\n", err)
+			atomic.AddInt64(&failedNum, int64(num))
+			continue
+		}
+
+		for _, sr := range r.([]table.SetReply) {
+			if sr.ErrCode < 0 {
+				atomic.AddInt64(&failedNum, 1)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gotable-sentinel health-checks a master with PING. After the master failed
+// for a number of times in a row, it promotes the most up-to-date slaver to
+// master and makes the other slavers slave of the new master.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	master   = flag.String("m", "", "Master host address ip:port, the same as in slaveof")
+	slavers  = flag.String("s", "", "Slaver host addresses, such as \"ip:port,ip:port\"")
+	network  = flag.String("N", "tcp", "Server network: tcp, tcp4, tcp6, unix")
+	interval = flag.Duration("i", time.Second, "Health check interval")
+	timeout  = flag.Duration("t", time.Second, "Timeout of every health check")
+	failures = flag.Int("n", 3, "Number of failed checks in a row to start failover")
+	password = flag.String("p", "", "Admin password")
+	force    = flag.Bool("force", false, "Promote a slaver still doing incremental sync")
+)
+
+func main() {
+	flag.Parse()
+
+	var slaverAddrs []string
+	for _, addr := range strings.Split(*slavers, ",") {
+		addr = strings.TrimSpace(addr)
+		if len(addr) > 0 {
+			slaverAddrs = append(slaverAddrs, addr)
+		}
+	}
+
+	if len(*master) == 0 || len(slaverAddrs) == 0 || *failures <= 0 {
+		fmt.Printf("Invalid master or slavers\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	log.Printf("Monitor master %s, slavers %v\n", *master, slaverAddrs)
+
+	var failed int
+	for {
+		time.Sleep(*interval)
+
+		err := ping(*master)
+		if err == nil {
+			failed = 0
+			continue
+		}
+
+		failed++
+		log.Printf("Ping master %s failed(%d): %s\n", *master, failed, err)
+		if failed < *failures {
+			continue
+		}
+
+		newMaster, err := failover(*master, slaverAddrs)
+		if err != nil {
+			log.Printf("Failover failed: %s\n", err)
+			continue
+		}
+
+		log.Printf("Master %s is down, please make it slave of %s "+
+			"after it is repaired\n", *master, newMaster)
+
+		*master = newMaster
+		slaverAddrs = removeAddr(slaverAddrs, newMaster)
+		failed = 0
+	}
+}
+
+func dial(addr string) (*table.Client, error) {
+	conn, err := net.DialTimeout(*network, addr, *timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return table.NewClient(conn), nil
+}
+
+// ping checks the server with PING in timeout.
+func ping(addr string) error {
+	client, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var done = make(chan error, 1)
+	go func() {
+		done <- client.NewContext(0).Ping()
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(*timeout):
+		return fmt.Errorf("timeout")
+	}
+}
+
+// adminCall connects to the server, authorizes with the admin password and
+// runs f, all in timeout. The connection of a hung server is closed, so it
+// is skipped instead of blocking the failover.
+func adminCall(addr string, f func(cc *table.CtrlContext) error) error {
+	client, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var done = make(chan error, 1)
+	go func() {
+		var tc = client.NewContext(proto.AdminDbId)
+		if len(*password) > 0 {
+			err := tc.Auth(*password)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+
+		var cc = table.CtrlContext(*tc)
+		done <- f(&cc)
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(*timeout):
+		return fmt.Errorf("timeout")
+	}
+}
+
+// failover promotes the slaver of master with the largest binlog seq, and
+// returns the new master address.
+func failover(master string, slaverAddrs []string) (string, error) {
+	var best string
+	var bestSeq uint64
+	for _, addr := range slaverAddrs {
+		st, err := replStatus(addr)
+		if err != nil {
+			log.Printf("Get replication status of %s failed: %s\n", addr, err)
+			continue
+		}
+
+		if st.MasterAddr != master || st.Migration {
+			log.Printf("Skip %s, which is not slaver of %s\n", addr, master)
+			continue
+		}
+		if st.Status != ctrl.SlaverReady &&
+			!(*force && st.Status == ctrl.SlaverIncrSync) {
+			log.Printf("Skip %s, which is not ready (status %d)\n",
+				addr, st.Status)
+			continue
+		}
+
+		if len(best) == 0 || st.LastSeq > bestSeq {
+			best, bestSeq = addr, st.LastSeq
+		}
+	}
+
+	if len(best) == 0 {
+		return "", fmt.Errorf("no slaver can be promoted")
+	}
+
+	var lastSeq uint64
+	err := adminCall(best, func(cc *table.CtrlContext) error {
+		var err error
+		lastSeq, err = cc.Promote(*force)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("promote %s failed: %s", best, err)
+	}
+
+	log.Printf("Promoted %s to master, lastSeq %d\n", best, lastSeq)
+
+	for _, addr := range slaverAddrs {
+		if addr == best {
+			continue
+		}
+
+		err = slaveOf(addr, best)
+		if err != nil {
+			log.Printf("Make %s slave of %s failed: %s\n", addr, best, err)
+		} else {
+			log.Printf("Make %s slave of %s\n", addr, best)
+		}
+	}
+
+	return best, nil
+}
+
+func replStatus(addr string) (ctrl.PkgReplStatus, error) {
+	var st ctrl.PkgReplStatus
+	err := adminCall(addr, func(cc *table.CtrlContext) error {
+		var err error
+		st, err = cc.ReplStatus()
+		return err
+	})
+	if err != nil {
+		return ctrl.PkgReplStatus{}, err
+	}
+	return st, nil
+}
+
+func slaveOf(addr, master string) error {
+	return adminCall(addr, func(cc *table.CtrlContext) error {
+		return cc.SlaveOf(master)
+	})
+}
+
+func removeAddr(addrs []string, addr string) []string {
+	var res []string
+	for _, a := range addrs {
+		if a != addr {
+			res = append(res, a)
+		}
+	}
+	return res
+}
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/stevejiang/gotable/config"
 	"github.com/stevejiang/gotable/server"
 	"log"
@@ -27,17 +29,35 @@ import (
 	"syscall"
 )
 
+var (
+	restoreDir = flag.String("restore", "", "Restore data from the backup directory before start")
+	masterAddr = flag.String("master", "", "Master address ip:port for the restored server to sync from")
+)
+
 func main() {
-	var configFile string
-	if len(os.Args) > 1 {
-		configFile = os.Args[1]
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [config file]\n", os.Args[0])
+		flag.PrintDefaults()
 	}
+	flag.Parse()
+
+	var configFile = flag.Arg(0)
 
 	conf, err := config.Load(configFile)
 	if err != nil {
 		log.Fatalf("Failed to load config: %s", err)
 	}
 
+	if len(*restoreDir) > 0 {
+		lastSeq, err := server.Restore(conf, *restoreDir, *masterAddr)
+		if err != nil {
+			log.Fatalf("Failed to restore from %s: %s", *restoreDir, err)
+		}
+		log.Printf("Restored from %s, lastSeq %d\n", *restoreDir, lastSeq)
+	} else if len(*masterAddr) > 0 {
+		log.Fatalf("Option -master is only valid with -restore")
+	}
+
 	var maxProcs = conf.Db.MaxCpuNum
 	if maxProcs <= 0 {
 		maxProcs = runtime.NumCPU()
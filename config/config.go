@@ -15,15 +15,21 @@
 package config
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"log"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Db      database `toml:"database"`
-	Bin     binlog   `toml:"binlog"`
+	Db      database    `toml:"database"`
+	Bin     binlog      `toml:"binlog"`
+	Repl    replication `toml:"replication"`
 	Auth    auth
+	Cluster cluster
 	Profile profile
+	CFs     []ColumnFamily `toml:"column_family"`
 }
 
 type database struct {
@@ -34,6 +40,21 @@ type database struct {
 	WriteBufSize int   `toml:"write_buffer_size"`
 	CacheSize    int64 `toml:"cache_size"`
 	Compression  string
+	CompactDel   bool   `toml:"compact_after_delete"` // Compact deleted units
+	Engine       string // Storage engine: rocksdb (default), memory
+
+	// RocksDB tuning, 0 or empty means the default value
+	BlockSize        int      `toml:"block_size"`
+	BloomBits        int      `toml:"bloom_bits"` // Default 10 bits per key
+	MaxBgJobs        int      `toml:"max_background_jobs"`
+	MaxWriteBufNum   int      `toml:"max_write_buffer_number"`
+	CompactionStyle  string   `toml:"compaction_style"` // level, universal
+	NumLevels        int      `toml:"num_levels"`
+	LevelBaseSize    int64    `toml:"max_bytes_for_level_base"`
+	LevelMultiplier  float64  `toml:"max_bytes_for_level_multiplier"`
+	TargetFileSize   int64    `toml:"target_file_size_base"`
+	LevelCompression []string `toml:"compression_per_level"`
+	RateLimit        int64    `toml:"rate_limit"` // Compaction and flush bytes/s
 }
 
 type binlog struct {
@@ -41,15 +62,186 @@ type binlog struct {
 	KeepNum int `toml:"keep_num"`
 }
 
+type replication struct {
+	SyncWindow     int `toml:"sync_window"`      // Max unacked sync data (MB)
+	FullSyncRate   int `toml:"full_sync_rate"`   // Max full sync rate (MB/s)
+	MinSyncSlavers int `toml:"min_sync_slavers"` // Slavers to apply semi-sync writes
+	SyncTimeout    int `toml:"sync_timeout"`     // Semi-sync timeout (ms)
+	ReadWaitTime   int `toml:"read_wait_time"`   // Max wait time of session read (ms)
+}
+
 type auth struct {
 	AdminPwd string `toml:"admin_password"`
 }
 
+type cluster struct {
+	Address string // This server ip:port in the cluster map, empty: no cluster
+}
+
 type profile struct {
 	Memory string
 	Host   string
 }
 
+// RocksDB default number of levels
+const DefaultNumLevels = 7
+
+// A RocksDB column family with its own options. Data of the mapped DBs and
+// tables are stored in it, other data stays in the default column family.
+type ColumnFamily struct {
+	Name         string
+	Tables       []string // "dbId" for a whole DB, or "dbId:tableId"
+	Compression  string   // The same as database compression if empty
+	WriteBufSize int      `toml:"write_buffer_size"` // 0: database value
+	BlockSize    int      `toml:"block_size"`        // 0: RocksDB default
+	BloomBits    int      `toml:"bloom_bits"`        // 0: 10 bits per key
+	TTL          int64    `toml:"ttl"`               // Seconds, 0: no TTL
+}
+
+// ParseTable parses "dbId" or "dbId:tableId" of Tables.
+// tableId is -1 for a whole DB.
+func ParseTable(s string) (dbId int, tableId int, err error) {
+	var fields = strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) > 2 {
+		return 0, 0, fmt.Errorf("invalid table %q", s)
+	}
+
+	dbId, err = strconv.Atoi(fields[0])
+	if err != nil || dbId < 0 || dbId >= 255 {
+		return 0, 0, fmt.Errorf("invalid dbId of table %q", s)
+	}
+	if len(fields) == 1 {
+		return dbId, -1, nil
+	}
+
+	tableId, err = strconv.Atoi(fields[1])
+	if err != nil || tableId < 0 || tableId > 255 {
+		return 0, 0, fmt.Errorf("invalid tableId of table %q", s)
+	}
+	return dbId, tableId, nil
+}
+
+func (conf *Config) check() error {
+	err := conf.Db.check()
+	if err != nil {
+		return err
+	}
+
+	var names = make(map[string]bool)
+	var tables = make(map[[2]int]string)
+	for _, cf := range conf.CFs {
+		if len(cf.Name) == 0 || cf.Name == "default" || names[cf.Name] {
+			return fmt.Errorf("invalid or duplicated column family name %q",
+				cf.Name)
+		}
+		names[cf.Name] = true
+
+		if !validCompression(cf.Compression) {
+			return fmt.Errorf("invalid compression %q of column family %s",
+				cf.Compression, cf.Name)
+		}
+		if cf.WriteBufSize < 0 || cf.BlockSize < 0 || cf.BloomBits < 0 ||
+			cf.TTL < 0 {
+			return fmt.Errorf("negative option of column family %s", cf.Name)
+		}
+		if len(cf.Tables) == 0 {
+			return fmt.Errorf("no table mapped to column family %s", cf.Name)
+		}
+
+		for _, t := range cf.Tables {
+			dbId, tableId, err := ParseTable(t)
+			if err != nil {
+				return fmt.Errorf("%s of column family %s", err, cf.Name)
+			}
+			var key = [2]int{dbId, tableId}
+			if old, ok := tables[key]; ok {
+				return fmt.Errorf("table %q mapped to both %s and %s",
+					t, old, cf.Name)
+			}
+			tables[key] = cf.Name
+		}
+	}
+
+	return nil
+}
+
+func (db *database) check() error {
+	switch db.Engine {
+	case "", "rocksdb", "memory":
+	default:
+		return fmt.Errorf("invalid engine %q", db.Engine)
+	}
+	if !validCompression(db.Compression) {
+		return fmt.Errorf("invalid compression %q", db.Compression)
+	}
+	if db.WriteBufSize < 0 || db.CacheSize < 0 {
+		return fmt.Errorf("negative write_buffer_size or cache_size")
+	}
+
+	var opts = []struct {
+		name  string
+		value int64
+	}{
+		{"block_size", int64(db.BlockSize)},
+		{"bloom_bits", int64(db.BloomBits)},
+		{"max_background_jobs", int64(db.MaxBgJobs)},
+		{"max_write_buffer_number", int64(db.MaxWriteBufNum)},
+		{"num_levels", int64(db.NumLevels)},
+		{"max_bytes_for_level_base", db.LevelBaseSize},
+		{"target_file_size_base", db.TargetFileSize},
+		{"rate_limit", db.RateLimit},
+	}
+	for _, o := range opts {
+		if o.value < 0 {
+			return fmt.Errorf("negative %s %d", o.name, o.value)
+		}
+	}
+
+	if db.BloomBits > 64 {
+		return fmt.Errorf("bloom_bits %d out of range [0 ~ 64]", db.BloomBits)
+	}
+	if db.MaxWriteBufNum == 1 {
+		return fmt.Errorf("max_write_buffer_number should be at least 2")
+	}
+	if db.NumLevels == 1 {
+		return fmt.Errorf("num_levels should be at least 2")
+	}
+	if db.LevelMultiplier != 0 && db.LevelMultiplier < 1 {
+		return fmt.Errorf("max_bytes_for_level_multiplier %g less than 1",
+			db.LevelMultiplier)
+	}
+
+	switch db.CompactionStyle {
+	case "", "level", "universal":
+	default:
+		return fmt.Errorf("invalid compaction_style %q", db.CompactionStyle)
+	}
+
+	var numLevels = db.NumLevels
+	if numLevels == 0 {
+		numLevels = DefaultNumLevels
+	}
+	if len(db.LevelCompression) > numLevels {
+		return fmt.Errorf("compression_per_level has %d levels, more than "+
+			"num_levels %d", len(db.LevelCompression), numLevels)
+	}
+	for i, c := range db.LevelCompression {
+		if len(c) == 0 || !validCompression(c) {
+			return fmt.Errorf("invalid compression %q of level %d", c, i)
+		}
+	}
+
+	return nil
+}
+
+func validCompression(c string) bool {
+	switch c {
+	case "", "no", "snappy", "zlib", "bzip2", "lz4", "lz4hc":
+		return true
+	}
+	return false
+}
+
 func Load(fileName string) (*Config, error) {
 	var conf Config
 	var err error
@@ -61,6 +253,11 @@ func Load(fileName string) (*Config, error) {
 		_, err = toml.DecodeFile(fileName, &conf)
 	}
 
+	if err != nil {
+		return nil, err
+	}
+
+	err = conf.check()
 	if err != nil {
 		return nil, err
 	}
@@ -75,9 +272,17 @@ data = "data"
 write_buffer_size = 67108864
 cache_size = 67108864
 compression = "snappy"
+compact_after_delete = true
 
 [binlog]
 memory_size = 8
 keep_num = 50
 
+[replication]
+sync_window = 16
+full_sync_rate = 0
+min_sync_slavers = 0
+sync_timeout = 1000
+read_wait_time = 500
+
 `
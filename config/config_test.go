@@ -0,0 +1,94 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/BurntSushi/toml"
+	"testing"
+)
+
+func decodeTestDB(s string, t *testing.T) *Config {
+	var conf Config
+	_, err := toml.Decode("[database]\n"+s, &conf)
+	if err != nil {
+		t.Fatalf("Decode %q failed: %s", s, err)
+	}
+	return &conf
+}
+
+func TestLoadDefault(t *testing.T) {
+	conf, err := Load("")
+	if err != nil {
+		t.Fatalf("Load default configuration failed: %s", err)
+	}
+	if conf.Db.Compression != "snappy" || conf.Db.BlockSize != 0 {
+		t.Fatalf("Invalid default configuration %+v", conf.Db)
+	}
+
+	_, err = Load("../gotable.conf")
+	if err != nil {
+		t.Fatalf("Load gotable.conf failed: %s", err)
+	}
+}
+
+func TestCheckRocksDBOptions(t *testing.T) {
+	var conf = decodeTestDB(`
+block_size = 16384
+bloom_bits = 16
+max_background_jobs = 4
+max_write_buffer_number = 4
+compaction_style = "universal"
+num_levels = 4
+max_bytes_for_level_base = 268435456
+max_bytes_for_level_multiplier = 8.0
+target_file_size_base = 67108864
+compression_per_level = ["no", "snappy", "lz4", "zlib"]
+rate_limit = 10485760
+`, t)
+	err := conf.check()
+	if err != nil {
+		t.Fatalf("Valid options failed: %s", err)
+	}
+	if conf.Db.BlockSize != 16384 || conf.Db.LevelMultiplier != 8 ||
+		len(conf.Db.LevelCompression) != 4 || conf.Db.RateLimit != 10485760 {
+		t.Fatalf("Options not decoded: %+v", conf.Db)
+	}
+
+	var invalid = []string{
+		`engine = "leveldb"`,
+		`compression = "gzip"`,
+		`block_size = -1`,
+		`bloom_bits = -1`,
+		`bloom_bits = 65`,
+		`max_background_jobs = -1`,
+		`max_write_buffer_number = 1`,
+		`num_levels = 1`,
+		`max_bytes_for_level_base = -1`,
+		`max_bytes_for_level_multiplier = 0.5`,
+		`target_file_size_base = -1`,
+		`rate_limit = -1`,
+		`compaction_style = "fifo"`,
+		`compression_per_level = ["no", "", "snappy"]`,
+		`compression_per_level = ["no", "gzip"]`,
+		`compression_per_level = ["no", "no", "no", "no", "no", "no", "no", "no"]`,
+		"num_levels = 2\ncompression_per_level = [\"no\", \"no\", \"no\"]",
+	}
+	for _, s := range invalid {
+		err = decodeTestDB(s, t).check()
+		if err == nil {
+			t.Fatalf("Check %q should fail", s)
+		}
+	}
+}
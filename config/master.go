@@ -29,17 +29,26 @@ const (
 )
 
 type MasterInfo struct {
-	MasterAddr string // Master address ip:host
-	SlaverAddr string // This server address ip:host
-	Migration  bool   // true: Migration; false: Normal master/slaver
-	UnitId     uint16 // Only meaningful for migration
-	Status     int    // Status of Slaver/Migration
+	MasterAddr string       // Master address ip:host
+	SlaverAddr string       // This server address ip:host
+	Migration  bool         // true: Migration; false: Normal master/slaver
+	Units      ctrl.UnitSet // Only meaningful for migration
+	Status     int          // Status of Slaver/Migration
+
+	// Progress of the unfinished full sync, used to resume full sync
+	FullSyncSeq uint64 // Master binlog seq of the full sync snapshot
+	FullSyncKey []byte // The last synced raw key
 }
 
 type MasterEncoding struct {
 	HasMaster bool // true: Has master; false: No master/No migration
 	MasterInfo
-	LastTime time.Time // Last change time
+	LastTime   time.Time    // Last change time
+	MovedUnits ctrl.UnitSet // Units migrated out, writes are refused
+
+	// Moved units whose new server has not switched in cutover,
+	// their data is kept until cutover is finished or rolled back
+	CutoverUnits ctrl.UnitSet
 }
 
 type MasterConfig struct {
@@ -47,6 +56,10 @@ type MasterConfig struct {
 
 	mtx sync.RWMutex // protects following
 	m   MasterEncoding
+
+	// Loaded from the cluster map, not saved in the config file
+	cluster    bool         // true: only serve ownedUnits
+	ownedUnits ctrl.UnitSet // Units of this server in the cluster map
 }
 
 func NewMasterConfig(dir string) *MasterConfig {
@@ -170,8 +183,10 @@ func (mc *MasterConfig) SetMaster(masterAddr, slaverAddr string) error {
 		m.MasterAddr = masterAddr
 		m.SlaverAddr = slaverAddr
 		m.Migration = false
-		m.UnitId = ctrl.TotalUnitNum // Exceed
+		m.Units = nil
 		m.Status = ctrl.SlaverInit
+		m.FullSyncSeq = 0
+		m.FullSyncKey = nil
 	} else {
 		m.HasMaster = false
 		m.LastTime = time.Now()
@@ -181,7 +196,8 @@ func (mc *MasterConfig) SetMaster(masterAddr, slaverAddr string) error {
 	return mc.save(&m)
 }
 
-func (mc *MasterConfig) SetMigration(masterAddr, slaverAddr string, unitId uint16) error {
+func (mc *MasterConfig) SetMigration(masterAddr, slaverAddr string,
+	units ctrl.UnitSet) error {
 	mc.mtx.RLock()
 	var m = mc.m
 	mc.mtx.RUnlock()
@@ -195,20 +211,28 @@ func (mc *MasterConfig) SetMigration(masterAddr, slaverAddr string, unitId uint1
 	}
 
 	if len(masterAddr) > 0 {
-		if m.HasMaster && m.Migration && m.UnitId != unitId {
+		if m.HasMaster && m.Migration && m.Units.String() != units.String() {
 			return fmt.Errorf("cannot start more than 1 migration")
 		}
-		if unitId >= ctrl.TotalUnitNum {
+		if len(units) == 0 {
+			return fmt.Errorf("no unit to migrate")
+		}
+		if units[len(units)-1] >= ctrl.TotalUnitNum {
 			return fmt.Errorf("migrate unit id out of range")
 		}
 
+		// The units are moving back
+		m.MovedUnits = m.MovedUnits.Remove(units)
+
 		m.HasMaster = true
 		m.LastTime = time.Now()
 		m.MasterAddr = masterAddr
 		m.SlaverAddr = slaverAddr
 		m.Migration = true
-		m.UnitId = unitId
+		m.Units = units
 		m.Status = ctrl.SlaverInit
+		m.FullSyncSeq = 0
+		m.FullSyncKey = nil
 	} else {
 		m.HasMaster = false
 		m.LastTime = time.Now()
@@ -241,6 +265,21 @@ func (mc *MasterConfig) SetStatus(status int) error {
 	}
 }
 
+// SetFullSync saves the progress of full sync. Clear it with (0, nil).
+func (mc *MasterConfig) SetFullSync(seq uint64, rawKey []byte) error {
+	mc.mtx.Lock()
+	if !mc.m.HasMaster {
+		mc.mtx.Unlock()
+		return nil
+	}
+	mc.m.FullSyncSeq = seq
+	mc.m.FullSyncKey = rawKey
+	var m = mc.m
+	mc.mtx.Unlock()
+
+	return mc.save(&m)
+}
+
 func (mc *MasterConfig) Status() int {
 	var st int = ctrl.NotSlaver
 	mc.mtx.RLock()
@@ -267,16 +306,111 @@ func (mc *MasterConfig) GetMaster() MasterInfo {
 	return m
 }
 
-func (mc *MasterConfig) GetMasterUnit() (bool, bool, uint16) {
+// GetMasterUnit returns whether has master, whether is migration and the
+// units under migration. The units should not be modified.
+func (mc *MasterConfig) GetMasterUnit() (bool, bool, ctrl.UnitSet) {
 	var hasMaster, migration bool
-	var unitId uint16
+	var units ctrl.UnitSet
 	mc.mtx.RLock()
 	if mc.m.HasMaster {
 		hasMaster = true
 		migration = mc.m.Migration
-		unitId = mc.m.UnitId
+		units = mc.m.Units
+	}
+	mc.mtx.RUnlock()
+
+	return hasMaster, migration, units
+}
+
+// MovedUnits returns the units migrated out of this server.
+// The result should not be modified.
+func (mc *MasterConfig) MovedUnits() ctrl.UnitSet {
+	mc.mtx.RLock()
+	var units = mc.m.MovedUnits
+	mc.mtx.RUnlock()
+	return units
+}
+
+// OwnedUnits returns whether in cluster mode, and the units served by this
+// server in the cluster map. The result should not be modified.
+func (mc *MasterConfig) OwnedUnits() (bool, ctrl.UnitSet) {
+	mc.mtx.RLock()
+	var cluster, units = mc.cluster, mc.ownedUnits
+	mc.mtx.RUnlock()
+	return cluster, units
+}
+
+// SetOwnedUnits switches to cluster mode, only the units are served.
+func (mc *MasterConfig) SetOwnedUnits(units ctrl.UnitSet) {
+	mc.mtx.Lock()
+	mc.cluster = true
+	mc.ownedUnits = units
+	mc.mtx.Unlock()
+}
+
+// AddOwnedUnits serves the units migrated in before the cluster map is
+// updated. Nothing changes if not in cluster mode.
+func (mc *MasterConfig) AddOwnedUnits(units ctrl.UnitSet) {
+	mc.mtx.Lock()
+	if mc.cluster {
+		// Copy on write, the old set may be in use
+		mc.ownedUnits = mc.ownedUnits.Add(units)
 	}
+	mc.mtx.Unlock()
+}
+
+// CutoverUnits returns the moved units waiting for the new server to switch.
+// The result should not be modified.
+func (mc *MasterConfig) CutoverUnits() ctrl.UnitSet {
+	mc.mtx.RLock()
+	var units = mc.m.CutoverUnits
+	mc.mtx.RUnlock()
+	return units
+}
+
+// SetCutoverPending marks the moved units as waiting for the new server to
+// switch if pending is true, otherwise unmarks them.
+func (mc *MasterConfig) SetCutoverPending(units ctrl.UnitSet, pending bool) error {
+	mc.mtx.RLock()
+	var m = mc.m
 	mc.mtx.RUnlock()
 
-	return hasMaster, migration, unitId
+	if pending {
+		m.CutoverUnits = m.CutoverUnits.Add(units)
+	} else if len(m.CutoverUnits.Remove(units)) == len(m.CutoverUnits) {
+		return nil // Nothing changed
+	} else {
+		m.CutoverUnits = m.CutoverUnits.Remove(units)
+	}
+
+	return mc.save(&m)
+}
+
+// RollbackCutover makes the units waiting for the new server writable again.
+func (mc *MasterConfig) RollbackCutover(units ctrl.UnitSet) error {
+	mc.mtx.RLock()
+	var m = mc.m
+	mc.mtx.RUnlock()
+
+	m.CutoverUnits = m.CutoverUnits.Remove(units)
+	m.MovedUnits = m.MovedUnits.Remove(units)
+
+	return mc.save(&m)
+}
+
+// SetUnitsMoved marks the units as migrated out of this server if moved is
+// true, writes to the units are refused from now on. Otherwise unmarks them.
+func (mc *MasterConfig) SetUnitsMoved(units ctrl.UnitSet, moved bool) error {
+	mc.mtx.RLock()
+	var m = mc.m
+	mc.mtx.RUnlock()
+
+	// Copy on write, the old set may be in use
+	if moved {
+		m.MovedUnits = m.MovedUnits.Add(units)
+	} else {
+		m.MovedUnits = m.MovedUnits.Remove(units)
+	}
+
+	return mc.save(&m)
 }
@@ -0,0 +1,250 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"sort"
+)
+
+const MaxNameLen = 64
+
+// Metadata of a DB. The name is optional.
+type DBInfo struct {
+	DbId uint8
+	Name string `json:",omitempty"` // Unique DB name
+	Desc string `json:",omitempty"` // Description
+}
+
+// Metadata of a table. Tables are still identified by dbId and tableId,
+// the name is optional.
+type TableInfo struct {
+	DbId    uint8
+	TableId uint8
+	Name    string            `json:",omitempty"` // Unique table name in the DB
+	Desc    string            `json:",omitempty"` // Description
+	Options map[string]string `json:",omitempty"` // Per-table options
+}
+
+// Catalog of the DBs and tables, sorted by dbId and tableId.
+type Catalog struct {
+	Version uint64 // Increased every time the catalog is changed
+	DBs     []DBInfo
+	Tables  []TableInfo
+}
+
+// Check whether every DB and table appears once and the names are unique.
+// DBs and tables are sorted by dbId and tableId at first.
+func (c *Catalog) Check() error {
+	sort.Sort(dbInfos(c.DBs))
+	sort.Sort(tableInfos(c.Tables))
+
+	var dbNames = make(map[string]bool)
+	for i, d := range c.DBs {
+		if d.DbId == proto.AdminDbId {
+			return fmt.Errorf("admin DB not allowed")
+		}
+		if i > 0 && c.DBs[i-1].DbId == d.DbId {
+			return fmt.Errorf("duplicated DB %d", d.DbId)
+		}
+		if len(d.Name) == 0 {
+			continue
+		}
+		err := CheckName(d.Name)
+		if err != nil {
+			return err
+		}
+		if dbNames[d.Name] {
+			return fmt.Errorf("duplicated DB name %s", d.Name)
+		}
+		dbNames[d.Name] = true
+	}
+
+	var names = make(map[string]bool)
+	for i, t := range c.Tables {
+		if t.DbId == proto.AdminDbId {
+			return fmt.Errorf("table %d of admin DB not allowed", t.TableId)
+		}
+		if i > 0 && !tableInfos(c.Tables).Less(i-1, i) {
+			return fmt.Errorf("duplicated table %d of DB %d", t.TableId, t.DbId)
+		}
+		if len(t.Name) == 0 {
+			continue
+		}
+		err := CheckName(t.Name)
+		if err != nil {
+			return err
+		}
+		var key = fmt.Sprintf("%d:%s", t.DbId, t.Name)
+		if names[key] {
+			return fmt.Errorf("duplicated table name %s in DB %d",
+				t.Name, t.DbId)
+		}
+		names[key] = true
+	}
+
+	return nil
+}
+
+// FindDB finds the DB. Return nil if not found.
+func (c *Catalog) FindDB(dbId uint8) *DBInfo {
+	for i := 0; i < len(c.DBs); i++ {
+		if c.DBs[i].DbId == dbId {
+			return &c.DBs[i]
+		}
+	}
+	return nil
+}
+
+// FindDBName finds the DB by name. Return nil if not found.
+func (c *Catalog) FindDBName(name string) *DBInfo {
+	for i := 0; i < len(c.DBs); i++ {
+		if c.DBs[i].Name == name {
+			return &c.DBs[i]
+		}
+	}
+	return nil
+}
+
+// SetDB adds the DB or replaces the old one.
+func (c *Catalog) SetDB(d DBInfo) {
+	if old := c.FindDB(d.DbId); old != nil {
+		*old = d
+	} else {
+		c.DBs = append(c.DBs, d)
+		sort.Sort(dbInfos(c.DBs))
+	}
+}
+
+// DelDB removes the DB. Tables of the DB are kept.
+// Return false if not found.
+func (c *Catalog) DelDB(dbId uint8) bool {
+	for i := 0; i < len(c.DBs); i++ {
+		if c.DBs[i].DbId == dbId {
+			c.DBs = append(c.DBs[:i], c.DBs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Find the table. Return nil if not found.
+func (c *Catalog) Find(dbId, tableId uint8) *TableInfo {
+	var i = sort.Search(len(c.Tables), func(i int) bool {
+		var t = &c.Tables[i]
+		return t.DbId > dbId || (t.DbId == dbId && t.TableId >= tableId)
+	})
+	if i < len(c.Tables) && c.Tables[i].DbId == dbId &&
+		c.Tables[i].TableId == tableId {
+		return &c.Tables[i]
+	}
+	return nil
+}
+
+// FindName finds the table of the DB by name. Return nil if not found.
+func (c *Catalog) FindName(dbId uint8, name string) *TableInfo {
+	for i := 0; i < len(c.Tables); i++ {
+		if c.Tables[i].DbId == dbId && c.Tables[i].Name == name {
+			return &c.Tables[i]
+		}
+	}
+	return nil
+}
+
+// Set adds the table or replaces the old one.
+func (c *Catalog) Set(t TableInfo) {
+	if old := c.Find(t.DbId, t.TableId); old != nil {
+		*old = t
+	} else {
+		c.Tables = append(c.Tables, t)
+		sort.Sort(tableInfos(c.Tables))
+	}
+}
+
+// Del removes the table. Return false if not found.
+func (c *Catalog) Del(dbId, tableId uint8) bool {
+	for i := 0; i < len(c.Tables); i++ {
+		if c.Tables[i].DbId == dbId && c.Tables[i].TableId == tableId {
+			c.Tables = append(c.Tables[:i], c.Tables[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// CheckName checks whether the name is valid. A name starts with a letter,
+// and has only letters, digits, '_', '-' and '.', so that it never looks
+// like a number.
+func CheckName(name string) error {
+	if len(name) == 0 || len(name) > MaxNameLen {
+		return fmt.Errorf("name length out of range [1 ~ %d]", MaxNameLen)
+	}
+	for i := 0; i < len(name); i++ {
+		var c = name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'):
+		default:
+			return fmt.Errorf("invalid name %q", name)
+		}
+	}
+	return nil
+}
+
+type dbInfos []DBInfo
+
+func (a dbInfos) Len() int           { return len(a) }
+func (a dbInfos) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a dbInfos) Less(i, j int) bool { return a[i].DbId < a[j].DbId }
+
+type tableInfos []TableInfo
+
+func (a tableInfos) Len() int      { return len(a) }
+func (a tableInfos) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a tableInfos) Less(i, j int) bool {
+	return a[i].DbId < a[j].DbId ||
+		(a[i].DbId == a[j].DbId && a[i].TableId < a[j].TableId)
+}
+
+// Estimated statistics of a table
+type TableStats struct {
+	DbId    uint8
+	TableId uint8
+	Name    string `json:",omitempty"` // Name in catalog
+	Size    uint64 // Approximate bytes on disk, data in memory excluded
+	Keys    uint64 // Approximate number of keys
+}
+
+// Get/Set/Del a DB or a table in the catalog. Version of the catalog is set
+// by server. The whole catalog is replied.
+type PkgCatalog struct {
+	Set     bool      // Add or replace DB or Table
+	Del     bool      // Delete DB or Table (only the ids are used)
+	IsDB    bool      // Change DB instead of Table
+	DB      DBInfo    // The DB to change
+	Table   TableInfo // The table to change
+	Catalog Catalog   // The catalog replied
+	ErrMsg  string    // error msg, nil means no error
+}
+
+// List tables with data or in catalog, and get their statistics.
+type PkgTableStats struct {
+	AllDB    bool         // Tables of all DBs, or only the DB of head
+	AllTable bool         // All tables of the DB, or only TableId
+	TableId  uint8        // The table if AllTable is false
+	Tables   []TableStats // Statistics replied
+	ErrMsg   string       // error msg, nil means no error
+}
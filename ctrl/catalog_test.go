@@ -0,0 +1,50 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"testing"
+)
+
+func TestCatalogCheck(t *testing.T) {
+	var c Catalog
+	c.DBs = []DBInfo{{DbId: 2, Name: "b"}, {DbId: 1, Name: "a"}, {DbId: 3}}
+	c.Tables = []TableInfo{{DbId: 1, TableId: 2, Name: "t"},
+		{DbId: 1, TableId: 1}, {DbId: 2, TableId: 2, Name: "t"}}
+	err := c.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %s", err)
+	}
+	if c.DBs[0].DbId != 1 || c.Tables[0].TableId != 1 {
+		t.Fatalf("Catalog not sorted")
+	}
+
+	var bad = []Catalog{
+		{DBs: []DBInfo{{DbId: 1}, {DbId: 1}}},
+		{DBs: []DBInfo{{DbId: 1, Name: "a"}, {DbId: 2, Name: "a"}}},
+		{DBs: []DBInfo{{DbId: proto.AdminDbId}}},
+		{DBs: []DBInfo{{DbId: 1, Name: "1a"}}},
+		{Tables: []TableInfo{{DbId: 1, TableId: 1}, {DbId: 1, TableId: 1}}},
+		{Tables: []TableInfo{{DbId: 1, TableId: 1, Name: "t"},
+			{DbId: 1, TableId: 2, Name: "t"}}},
+		{Tables: []TableInfo{{DbId: proto.AdminDbId, TableId: 1}}},
+	}
+	for i, c := range bad {
+		if c.Check() == nil {
+			t.Fatalf("Check of catalog %d should fail", i)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A range of units served by the same master/slaver servers
+type UnitRange struct {
+	StartUnitId uint16   // The first unit of the range
+	EndUnitId   uint16   // The last unit of the range (included)
+	Master      string   // Master ip:host
+	Slavers     []string // Slavers ip:host
+}
+
+// Cluster routing table, maps every unit to the servers.
+// Ranges are sorted by unitId and cover all units without overlap.
+type ClusterMap struct {
+	Version uint64 // Increased every time the map is changed
+	Ranges  []UnitRange
+}
+
+// Check whether the map covers every unit exactly once.
+// Ranges are sorted by StartUnitId at first.
+func (m *ClusterMap) Check() error {
+	sort.Sort(unitRanges(m.Ranges))
+
+	var next int
+	for _, r := range m.Ranges {
+		if int(r.StartUnitId) != next {
+			return fmt.Errorf("unit %d not covered or overlapped", next)
+		}
+		if r.EndUnitId < r.StartUnitId {
+			return fmt.Errorf("invalid range %d-%d", r.StartUnitId, r.EndUnitId)
+		}
+		if len(r.Master) == 0 {
+			return fmt.Errorf("no master for range %d-%d",
+				r.StartUnitId, r.EndUnitId)
+		}
+		next = int(r.EndUnitId) + 1
+	}
+
+	if next != TotalUnitNum {
+		return fmt.Errorf("unit %d not covered", next)
+	}
+
+	return nil
+}
+
+// Lookup the range of the unit. Return nil if not found.
+func (m *ClusterMap) Lookup(unitId uint16) *UnitRange {
+	var i = sort.Search(len(m.Ranges), func(i int) bool {
+		return m.Ranges[i].EndUnitId >= unitId
+	})
+	if i < len(m.Ranges) && m.Ranges[i].StartUnitId <= unitId {
+		return &m.Ranges[i]
+	}
+	return nil
+}
+
+// ServerUnits returns the units of the ranges served by addr,
+// as either master or slaver.
+func (m *ClusterMap) ServerUnits(addr string) UnitSet {
+	var units UnitSet
+	for _, r := range m.Ranges {
+		var served = r.Master == addr
+		for i := 0; !served && i < len(r.Slavers); i++ {
+			served = r.Slavers[i] == addr
+		}
+		if served {
+			units = units.Add(NewUnitRange(r.StartUnitId, r.EndUnitId))
+		}
+	}
+	return units
+}
+
+type unitRanges []UnitRange
+
+func (a unitRanges) Len() int           { return len(a) }
+func (a unitRanges) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a unitRanges) Less(i, j int) bool { return a[i].StartUnitId < a[j].StartUnitId }
+
+// Get/Set the cluster routing table on the config server.
+// Version of the map is set by server.
+type PkgCluster struct {
+	Set    bool       // true: replace the map; false: get the map
+	Map    ClusterMap // The cluster routing table
+	ErrMsg string     // error msg, nil means no error
+}
@@ -14,6 +14,10 @@
 
 package ctrl
 
+import (
+	"time"
+)
+
 // Slaver/Migration status
 const (
 	NotSlaver       = iota // Not a normal slaver (also not a migration slaver)
@@ -32,6 +36,12 @@ type PkgSlaveOf struct {
 	SlaverAddr string // ip:host
 	LastSeq    uint64
 	ErrMsg     string // error msg, nil means no error
+
+	// Resume the unfinished full sync if FullSyncSeq > 0
+	FullSyncSeq uint64 // Master binlog seq of the full sync snapshot
+	FullSyncKey []byte // Resume after this raw key
+
+	SyncAck bool // Slaver sends SYNCACK, master can enable flow control
 }
 
 // Migrate command pkg.
@@ -43,24 +53,150 @@ type PkgSlaveOf struct {
 // 2. switch proxy and client requests routed to new servers
 // 3. wait for 10 seconds, and close the master/slaver connection
 // 4. delete the unit data from old servers
+// Or send the Cutover command to the old server to do all these steps.
 type PkgMigrate struct {
-	ClientReq  bool   // true: from client api; false: from slaver to master
-	MasterAddr string // ip:host, stop migration if empty
-	SlaverAddr string // ip:host
-	UnitId     uint16 // The unit to be migrated
-	ErrMsg     string // error msg, nil means no error
+	ClientReq  bool    // true: from client api; false: from slaver to master
+	MasterAddr string  // ip:host, stop migration if empty
+	SlaverAddr string  // ip:host
+	UnitId     uint16  // The unit to be migrated
+	Units      UnitSet // The units to be migrated in one session, UnitId if empty
+	ErrMsg     string  // error msg, nil means no error
+	SyncAck    bool    // Slaver sends SYNCACK, master can enable flow control
+	Cancel     bool    // Stop migration and delete the migrated data
+}
+
+// MigUnits returns the units to be migrated.
+func (p *PkgMigrate) MigUnits() UnitSet {
+	if len(p.Units) > 0 {
+		return NewUnitSet(p.Units)
+	}
+	return UnitSet{p.UnitId}
+}
+
+// Finish migration of a unit on the old server (migration master).
+// All units migrated in the same session as the unit are finished together.
+// Writes to the unit are refused, and when the new server has applied all
+// writes in Timeout, it switches to normal status, then the unit data is
+// deleted from the old server. If it is not switched in Timeout, send it
+// again to finish, or cancel the migration on the old server to roll back.
+type PkgCutover struct {
+	UnitId  uint16 // The unit under migration
+	Timeout int    // Max milliseconds to wait for the new server
+	ErrMsg  string // error msg, nil means no error
 }
 
 // Get migration/slaver status
 type PkgSlaverStatus struct {
 	Migration bool   // true: Migration status; false: Normal slaver status
-	UnitId    uint16 // The unit under migration
+	UnitId    uint16 // A unit under migration
 	Status    int
 	ErrMsg    string // error msg, nil means no error
 }
 
+// Replication status of a connected slaver, reported by master
+type SlaverSync struct {
+	SlaverAddr  string
+	Migration   bool
+	UnitId      uint16    // Only meaningful for migration, the first unit
+	Units       UnitSet   // Only meaningful for migration
+	FullSync    bool      // true: doing full sync; false: incremental sync
+	SyncRecords uint64    // Records sent in full sync
+	SyncBytes   uint64    // Bytes sent in full sync
+	AckSeq      uint64    // The last seq applied by slaver
+	LagRecords  uint64    // Number of records not applied by slaver
+	LagSeconds  float64   // Seconds since the oldest record not applied
+	BytesBehind uint64    // Bytes sent but not applied by slaver
+	LastAck     time.Time // Time of the last SYNCACK, zero if never acked
+}
+
+// Get replication status of the server as master and slaver
+type PkgReplStatus struct {
+	LastSeq uint64 // The last binlog seq of the server
+
+	// Slaver side, only meaningful if MasterAddr is not empty
+	MasterAddr   string
+	Migration    bool
+	Status       int       // Slaver/Migration status
+	AppliedSeq   uint64    // The last master seq applied
+	AppliedBytes uint64    // Bytes applied since connected to master
+	LastAck      time.Time // Time of the last SYNCACK sent to master
+
+	// Master side
+	Slavers []SlaverSync
+
+	ErrMsg string // error msg, nil means no error
+}
+
+// Promote a normal slaver to master.
+// The slaver should be SlaverReady, or SlaverIncrSync if Force is true.
+type PkgPromote struct {
+	Force   bool   // Promote even if the slaver has not caught up
+	LastSeq uint64 // The last binlog seq after promoted, set by server
+	ErrMsg  string // error msg, nil means no error
+}
+
 // Delete unit data
 type PkgDelUnit struct {
-	UnitId uint16 // The unit to delete
-	ErrMsg string // error msg, nil means no error
+	UnitId uint16  // The unit to delete
+	Units  UnitSet // The units to delete, UnitId if empty
+	ErrMsg string  // error msg, nil means no error
+}
+
+// DelUnits returns the units to delete.
+func (p *PkgDelUnit) DelUnits() UnitSet {
+	if len(p.Units) > 0 {
+		return NewUnitSet(p.Units)
+	}
+	return UnitSet{p.UnitId}
+}
+
+// Drop a table or a whole DB. The DB is DbId of the package head.
+// The package is written to binlog as it is, so that slavers drop the same
+// data in the same order of the other writes.
+type PkgDrop struct {
+	TableId uint8   // The table to drop, ignored if DropDB is true
+	DropDB  bool    // Drop the whole DB
+	Units   UnitSet // Only drop data of these units, all units if empty
+	ErrMsg  string  // error msg, nil means no error
+}
+
+// Compaction scope
+const (
+	CompactDB    = 0 // The DB of the package head
+	CompactTable = 1 // TableId of the DB
+	CompactUnits = 2 // Units [StartUnit, EndUnit]
+	CompactAll   = 3 // The whole key space
+)
+
+// Compact data on the server with RocksDB manual compaction. Slavers are not
+// touched. Only one compaction runs at a time.
+type PkgCompact struct {
+	Scope     uint8
+	TableId   uint8  // For CompactTable
+	StartUnit uint16 // For CompactUnits
+	EndUnit   uint16 // For CompactUnits
+	ErrMsg    string // error msg, nil means no error
+}
+
+// RocksDB property value of a column family
+type PropertyValue struct {
+	CF    string // Column family name
+	Value string
+}
+
+// Get RocksDB property, such as "rocksdb.stats", "rocksdb.levelstats" and
+// "rocksdb.estimate-num-keys".
+type PkgProperty struct {
+	Name   string
+	Values []PropertyValue // Values of all column families with the property
+	ErrMsg string          // error msg, nil means no error
+}
+
+// Create online backup on the server side.
+// Dir should not exist and is better on the same file system as the data
+// directory, so that SST files can be hard-linked instead of copied.
+type PkgBackup struct {
+	Dir     string // Backup directory on the server
+	LastSeq uint64 // Binlog seq of the backup, set by server
+	ErrMsg  string // error msg, nil means no error
 }
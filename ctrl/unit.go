@@ -15,7 +15,11 @@
 package ctrl
 
 import (
+	"fmt"
 	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -26,3 +30,128 @@ func GetUnitId(dbId, tableId uint8, rowKey []byte) uint16 {
 	var a = crc32.Update(0, crc32.IEEETable, []byte{dbId, tableId})
 	return uint16(crc32.Update(a, crc32.IEEETable, rowKey) % TotalUnitNum)
 }
+
+// A sorted set of units without duplication
+type UnitSet []uint16
+
+// NewUnitSet creates a set of the units, duplicated units are removed.
+func NewUnitSet(units []uint16) UnitSet {
+	var s = make(UnitSet, len(units))
+	copy(s, units)
+	sort.Sort(s)
+
+	var n int
+	for i := 0; i < len(s); i++ {
+		if n == 0 || s[n-1] != s[i] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	return s[:n]
+}
+
+// NewUnitRange creates a set of units [startUnitId, endUnitId].
+func NewUnitRange(startUnitId, endUnitId uint16) UnitSet {
+	var s UnitSet
+	for u := int(startUnitId); u <= int(endUnitId); u++ {
+		s = append(s, uint16(u))
+	}
+	return s
+}
+
+// ParseUnitSet parses units such as "1-5,9".
+func ParseUnitSet(str string) (UnitSet, error) {
+	var units []uint16
+	for _, field := range strings.Split(str, ",") {
+		var se = strings.SplitN(strings.TrimSpace(field), "-", 2)
+		start, err := parseUnitId(se[0])
+		if err != nil {
+			return nil, err
+		}
+		var end = start
+		if len(se) > 1 {
+			end, err = parseUnitId(se[1])
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid unit range %s", field)
+			}
+		}
+		units = append(units, NewUnitRange(start, end)...)
+	}
+
+	return NewUnitSet(units), nil
+}
+
+func parseUnitId(str string) (uint16, error) {
+	unitId, err := strconv.Atoi(str)
+	if err != nil || unitId < 0 || unitId >= TotalUnitNum {
+		return 0, fmt.Errorf("invalid unit id %s", str)
+	}
+	return uint16(unitId), nil
+}
+
+func (s UnitSet) Len() int           { return len(s) }
+func (s UnitSet) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s UnitSet) Less(i, j int) bool { return s[i] < s[j] }
+
+// Has returns whether the unit is in the set.
+func (s UnitSet) Has(unitId uint16) bool {
+	var i = sort.Search(len(s), func(i int) bool { return s[i] >= unitId })
+	return i < len(s) && s[i] == unitId
+}
+
+// Next returns the smallest unit not less than unitId in the set.
+func (s UnitSet) Next(unitId uint16) (uint16, bool) {
+	var i = sort.Search(len(s), func(i int) bool { return s[i] >= unitId })
+	if i < len(s) {
+		return s[i], true
+	}
+	return 0, false
+}
+
+// Add returns a new set with units of both sets.
+func (s UnitSet) Add(a UnitSet) UnitSet {
+	var units = make([]uint16, 0, len(s)+len(a))
+	units = append(units, s...)
+	units = append(units, a...)
+	return NewUnitSet(units)
+}
+
+// Remove returns a new set without units in a.
+func (s UnitSet) Remove(a UnitSet) UnitSet {
+	var res UnitSet
+	for _, u := range s {
+		if !a.Has(u) {
+			res = append(res, u)
+		}
+	}
+	return res
+}
+
+// Ranges returns the continuous ranges [start, end] of the set.
+func (s UnitSet) Ranges() [][2]uint16 {
+	var rs [][2]uint16
+	for i := 0; i < len(s); i++ {
+		if len(rs) > 0 && rs[len(rs)-1][1]+1 == s[i] {
+			rs[len(rs)-1][1] = s[i]
+		} else {
+			rs = append(rs, [2]uint16{s[i], s[i]})
+		}
+	}
+	return rs
+}
+
+// String formats the set such as "1-5,9".
+func (s UnitSet) String() string {
+	var fields []string
+	for _, r := range s.Ranges() {
+		if r[0] == r[1] {
+			fields = append(fields, strconv.Itoa(int(r[0])))
+		} else {
+			fields = append(fields, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctrl
+
+import (
+	"testing"
+)
+
+func TestUnitSetParse(t *testing.T) {
+	s, err := ParseUnitSet("9, 1-5,3,8191,6-6")
+	if err != nil {
+		t.Fatalf("ParseUnitSet failed: %s", err)
+	}
+	if len(s) != 8 || s.String() != "1-6,9,8191" {
+		t.Fatalf("Invalid unit set %v", []uint16(s))
+	}
+
+	for _, str := range []string{"", "a", "-1", "5-1", "8192", "1-8192", "1,,2"} {
+		_, err = ParseUnitSet(str)
+		if err == nil {
+			t.Fatalf("ParseUnitSet %q should fail", str)
+		}
+	}
+}
+
+func TestUnitSetOps(t *testing.T) {
+	var s = NewUnitSet([]uint16{7, 3, 5, 3})
+	if len(s) != 3 || s[0] != 3 || s[2] != 7 {
+		t.Fatalf("Invalid unit set %v", []uint16(s))
+	}
+
+	if !s.Has(5) || s.Has(4) || s.Has(8) {
+		t.Fatalf("Has mismatch")
+	}
+	if u, ok := s.Next(4); !ok || u != 5 {
+		t.Fatalf("Next of 4: %d %v", u, ok)
+	}
+	if _, ok := s.Next(8); ok {
+		t.Fatalf("No unit after 7")
+	}
+
+	// Add and Remove return new sets
+	var a = s.Add(NewUnitRange(4, 6))
+	if a.String() != "3-7" || s.String() != "3,5,7" {
+		t.Fatalf("Add mismatch: %s, %s", a, s)
+	}
+	var r = a.Remove(UnitSet{4, 7, 100})
+	if r.String() != "3,5-6" || a.String() != "3-7" {
+		t.Fatalf("Remove mismatch: %s, %s", r, a)
+	}
+	if len(r.Remove(r)) != 0 {
+		t.Fatalf("Remove all mismatch")
+	}
+
+	var rs = NewUnitRange(0, TotalUnitNum-1).Ranges()
+	if len(rs) != 1 || rs[0] != [2]uint16{0, TotalUnitNum - 1} {
+		t.Fatalf("Ranges mismatch: %v", rs)
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/ctrl"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	backupInfoFile   = "backup.json"
+	masterConfigFile = "master.conf"
+)
+
+// Backup information, saved in the backup directory
+type BackupInfo struct {
+	LastSeq uint64    // Binlog seq of the data snapshot
+	Time    time.Time // Backup time
+}
+
+// Backup directory layout:
+// dir/table        RocksDB checkpoint of data/table
+// dir/config       Copy of data/config
+// dir/backup.json  BackupInfo
+func backupTableDir(dir string) string {
+	return fmt.Sprintf("%s/table", dir)
+}
+
+func backupConfigDir(dir string) string {
+	return fmt.Sprintf("%s/config", dir)
+}
+
+func backupInfoName(dir string) string {
+	return fmt.Sprintf("%s/%s", dir, backupInfoFile)
+}
+
+func (srv *Server) backup(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgBackup
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			p.LastSeq, err = srv.createBackup(p.Dir)
+			if err != nil {
+				log.Printf("Backup to %s failed: %s\n", p.Dir, err)
+				p.ErrMsg = fmt.Sprintf("backup failed %s", err)
+			} else {
+				log.Printf("Backup to %s succeeds, lastSeq %d\n", p.Dir, p.LastSeq)
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Backup command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) createBackup(dir string) (uint64, error) {
+	if len(dir) == 0 {
+		return 0, errors.New("empty backup directory")
+	}
+
+	m := srv.mc.GetMaster()
+	if len(m.MasterAddr) > 0 {
+		if m.Migration {
+			return 0, errors.New("cannot backup during migration")
+		}
+		if m.Status != ctrl.SlaverIncrSync && m.Status != ctrl.SlaverReady {
+			return 0, fmt.Errorf("slaver is not synced, status %d", m.Status)
+		}
+	}
+
+	_, err := os.Stat(dir)
+	if err == nil {
+		return 0, fmt.Errorf("directory %s already exists", dir)
+	}
+
+	err = os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+
+	lastSeq, err := srv.doBackup(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return 0, err
+	}
+
+	return lastSeq, nil
+}
+
+func (srv *Server) doBackup(dir string) (uint64, error) {
+	// Flush the memtables without blocking writes, so that the checkpoint
+	// only flushes the few writes after it
+	var err = srv.tbl.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	// Stop write while creating the checkpoint, every write applied
+	// has got its binlog seq
+	srv.wrMtx.Lock()
+	var lastSeq = srv.lastSeq()
+	err = srv.tbl.Checkpoint(backupTableDir(dir))
+	srv.wrMtx.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = copyMasterConfig(ConfigDirName(srv.conf), backupConfigDir(dir))
+	if err != nil {
+		return 0, err
+	}
+
+	var info = BackupInfo{lastSeq, time.Now()}
+	err = writeBackupInfo(backupInfoName(dir), &info)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastSeq, nil
+}
+
+// Restore prepares the data directory of conf from a backup directory.
+// The data directory must not have table or binlog data. If masterAddr is
+// not empty, the server runs as a slaver of masterAddr and resumes
+// incremental sync from the backup seq; otherwise the master/slaver config
+// in the backup is used.
+func Restore(conf *config.Config, backupDir, masterAddr string) (uint64, error) {
+	var info BackupInfo
+	var err = readBackupInfo(backupInfoName(backupDir), &info)
+	if err != nil {
+		return 0, err
+	}
+
+	var tableDir = TableDirName(conf)
+	var binlogDir = BinLogDirName(conf)
+	for _, d := range []string{tableDir, binlogDir} {
+		_, err = os.Stat(d)
+		if err == nil {
+			return 0, fmt.Errorf("directory %s already exists", d)
+		}
+	}
+
+	log.Printf("Restore table from %s to %s\n", backupDir, tableDir)
+	err = linkOrCopyDir(backupTableDir(backupDir), tableDir)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.LastSeq > 0 {
+		err = binlog.InitLogSeq(binlogDir, info.LastSeq)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var configDir = ConfigDirName(conf)
+	err = copyMasterConfig(backupConfigDir(backupDir), configDir)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(masterAddr) > 0 {
+		mc := config.NewMasterConfig(configDir)
+		if mc == nil {
+			return 0, errors.New("failed to load master config")
+		}
+
+		err = mc.SetMaster(masterAddr, conf.Db.Address)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return info.LastSeq, nil
+}
+
+func writeBackupInfo(name string, info *BackupInfo) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	en := json.NewEncoder(file)
+	err = en.Encode(info)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func readBackupInfo(name string, info *BackupInfo) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	de := json.NewDecoder(file)
+	return de.Decode(info)
+}
+
+func copyMasterConfig(srcDir, dstDir string) error {
+	var src = fmt.Sprintf("%s/%s", srcDir, masterConfigFile)
+	_, err := os.Stat(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // No master config
+		}
+		return err
+	}
+
+	err = os.MkdirAll(dstDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return copyFile(src, fmt.Sprintf("%s/%s", dstDir, masterConfigFile))
+}
+
+// linkOrCopyDir hard-links the immutable SST files of srcDir into dstDir,
+// and copies other files or the file whose hard link fails.
+func linkOrCopyDir(srcDir, dstDir string) error {
+	dir, err := os.Open(srcDir)
+	if err != nil {
+		return err
+	}
+
+	fi, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dstDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fi {
+		if f.IsDir() {
+			continue
+		}
+
+		var src = fmt.Sprintf("%s/%s", srcDir, f.Name())
+		var dst = fmt.Sprintf("%s/%s", dstDir, f.Name())
+		if strings.HasSuffix(f.Name(), ".sst") && os.Link(src, dst) == nil {
+			continue
+		}
+
+		err = copyFile(src, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+
+	return out.Sync()
+}
@@ -0,0 +1,252 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"os"
+	"testing"
+	"time"
+)
+
+func startTestServer(name, address string, t *testing.T) (*Server, *table.Client) {
+	return runTestServer(newTestConfig(name, address, t), t)
+}
+
+// newTestConfig creates the config of a test server with empty data.
+func newTestConfig(name, address string, t *testing.T) *config.Config {
+	conf, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load config failed: %s", err)
+	}
+
+	conf.Db.Network = "tcp"
+	conf.Db.Address = address
+	conf.Cluster.Address = address
+	conf.Db.Data = "/tmp/test_gotable/cascade/" + name
+	conf.Bin.MemSize = 1
+	if !store.HasEngine(store.EngineRocksDB) {
+		conf.Db.Engine = store.EngineMemory
+	}
+	os.RemoveAll(conf.Db.Data)
+	return conf
+}
+
+func runTestServer(conf *config.Config, t *testing.T) (*Server, *table.Client) {
+	var srv = NewServer(conf)
+	if srv == nil {
+		t.Fatalf("Failed to create server %s", conf.Db.Address)
+	}
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("Start server %s failed: %s", conf.Db.Address, err)
+	}
+	go srv.Serve()
+
+	cli, err := table.Dial("tcp", conf.Db.Address)
+	if err != nil {
+		t.Fatalf("Dial %s failed: %s", conf.Db.Address, err)
+	}
+	return srv, cli
+}
+
+func setTestKeys(c *table.Context, start, end int, t *testing.T) {
+	for i := start; i < end; i++ {
+		err := c.Set(1, []byte(fmt.Sprintf("row%d", i)), []byte("col"),
+			[]byte(fmt.Sprintf("v%d", i)), int64(i), 0)
+		if err != nil {
+			t.Fatalf("Set failed: %s", err)
+		}
+	}
+}
+
+// waitTestKeys waits until all keys are synced to the slaver.
+func waitTestKeys(c *table.Context, start, end int, t *testing.T) {
+	var deadline = time.Now().Add(time.Second * 30)
+	for i := start; i < end; {
+		value, score, _, err := c.Get(1, []byte(fmt.Sprintf("row%d", i)),
+			[]byte("col"), 0)
+		if err != nil {
+			t.Fatalf("Get failed: %s", err)
+		}
+		if value == nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("Key row%d not synced", i)
+			}
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		if !bytes.Equal(value, []byte(fmt.Sprintf("v%d", i))) || score != int64(i) {
+			t.Fatalf("Value mismatch: %q %d", value, score)
+		}
+		i++
+	}
+}
+
+func TestCascadeReplication(t *testing.T) {
+	var addrA, addrB, addrC = "127.0.0.1:26701", "127.0.0.1:26702", "127.0.0.1:26703"
+	var _, cliA = startTestServer("a", addrA, t)
+	var _, cliB = startTestServer("b", addrB, t)
+	var _, cliC = startTestServer("c", addrC, t)
+	defer cliA.Close()
+	defer cliB.Close()
+	defer cliC.Close()
+
+	var ca, cb, cc = cliA.NewContext(0), cliB.NewContext(0), cliC.NewContext(0)
+	setTestKeys(ca, 0, 100, t)
+
+	// A => B => C
+	var ctrlB = table.CtrlContext(*cb)
+	err := ctrlB.SlaveOf(addrA)
+	if err != nil {
+		t.Fatalf("B slaveof A failed: %s", err)
+	}
+	var ctrlC = table.CtrlContext(*cc)
+	err = ctrlC.SlaveOf(addrB)
+	if err != nil {
+		t.Fatalf("C slaveof B failed: %s", err)
+	}
+
+	waitTestKeys(cb, 0, 100, t)
+	waitTestKeys(cc, 0, 100, t)
+
+	// Incremental sync through B, the catalog is synced in order with keys
+	var ctrlA = table.CtrlContext(*ca)
+	err = ctrlA.SetTableInfo(ctrl.TableInfo{DbId: 0, TableId: 1, Name: "t1"})
+	if err != nil {
+		t.Fatalf("SetTableInfo failed: %s", err)
+	}
+	setTestKeys(ca, 100, 200, t)
+	waitTestKeys(cc, 100, 200, t)
+
+	catalog, err := ctrlC.GetCatalog()
+	if err != nil {
+		t.Fatalf("GetCatalog failed: %s", err)
+	}
+	if catalog.Version != 1 || catalog.FindName(0, "t1") == nil {
+		t.Fatalf("Catalog not synced: %+v", catalog)
+	}
+	err = ctrlB.SetTableInfo(ctrl.TableInfo{DbId: 0, TableId: 2, Name: "t2"})
+	if err == nil {
+		t.Fatalf("Change catalog on slaver B should fail")
+	}
+
+	// B is write protected, and reports both sides
+	err = cb.Set(1, []byte("row0"), []byte("col"), []byte("v"), 0, 0)
+	if err != table.ErrWriteSlaver {
+		t.Fatalf("Write slaver B should fail: %v", err)
+	}
+
+	var deadline = time.Now().Add(time.Second * 10)
+	for {
+		st, err := ctrlB.ReplStatus()
+		if err != nil {
+			t.Fatalf("ReplStatus failed: %s", err)
+		}
+		if st.MasterAddr != addrA {
+			t.Fatalf("Master of B mismatch: %s", st.MasterAddr)
+		}
+		if len(st.Slavers) == 1 && !st.Slavers[0].FullSync &&
+			st.Slavers[0].LagRecords == 0 && st.Status == ctrl.SlaverReady {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("B status not ready: %+v", st)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	// The same seq on all servers
+	stA, err := ctrlA.ReplStatus()
+	if err != nil {
+		t.Fatalf("ReplStatus failed: %s", err)
+	}
+	deadline = time.Now().Add(time.Second * 10)
+	for {
+		stC, err := ctrlC.ReplStatus()
+		if err != nil {
+			t.Fatalf("ReplStatus failed: %s", err)
+		}
+		if stC.LastSeq == stA.LastSeq {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Seq mismatch: A %d, C %d", stA.LastSeq, stC.LastSeq)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	// A and B serve the first half units in the cluster map, C serves none
+	var m = ctrl.ClusterMap{Ranges: []ctrl.UnitRange{
+		{StartUnitId: 0, EndUnitId: 4095, Master: addrA, Slavers: []string{addrB}},
+		{StartUnitId: 4096, EndUnitId: ctrl.TotalUnitNum - 1, Master: "127.0.0.1:26704"},
+	}}
+	_, err = ctrlB.SetCluster(m)
+	if err == nil {
+		t.Fatalf("Set cluster map on slaver B should fail")
+	}
+	_, err = ctrlA.SetCluster(m)
+	if err != nil {
+		t.Fatalf("SetCluster failed: %s", err)
+	}
+
+	var owned, other []byte
+	for i := 0; i < 200; i++ {
+		var rowKey = []byte(fmt.Sprintf("row%d", i))
+		if ctrl.GetUnitId(0, 1, rowKey) < 4096 {
+			owned = rowKey
+		} else {
+			other = rowKey
+		}
+	}
+	err = ca.Set(1, other, []byte("col"), []byte("v"), 0, 0)
+	if err != table.ErrMoved {
+		t.Fatalf("Write unit not served by A should fail: %v", err)
+	}
+	err = ca.Set(1, owned, []byte("col"), []byte("v"), 0, 0)
+	if err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	// The map is replicated to B and C
+	deadline = time.Now().Add(time.Second * 10)
+	for {
+		mc, err := ctrlC.GetCluster()
+		if err == nil && mc.Version == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Cluster map not synced: %+v, %v", mc, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	_, _, _, err = cb.Get(1, owned, []byte("col"), 0)
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	_, _, _, err = cb.Get(1, other, []byte("col"), 0)
+	if err != table.ErrMoved {
+		t.Fatalf("Read unit not served by B should fail: %v", err)
+	}
+	_, _, _, err = cc.Get(1, owned, []byte("col"), 0)
+	if err != table.ErrMoved {
+		t.Fatalf("Read unit not served by C should fail: %v", err)
+	}
+}
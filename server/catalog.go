@@ -0,0 +1,235 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"log"
+)
+
+// The table catalog is stored in the reserved admin table.
+// Any client can get it, only admin can change it on master.
+// Every change writes the whole new catalog to binlog, slavers replace
+// their catalog with it.
+func (srv *Server) catalog(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgCatalog
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if (p.Set || p.Del) && !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if (p.Set || p.Del) && !store.NewWriteAccess(false, srv.mc).Check() {
+			p.ErrMsg = "cannot change catalog on slaver"
+		} else {
+			p.Catalog, err = srv.getCatalog()
+			if err == nil && (p.Set || p.Del) {
+				err = srv.changeCatalog(&p.Catalog, &p)
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		var err = applyCatalog(srv.tbl, req.Pkg)
+		if err != nil {
+			log.Printf("Slaver CATALOG failed: [%d, %d] %s\n", req.DbId, req.Seq, err)
+		}
+		srv.sendResp(err == nil, req, nil)
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Catalog command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+// getCatalog reads the catalog. An empty catalog is returned if not set.
+func (srv *Server) getCatalog() (ctrl.Catalog, error) {
+	var c ctrl.Catalog
+	value, err := srv.tbl.GetAdminValue(store.KeyCatalog)
+	if err != nil {
+		return c, fmt.Errorf("read catalog failed(%s)", err)
+	}
+	if value == nil {
+		return c, nil
+	}
+
+	err = json.Unmarshal(value, &c)
+	if err != nil {
+		return c, fmt.Errorf("decode catalog failed(%s)", err)
+	}
+	return c, nil
+}
+
+// changeCatalog sets or deletes a DB or table, and saves the catalog with
+// a new version. Only called in the ctrl goroutine, so the catalog is not
+// changed by others between reading and saving it.
+func (srv *Server) changeCatalog(c *ctrl.Catalog, p *ctrl.PkgCatalog) error {
+	var t = p.Table
+	if p.IsDB {
+		if !p.Del {
+			c.SetDB(p.DB)
+		} else if !c.DelDB(p.DB.DbId) {
+			return fmt.Errorf("DB %d not in catalog", p.DB.DbId)
+		}
+	} else if p.Del {
+		if !c.Del(t.DbId, t.TableId) {
+			return fmt.Errorf("table %d of DB %d not in catalog",
+				t.TableId, t.DbId)
+		}
+	} else {
+		c.Set(t)
+	}
+
+	err := c.Check()
+	if err != nil {
+		return err
+	}
+	c.Version++
+
+	pkg, err := ctrl.Encode(proto.CmdCatalog, proto.AdminDbId, 0,
+		&ctrl.PkgCatalog{Set: true, Catalog: *c})
+	if err != nil {
+		return err
+	}
+
+	// Hold writes like other write commands, so that the binlog seq of the
+	// catalog is bound to full sync snapshots correctly
+	srv.wrMtx.RLock()
+	err = applyCatalog(srv.tbl, pkg)
+	if err == nil {
+		srv.bin.AddRequest(&binlog.Request{MasterSeq: 0, Pkg: pkg})
+	}
+	srv.wrMtx.RUnlock()
+	if err != nil {
+		return fmt.Errorf("write catalog failed(%s)", err)
+	}
+
+	log.Printf("Catalog changed to version %d, %d DBs, %d tables\n",
+		c.Version, len(c.DBs), len(c.Tables))
+	return nil
+}
+
+// applyCatalog saves the whole catalog of a CmdCatalog binlog record.
+func applyCatalog(tbl *store.Table, pkg []byte) error {
+	var p ctrl.PkgCatalog
+	var err = ctrl.Decode(pkg, nil, &p)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(&p.Catalog)
+	if err != nil {
+		return err
+	}
+
+	return tbl.SetAdminValue(store.KeyCatalog, value)
+}
+
+// List tables with data or in catalog, and estimate their sizes.
+func (srv *Server) tableStats(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgTableStats
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		p.Tables = nil
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if p.AllDB && !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if !p.AllDB && !req.Cli.IsAuth(req.DbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			p.Tables, err = srv.getTableStats(req.DbId, &p)
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for TableStats command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) getTableStats(dbId uint8,
+	p *ctrl.PkgTableStats) ([]ctrl.TableStats, error) {
+	c, err := srv.getCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []ctrl.TableStats
+	if p.AllDB || p.AllTable {
+		// Tables in catalog are listed even without data
+		var used = srv.tbl.ListTables(dbId, p.AllDB)
+		var i int
+		for _, t := range c.Tables {
+			if !p.AllDB && t.DbId != dbId {
+				continue
+			}
+			for ; i < len(used) && (used[i].DbId < t.DbId ||
+				(used[i].DbId == t.DbId && used[i].TableId < t.TableId)); i++ {
+				tables = append(tables, used[i])
+			}
+			if i < len(used) && used[i].DbId == t.DbId &&
+				used[i].TableId == t.TableId {
+				i++
+			}
+			tables = append(tables, ctrl.TableStats{DbId: t.DbId,
+				TableId: t.TableId, Name: t.Name})
+		}
+		tables = append(tables, used[i:]...)
+	} else {
+		var t = ctrl.TableStats{DbId: dbId, TableId: p.TableId}
+		if info := c.Find(dbId, p.TableId); info != nil {
+			t.Name = info.Name
+		}
+		tables = append(tables, t)
+	}
+
+	srv.tbl.GetTableStats(tables)
+	return tables, nil
+}
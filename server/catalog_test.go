@@ -0,0 +1,109 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/ctrl"
+	"testing"
+)
+
+func testTableId(c *table.Context, name string, t *testing.T) uint8 {
+	tbl, err := c.Table(name)
+	if err != nil {
+		t.Fatalf("Resolve table %s failed: %s", name, err)
+	}
+	return tbl.TableId()
+}
+
+func TestCatalogNames(t *testing.T) {
+	var addr = "127.0.0.1:26751"
+	var _, cli = startTestServer("catalog", addr, t)
+	defer cli.Close()
+	other, err := table.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial %s failed: %s", addr, err)
+	}
+	defer other.Close()
+
+	var c, oc = cli.NewContext(0), other.NewContext(0)
+	var cc = table.CtrlContext(*c)
+	err = cc.SetDBInfo(ctrl.DBInfo{DbId: 0, Name: "db0"})
+	if err != nil {
+		t.Fatalf("SetDBInfo failed: %s", err)
+	}
+	err = cc.SetTableInfo(ctrl.TableInfo{DbId: 0, TableId: 1, Name: "t1"})
+	if err != nil {
+		t.Fatalf("SetTableInfo failed: %s", err)
+	}
+	if id := testTableId(c, "t1", t); id != 1 {
+		t.Fatalf("Invalid table id %d", id)
+	}
+	if id := testTableId(oc, "t1", t); id != 1 {
+		t.Fatalf("Invalid table id %d", id)
+	}
+	if _, err = oc.Table("t9"); err == nil {
+		t.Fatalf("Table t9 should not be found")
+	}
+
+	// Move the name to another table, the cache of the changing client is
+	// replaced at once
+	tbl, _ := c.Table("t1")
+	err = cc.DelTableInfo(0, 1)
+	if err != nil {
+		t.Fatalf("DelTableInfo failed: %s", err)
+	}
+	err = cc.SetTableInfo(ctrl.TableInfo{DbId: 0, TableId: 2, Name: "t1"})
+	if err != nil {
+		t.Fatalf("SetTableInfo failed: %s", err)
+	}
+	if id := testTableId(c, "t1", t); id != 2 {
+		t.Fatalf("Name cache not replaced after catalog changed: %d", id)
+	}
+	if tbl.TableId() != 1 {
+		t.Fatalf("Resolved table changed: %d", tbl.TableId())
+	}
+
+	// Other clients read the change on refresh, or on a missing name
+	if id := testTableId(oc, "t1", t); id != 1 {
+		t.Fatalf("Name cache changed before refresh: %d", id)
+	}
+	err = other.RefreshCatalog()
+	if err != nil {
+		t.Fatalf("RefreshCatalog failed: %s", err)
+	}
+	if id := testTableId(oc, "t1", t); id != 2 {
+		t.Fatalf("Name cache not refreshed: %d", id)
+	}
+
+	err = cc.SetTableInfo(ctrl.TableInfo{DbId: 0, TableId: 9, Name: "t9"})
+	if err != nil {
+		t.Fatalf("SetTableInfo failed: %s", err)
+	}
+	if id := testTableId(oc, "t9", t); id != 9 {
+		t.Fatalf("Invalid table id %d", id)
+	}
+
+	err = cc.DelDBInfo(0)
+	if err != nil {
+		t.Fatalf("DelDBInfo failed: %s", err)
+	}
+	if _, err = cli.NewContextByName("db0"); err == nil {
+		t.Fatalf("DB db0 should not be found after deleted")
+	}
+	if _, err = other.NewContextByName("db0"); err != nil {
+		t.Fatalf("Cached DB db0 not found: %s", err)
+	}
+}
@@ -60,6 +60,7 @@ type Client struct {
 	mtx      sync.RWMutex
 	authBM   *util.BitMap
 	shutdown bool
+	ms       *master // Only for ClientTypeMaster
 }
 
 func NewClient(conn net.Conn, authEnabled bool) *Client {
@@ -161,6 +162,19 @@ func (c *Client) SetAuth(dbId uint8) {
 	}
 }
 
+func (c *Client) setMaster(ms *master) {
+	c.mtx.Lock()
+	c.ms = ms
+	c.mtx.Unlock()
+}
+
+func (c *Client) getMaster() *master {
+	c.mtx.RLock()
+	ms := c.ms
+	c.mtx.RUnlock()
+	return ms
+}
+
 func (c *Client) GoRecvRequest(ch *RequestChan, slv *slaver) {
 	var headBuf = make([]byte, proto.HeadSize)
 	var head proto.PkgHead
@@ -212,8 +226,40 @@ func (c *Client) GoRecvRequest(ch *RequestChan, slv *slaver) {
 			if ClientTypeNormal != c.ClientType() {
 				ch.SyncReqChan <- &req
 			}
+		case proto.CmdSyncAck:
+			if ms := c.getMaster(); ms != nil {
+				ms.ack(pkg)
+			}
 		case proto.CmdDump:
 			ch.DumpReqChan <- &req
+		case proto.CmdDrop:
+			if ClientTypeNormal == c.ClientType() {
+				ch.CtrlReqChan <- &req
+			} else {
+				ch.SyncReqChan <- &req
+			}
+		case proto.CmdCompact:
+			fallthrough
+		case proto.CmdProperty:
+			fallthrough
+		case proto.CmdTableSt:
+			fallthrough
+		case proto.CmdCatalog:
+			fallthrough
+		case proto.CmdCluster:
+			if ClientTypeNormal == c.ClientType() {
+				ch.CtrlReqChan <- &req
+			} else {
+				ch.SyncReqChan <- &req
+			}
+		case proto.CmdCutover:
+			fallthrough
+		case proto.CmdPromote:
+			fallthrough
+		case proto.CmdReplSt:
+			fallthrough
+		case proto.CmdBackup:
+			fallthrough
 		case proto.CmdDelUnit:
 			fallthrough
 		case proto.CmdSlaverSt:
@@ -0,0 +1,162 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"log"
+)
+
+// The cluster routing table is served by the config server, and stored in
+// the reserved admin table. Any client can get it, only admin can set it on
+// master. The new map is written to binlog, slavers replace their map with it.
+// Data nodes with cluster address configured only serve their own units in
+// the map of their admin table.
+func (srv *Server) cluster(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgCluster
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if p.Set && !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if p.Set && !store.NewWriteAccess(false, srv.mc).Check() {
+			p.ErrMsg = "cannot set cluster map on slaver"
+		} else {
+			if p.Set {
+				err = srv.setClusterMap(&p.Map)
+			} else {
+				p.Map, err = srv.getClusterMap()
+			}
+			if err != nil {
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		m, err := applyClusterMap(srv.tbl, req.Pkg)
+		if err != nil {
+			log.Printf("Slaver CLUSTER failed: [%d, %d] %s\n", req.DbId, req.Seq, err)
+		} else {
+			srv.loadOwnedUnits(&m)
+		}
+		srv.sendResp(err == nil, req, nil)
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Cluster command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) getClusterMap() (ctrl.ClusterMap, error) {
+	var m ctrl.ClusterMap
+	value, err := srv.tbl.GetAdminValue(store.KeyClusterMap)
+	if err != nil {
+		return m, fmt.Errorf("read cluster map failed(%s)", err)
+	}
+	if value == nil {
+		return m, fmt.Errorf("no cluster map")
+	}
+
+	err = json.Unmarshal(value, &m)
+	if err != nil {
+		return m, fmt.Errorf("decode cluster map failed(%s)", err)
+	}
+	return m, nil
+}
+
+// setClusterMap replaces the cluster map with a new version.
+// Only called in the ctrl goroutine, so no lock is needed.
+func (srv *Server) setClusterMap(m *ctrl.ClusterMap) error {
+	err := m.Check()
+	if err != nil {
+		return err
+	}
+
+	old, err := srv.getClusterMap()
+	if err != nil {
+		old.Version = 0
+	}
+	m.Version = old.Version + 1
+
+	pkg, err := ctrl.Encode(proto.CmdCluster, proto.AdminDbId, 0,
+		&ctrl.PkgCluster{Set: true, Map: *m})
+	if err != nil {
+		return err
+	}
+
+	// Hold writes like other write commands, so that the binlog seq of the
+	// map is bound to full sync snapshots correctly
+	srv.wrMtx.RLock()
+	_, err = applyClusterMap(srv.tbl, pkg)
+	if err == nil {
+		srv.bin.AddRequest(&binlog.Request{MasterSeq: 0, Pkg: pkg})
+	}
+	srv.wrMtx.RUnlock()
+	if err != nil {
+		return fmt.Errorf("write cluster map failed(%s)", err)
+	}
+
+	srv.loadOwnedUnits(m)
+	log.Printf("Cluster map changed to version %d, %d ranges\n",
+		m.Version, len(m.Ranges))
+	return nil
+}
+
+// applyClusterMap saves the whole map of a CmdCluster binlog record.
+func applyClusterMap(tbl *store.Table, pkg []byte) (ctrl.ClusterMap, error) {
+	var p ctrl.PkgCluster
+	var err = ctrl.Decode(pkg, nil, &p)
+	if err != nil {
+		return p.Map, err
+	}
+
+	value, err := json.Marshal(&p.Map)
+	if err != nil {
+		return p.Map, err
+	}
+
+	return p.Map, tbl.SetAdminValue(store.KeyClusterMap, value)
+}
+
+// loadOwnedUnits serves only the units of this server in the map, if the
+// cluster address is configured.
+func (srv *Server) loadOwnedUnits(m *ctrl.ClusterMap) {
+	var addr = srv.conf.Cluster.Address
+	if len(addr) == 0 {
+		return
+	}
+
+	var units = m.ServerUnits(addr)
+	srv.mc.SetOwnedUnits(units)
+	log.Printf("Serve units %s of %s in cluster map version %d\n",
+		units, addr, m.Version)
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Manual compaction on this server only. It may take a long time, so it runs
+// in its own goroutine and replies when finished.
+func (srv *Server) compact(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgCompact
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if p.Scope > ctrl.CompactAll {
+			p.ErrMsg = fmt.Sprintf("invalid compaction scope %d", p.Scope)
+		} else if p.Scope == ctrl.CompactUnits && (p.StartUnit > p.EndUnit ||
+			p.EndUnit >= ctrl.TotalUnitNum) {
+			p.ErrMsg = fmt.Sprintf("invalid unit range [%d, %d]",
+				p.StartUnit, p.EndUnit)
+		} else if !atomic.CompareAndSwapUint32(&srv.compacting, 0, 1) {
+			p.ErrMsg = "another compaction is running"
+		} else {
+			go srv.doCompact(req, p)
+			return
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Compact command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) doCompact(req *Request, p ctrl.PkgCompact) {
+	defer atomic.StoreUint32(&srv.compacting, 0)
+
+	var what string
+	switch p.Scope {
+	case ctrl.CompactDB:
+		what = fmt.Sprintf("DB %d", req.DbId)
+	case ctrl.CompactTable:
+		what = fmt.Sprintf("table %d of DB %d", p.TableId, req.DbId)
+	case ctrl.CompactUnits:
+		what = fmt.Sprintf("units [%d, %d]", p.StartUnit, p.EndUnit)
+	case ctrl.CompactAll:
+		what = "all data"
+	}
+
+	log.Printf("Compact %s start\n", what)
+	var start = time.Now()
+	switch p.Scope {
+	case ctrl.CompactDB:
+		srv.tbl.CompactDB(req.DbId)
+	case ctrl.CompactTable:
+		srv.tbl.CompactTable(req.DbId, p.TableId)
+	case ctrl.CompactUnits:
+		srv.tbl.CompactUnitRange(p.StartUnit, p.EndUnit)
+	case ctrl.CompactAll:
+		srv.tbl.CompactAll()
+	}
+	log.Printf("Compact %s finished in %s\n", what, time.Since(start))
+
+	pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+	if err == nil {
+		srv.sendResp(false, req, pkg)
+	}
+}
+
+// Get RocksDB property of every column family.
+func (srv *Server) property(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgProperty
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		p.Values = nil
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if !strings.HasPrefix(p.Name, "rocksdb.") {
+			p.ErrMsg = fmt.Sprintf("invalid property %q", p.Name)
+		} else {
+			p.Values = srv.tbl.GetProperty(p.Name)
+			if len(p.Values) == 0 {
+				p.ErrMsg = fmt.Sprintf("unknown property %q", p.Name)
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Property command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/ctrl"
+	"github.com/stevejiang/gotable/store"
+	"log"
+)
+
+// Drop a table or a DB. The request package is written to binlog as one
+// record, slavers apply it in order with the other writes.
+func (srv *Server) drop(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgDrop
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else if req.DbId == proto.AdminDbId {
+			p.ErrMsg = "cannot drop the admin DB"
+		} else if !store.NewWriteAccess(false, srv.mc).Check() {
+			p.ErrMsg = "cannot drop on slaver"
+		} else {
+			// Block all writes, so that the binlog order is the same as
+			// the order applied to DB
+			srv.wrMtx.Lock()
+			err = applyDrop(srv.tbl, req.Pkg)
+			if err == nil {
+				srv.bin.AddRequest(&binlog.Request{MasterSeq: 0, Pkg: req.Pkg})
+			}
+			srv.wrMtx.Unlock()
+
+			if err != nil {
+				p.ErrMsg = fmt.Sprintf("drop failed %s", err)
+			} else if p.DropDB {
+				log.Printf("Drop DB %d\n", req.DbId)
+			} else {
+				log.Printf("Drop table %d of DB %d\n", p.TableId, req.DbId)
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		var err = applyDrop(srv.tbl, req.Pkg)
+		if err != nil {
+			log.Printf("Slaver DROP failed: [%d, %d] %s\n", req.DbId, req.Seq, err)
+		}
+		srv.sendResp(err == nil, req, nil)
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Drop command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func applyDrop(tbl *store.Table, pkg []byte) error {
+	var head proto.PkgHead
+	var p ctrl.PkgDrop
+	var err = ctrl.Decode(pkg, &head, &p)
+	if err != nil {
+		return err
+	}
+
+	if p.DropDB {
+		return tbl.DropDB(head.DbId, p.Units)
+	}
+	return tbl.DropTable(head.DbId, p.TableId, p.Units)
+}
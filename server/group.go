@@ -0,0 +1,125 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/store"
+)
+
+// Max number of requests committed with one RocksDB write
+const maxGroupWrites = 256
+
+// Max number of grouped writes of a write goroutine not replied yet
+const maxPendingReplies = 256
+
+type groupReq struct {
+	req     *Request
+	gw      *store.GroupWrite
+	replies chan<- groupReply // Of the write goroutine
+}
+
+type groupReply struct {
+	req   *Request
+	pkg   []byte
+	write bool
+	seq   uint64
+}
+
+// groupReplier replies the grouped writes of a write goroutine. The replies
+// channel never blocks processGroup, as a write goroutine queues no more
+// than maxPendingReplies writes not replied.
+type groupReplier struct {
+	replies chan groupReply
+	pending int
+}
+
+func newGroupReplier() *groupReplier {
+	return &groupReplier{replies: make(chan groupReply, maxPendingReplies)}
+}
+
+// groupWrite prepares a SET, DEL, MSET or MDEL of a normal client and queues
+// it for group commit. It returns true if queued, then the read lock of
+// srv.wrMtx is released by processGroup after the write is logged.
+func (srv *Server) groupWrite(req *Request, gr *groupReplier) bool {
+	var wa = store.NewWriteAccess(false, srv.mc)
+	if !wa.Check() {
+		switch req.Cmd {
+		case proto.CmdMSet, proto.CmdMDel:
+			srv.replyMultiOp(req, table.EcWriteSlaver)
+		default:
+			srv.replyOneOp(req, table.EcWriteSlaver)
+		}
+		return false
+	}
+
+	var gw = srv.tbl.PrepareWrite(&req.PkgArgs, req.Cli, wa)
+	if !gw.Pending() {
+		pkg, ok := gw.Reply()
+		srv.sendResp(ok, req, pkg)
+		return false
+	}
+
+	gr.pending++
+	srv.groupChan <- groupReq{req, gw, gr.replies}
+	return true
+}
+
+// replyGroup sends the reply of a grouped write to its client. It is called
+// by the write goroutine, which may block on a client not reading.
+func (srv *Server) replyGroup(gr *groupReplier, r groupReply) {
+	gr.pending--
+	srv.reply(r.write, r.req, r.pkg, r.seq)
+}
+
+// processGroup commits the queued writes of all write goroutines in groups.
+// The writes of a group are logged in the queue order before their keys are
+// unlocked, so the binlog order of a key is its write order. Replies are
+// handed back to the write goroutines, so a client not reading its socket
+// never blocks the group commit.
+func (srv *Server) processGroup() {
+	var reqs = make([]groupReq, 0, maxGroupWrites)
+	var group = make([]*store.GroupWrite, 0, maxGroupWrites)
+	for {
+		reqs = append(reqs[:0], <-srv.groupChan)
+	more:
+		for len(reqs) < maxGroupWrites {
+			select {
+			case r := <-srv.groupChan:
+				reqs = append(reqs, r)
+			default:
+				break more
+			}
+		}
+
+		group = group[:0]
+		for _, r := range reqs {
+			group = append(group, r.gw)
+		}
+		srv.tbl.CommitGroup(group)
+
+		for i, r := range reqs {
+			pkg, ok := r.gw.Reply()
+			var seq = srv.logWrite(ok, r.req, pkg)
+			r.gw.Done()
+			srv.wrMtx.RUnlock() // Locked by processWrite
+			r.replies <- groupReply{r.req, pkg, ok, seq}
+
+			reqs[i] = groupReq{}
+			group[i] = nil
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Default time to wait for the new server in cutover
+	defaultCutoverTimeout = time.Second * 5
+	// Interval to check the new server in cutover
+	cutoverCheckInterval = time.Millisecond * 10
+)
+
+func (srv *Server) cutover(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgCutover
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			err = srv.doCutover(&p)
+			if err != nil {
+				log.Printf("Cutover failed: %s\n", err)
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Cutover command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+// doCutover moves the ownership of the unit to the migration slaver:
+// 1. refuse writes to the unit on this server;
+// 2. wait until the migration slaver has applied all writes of the unit;
+// 3. tell the migration slaver to switch to normal status;
+// 4. delete the unit data from this server.
+// If the migration slaver is not caught up in time, writes are accepted again.
+// If it is not switched in time, the units are kept but not writable, until
+// cutover is sent again to finish it, or the migration is cancelled on this
+// server to roll it back.
+func (srv *Server) doCutover(p *ctrl.PkgCutover) error {
+	var timeout = defaultCutoverTimeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Millisecond
+	}
+
+	if srv.mc.CutoverUnits().Has(p.UnitId) {
+		var ms = srv.findCutover(p.UnitId)
+		if ms == nil {
+			return fmt.Errorf("new server of unit %d is unknown after restart, "+
+				"delete the unit if the new server is switched, or cancel "+
+				"migration to roll back", p.UnitId)
+		}
+		return srv.switchUnits(ms, timeout)
+	}
+
+	var ms *master
+	for _, m := range srv.masters.Masters() {
+		if m.migration && m.units.Has(p.UnitId) && !m.IsClosed() {
+			ms = m
+			break
+		}
+	}
+	if ms == nil {
+		return fmt.Errorf("unit %d is not under migration", p.UnitId)
+	}
+	if atomic.LoadUint32(&ms.incrSync) == 0 {
+		return fmt.Errorf("full migration of units %s not finished", ms.units)
+	}
+
+	err := srv.mc.SetUnitsMoved(ms.units, true)
+	if err != nil {
+		return fmt.Errorf("set config failed(%s)", err)
+	}
+
+	// All writes to the unit before are bound to a binlog seq
+	srv.wrMtx.Lock()
+	var lastSeq = srv.lastSeq()
+	srv.wrMtx.Unlock()
+
+	var deadline = time.Now().Add(timeout)
+	for ms.AppliedSeq() < lastSeq {
+		if ms.IsClosed() || time.Now().After(deadline) {
+			srv.mc.SetUnitsMoved(ms.units, false)
+			return fmt.Errorf("%s not caught up, lastSeq %d, applied %d",
+				ms.slaveAddr, lastSeq, ms.AppliedSeq())
+		}
+		time.Sleep(cutoverCheckInterval)
+	}
+
+	ms.EndMigration()
+	return srv.switchUnits(ms, timeout)
+}
+
+// switchUnits waits until the migration slaver is switched, and deletes the
+// units. The migration slaver closes the connection after switched.
+func (srv *Server) switchUnits(ms *master, timeout time.Duration) error {
+	var deadline = time.Now().Add(timeout)
+	for !ms.IsClosed() {
+		if time.Now().After(deadline) {
+			if srv.findCutover(ms.units[0]) == nil {
+				srv.cutovers = append(srv.cutovers, ms)
+			}
+			err := srv.mc.SetCutoverPending(ms.units, true)
+			if err != nil {
+				return fmt.Errorf("set config failed(%s)", err)
+			}
+			return fmt.Errorf("%s not switched in time, units %s are kept "+
+				"but not writable, cutover again to finish, or cancel "+
+				"migration to roll back", ms.slaveAddr, ms.units)
+		}
+		time.Sleep(cutoverCheckInterval)
+	}
+
+	log.Printf("Units %s are moved to %s\n", ms.units, ms.slaveAddr)
+
+	err := srv.deleteUnits(ms.units)
+	if err != nil {
+		return fmt.Errorf("delete unit failed(%s)", err)
+	}
+
+	srv.removeCutover(ms)
+	err = srv.mc.SetCutoverPending(ms.units, false)
+	if err != nil {
+		return fmt.Errorf("set config failed(%s)", err)
+	}
+	return nil
+}
+
+// findCutover returns the migration master of the unit waiting for switch.
+func (srv *Server) findCutover(unitId uint16) *master {
+	for _, ms := range srv.cutovers {
+		if ms.units.Has(unitId) {
+			return ms
+		}
+	}
+	return nil
+}
+
+func (srv *Server) removeCutover(ms *master) {
+	for i := 0; i < len(srv.cutovers); i++ {
+		if srv.cutovers[i] == ms {
+			srv.cutovers = append(srv.cutovers[:i], srv.cutovers[i+1:]...)
+			return
+		}
+	}
+}
+
+// rollbackCutover makes the units of the cutover not switched writable again
+// on this server. Cancel the migration on the new server first, or the units
+// are writable on both servers if the new server switches later.
+func (srv *Server) rollbackCutover(unitId uint16) error {
+	var units = srv.mc.CutoverUnits()
+	if ms := srv.findCutover(unitId); ms != nil {
+		units = ms.units
+		srv.removeCutover(ms)
+		ms.Close()
+	}
+
+	log.Printf("Roll back cutover of units %s\n", units)
+	return srv.mc.RollbackCutover(units)
+}
+
+// endMigration switches the migration slaver to normal status, when the old
+// server tells all writes of the unit are applied.
+func (srv *Server) endMigration() {
+	m := srv.mc.GetMaster()
+	if !m.Migration {
+		return
+	}
+
+	err := srv.mc.SetMigration("", "", nil)
+	if err != nil {
+		log.Printf("Failed to end migration: %s\n", err)
+		return
+	}
+
+	// Serve the units before the cluster map is updated
+	srv.mc.AddOwnedUnits(m.Units)
+
+	srv.rwMtx.Lock()
+	slv := srv.slv
+	srv.slv = nil
+	srv.rwMtx.Unlock()
+
+	// Tell the old server by closing the connection
+	if slv != nil {
+		slv.Close()
+	}
+
+	log.Printf("Migration of units %s from %s finished\n",
+		m.Units, m.MasterAddr)
+}
+
+// cancelMigration stops the migration of the unit and deletes the migrated
+// data, including other units of the same migration. On the old server,
+// it rolls back the cutover of the unit not switched in time.
+func (srv *Server) cancelMigration(unitId uint16) error {
+	if srv.mc.CutoverUnits().Has(unitId) {
+		return srv.rollbackCutover(unitId)
+	}
+
+	m := srv.mc.GetMaster()
+	if !m.Migration || !m.Units.Has(unitId) {
+		return fmt.Errorf("unit %d is not under migration", unitId)
+	}
+
+	srv.rwMtx.Lock()
+	slv := srv.slv
+	srv.slv = nil
+	srv.rwMtx.Unlock()
+
+	if slv != nil {
+		slv.Close()
+	}
+
+	// Wait for the migrated data being applied
+	srv.wrMtx.Lock()
+	srv.wrMtx.Unlock()
+
+	if m.Status == ctrl.SlaverNeedClear {
+		// Not started, the old data of the unit is not touched
+		return srv.mc.SetMigration("", "", nil)
+	}
+
+	log.Printf("Cancel migration of units %s from %s\n", m.Units, m.MasterAddr)
+	return srv.deleteMigrationUnits(m.Units, m)
+}
+
+// deleteUnits deletes data of the units range by range.
+func (srv *Server) deleteUnits(units ctrl.UnitSet) error {
+	for _, r := range units.Ranges() {
+		err := srv.tbl.DeleteUnitRange(r[0], r[1], srv.conf.Db.CompactDel)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *Server) hasUnitsData(units ctrl.UnitSet) bool {
+	for _, r := range units.Ranges() {
+		if srv.tbl.HasUnitRangeData(r[0], r[1]) {
+			return true
+		}
+	}
+	return false
+}
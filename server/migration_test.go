@@ -0,0 +1,172 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/ctrl"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitMigration waits until the migration of the unit is caught up.
+func waitMigration(c *table.CtrlContext, unitId uint16, t *testing.T) {
+	var deadline = time.Now().Add(time.Second * 10)
+	for {
+		s, err := c.MigStatus(unitId)
+		if err == nil && !s.FullSync && s.LagRecords == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Migration of unit %d not caught up: %+v, %v", unitId, s, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestCutoverNotSwitched(t *testing.T) {
+	var addrA, addrB = "127.0.0.1:26711", "127.0.0.1:26712"
+	var srvA, cliA = startTestServer("mig_a", addrA, t)
+	var srvB, cliB = startTestServer("mig_b", addrB, t)
+	defer cliA.Close()
+	defer cliB.Close()
+
+	var ca, cb = cliA.NewContext(0), cliB.NewContext(0)
+	setTestKeys(ca, 0, 100, t)
+
+	var key1, key2 = []byte("row0"), []byte("row1")
+	var unit1, unit2 = ctrl.GetUnitId(0, 1, key1), ctrl.GetUnitId(0, 1, key2)
+	for i := 2; unit2 == unit1; i++ {
+		key2 = []byte(fmt.Sprintf("row%d", i))
+		unit2 = ctrl.GetUnitId(0, 1, key2)
+	}
+
+	var ctrlA, ctrlB = table.CtrlContext(*ca), table.CtrlContext(*cb)
+	err := ctrlB.Migrate(addrA, unit1)
+	if err != nil {
+		t.Fatalf("Migrate failed: %s", err)
+	}
+	waitMigration(&ctrlA, unit1, t)
+
+	// B cannot switch while its writes are locked
+	srvB.wrMtx.Lock()
+	err = ctrlA.Cutover(unit1, time.Millisecond*300)
+	if err == nil || !strings.Contains(err.Error(), "not switched in time") {
+		srvB.wrMtx.Unlock()
+		t.Fatalf("Cutover should not be switched in time: %v", err)
+	}
+	err = ca.Set(1, key1, []byte("col"), []byte("v"), 0, 0)
+	if err != table.ErrMoved {
+		srvB.wrMtx.Unlock()
+		t.Fatalf("Write unit in cutover should fail: %v", err)
+	}
+
+	// Cutover again finishes the switch
+	srvB.wrMtx.Unlock()
+	err = ctrlA.Cutover(unit1, time.Second*10)
+	if err != nil {
+		t.Fatalf("Cutover again failed: %s", err)
+	}
+	if len(srvA.mc.CutoverUnits()) != 0 {
+		t.Fatalf("Cutover units not cleared")
+	}
+	value, _, _, err := cb.Get(1, key1, []byte("col"), 0)
+	if err != nil || string(value) != "v0" {
+		t.Fatalf("Get from B failed: %q, %v", value, err)
+	}
+
+	// Cancel migration on A rolls back the cutover
+	err = ctrlB.Migrate(addrA, unit2)
+	if err != nil {
+		t.Fatalf("Migrate failed: %s", err)
+	}
+	waitMigration(&ctrlA, unit2, t)
+
+	srvB.wrMtx.Lock()
+	defer srvB.wrMtx.Unlock()
+	err = ctrlA.Cutover(unit2, time.Millisecond*300)
+	if err == nil || !strings.Contains(err.Error(), "not switched in time") {
+		t.Fatalf("Cutover should not be switched in time: %v", err)
+	}
+	err = ctrlA.CancelMigrate(unit2)
+	if err != nil {
+		t.Fatalf("Roll back cutover failed: %s", err)
+	}
+	err = ca.Set(1, key2, []byte("col"), []byte("v"), 0, 0)
+	if err != nil {
+		t.Fatalf("Write unit after rollback failed: %s", err)
+	}
+}
+
+func TestMigrateUnitSet(t *testing.T) {
+	var addrA, addrB = "127.0.0.1:26713", "127.0.0.1:26714"
+	var _, cliA = startTestServer("migset_a", addrA, t)
+	var _, cliB = startTestServer("migset_b", addrB, t)
+	defer cliA.Close()
+	defer cliB.Close()
+
+	var ca, cb = cliA.NewContext(0), cliB.NewContext(0)
+	setTestKeys(ca, 0, 100, t)
+
+	// Migrate units of row0 and row1 in one session, row2 is kept
+	var units ctrl.UnitSet
+	var kept []byte
+	for i := 0; len(units) < 2 || kept == nil; i++ {
+		var rowKey = []byte(fmt.Sprintf("row%d", i))
+		var unitId = ctrl.GetUnitId(0, 1, rowKey)
+		if len(units) < 2 && !units.Has(unitId) {
+			units = units.Add(ctrl.UnitSet{unitId})
+		} else if !units.Has(unitId) {
+			kept = rowKey
+		}
+	}
+
+	var ctrlA, ctrlB = table.CtrlContext(*ca), table.CtrlContext(*cb)
+	err := ctrlB.MigrateUnits(addrA, units)
+	if err != nil {
+		t.Fatalf("MigrateUnits failed: %s", err)
+	}
+	waitMigration(&ctrlA, units[0], t)
+	s, err := ctrlA.MigStatus(units[1])
+	if err != nil || s.Units.String() != units.String() {
+		t.Fatalf("Units not in one session: %+v, %v", s, err)
+	}
+
+	// Cutover of any unit finishes all of them
+	err = ctrlA.Cutover(units[1], time.Second*10)
+	if err != nil {
+		t.Fatalf("Cutover failed: %s", err)
+	}
+	for i := 0; i < 100; i++ {
+		var rowKey = []byte(fmt.Sprintf("row%d", i))
+		if !units.Has(ctrl.GetUnitId(0, 1, rowKey)) {
+			continue
+		}
+		_, _, _, err = ca.Get(1, rowKey, []byte("col"), 0)
+		if err != table.ErrMoved {
+			t.Fatalf("Read moved unit on A should fail: %v", err)
+		}
+		value, _, _, err := cb.Get(1, rowKey, []byte("col"), 0)
+		if err != nil || string(value) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Get from B failed: %q, %v", value, err)
+		}
+	}
+	err = ca.Set(1, kept, []byte("col"), []byte("v"), 0, 0)
+	if err != nil {
+		t.Fatalf("Write unit kept on A failed: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+)
+
+func (srv *Server) promote(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgPromote
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			err = srv.doPromote(&p)
+			if err != nil {
+				log.Printf("Promote failed: %s\n", err)
+				p.ErrMsg = err.Error()
+			}
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for Promote command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+// doPromote stops syncing from master and switches the binlog to master mode.
+// The binlog seq continues from the seq of the old master, so that other
+// slavers of the old master can be re-attached without full sync.
+func (srv *Server) doPromote(p *ctrl.PkgPromote) error {
+	m := srv.mc.GetMaster()
+	if len(m.MasterAddr) == 0 {
+		return fmt.Errorf("not a slaver")
+	}
+	if m.Migration {
+		return fmt.Errorf("cannot promote migration slaver")
+	}
+	if m.Status != ctrl.SlaverReady &&
+		!(p.Force && m.Status == ctrl.SlaverIncrSync) {
+		return fmt.Errorf("slaver is not ready (status %d)", m.Status)
+	}
+	if _, valid := srv.bin.GetMasterSeq(); !valid {
+		return fmt.Errorf("binlog is out of sync")
+	}
+
+	err := srv.mc.SetMaster("", "")
+	if err != nil {
+		return fmt.Errorf("set config failed(%s)", err)
+	}
+
+	srv.rwMtx.Lock()
+	slv := srv.slv
+	srv.slv = nil
+	srv.rwMtx.Unlock()
+
+	if slv != nil {
+		slv.Close()
+	}
+
+	// Requests of the old master queued before are skipped after closed,
+	// none of them can be applied after switching to master
+	srv.flushSync()
+
+	srv.wrMtx.Lock()
+	srv.bin.AsMaster()
+	p.LastSeq = srv.lastSeq()
+	srv.wrMtx.Unlock()
+
+	log.Printf("Promoted to master from %s, lastSeq %d\n",
+		m.MasterAddr, p.LastSeq)
+	return nil
+}
+
+// flushSync waits until all requests queued in SyncReqChan are processed.
+func (srv *Server) flushSync() {
+	srv.reqChan.SyncReqChan <- &Request{}
+	<-srv.syncFlushed
+}
@@ -0,0 +1,168 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/ctrl"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitSlaverReady waits until the slaver is ready and has the seq.
+func waitSlaverReady(c *table.CtrlContext, lastSeq uint64, t *testing.T) {
+	var deadline = time.Now().Add(time.Second * 10)
+	for {
+		st, err := c.ReplStatus()
+		if err != nil {
+			t.Fatalf("ReplStatus failed: %s", err)
+		}
+		if st.Status == ctrl.SlaverReady && st.LastSeq >= lastSeq {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Slaver not ready at seq %d: %+v", lastSeq, st)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// promoteWriting promotes the slaver while its master is written, and
+// checks nothing of the master is applied after promoted.
+func promoteWriting(cm *table.Context, cs *table.CtrlContext, t *testing.T) uint64 {
+	var stop = make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			cm.Set(1, []byte(fmt.Sprintf("row%d", i)), []byte("col"),
+				[]byte(fmt.Sprintf("v%d", i)), int64(i), 0)
+		}
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	lastSeq, err := cs.Promote(false)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Promote failed: %s", err)
+	}
+
+	time.Sleep(time.Millisecond * 200)
+	st, err := cs.ReplStatus()
+	if err != nil {
+		t.Fatalf("ReplStatus failed: %s", err)
+	}
+	if st.MasterAddr != "" || st.LastSeq != lastSeq {
+		t.Fatalf("Changed after promoted: master %q, lastSeq %d != %d",
+			st.MasterAddr, st.LastSeq, lastSeq)
+	}
+	return lastSeq
+}
+
+func TestPromote(t *testing.T) {
+	var addrA, addrB = "127.0.0.1:26721", "127.0.0.1:26722"
+	var addrC = "127.0.0.1:26723"
+	var _, cliA = startTestServer("promote_a", addrA, t)
+	var _, cliB = startTestServer("promote_b", addrB, t)
+	var _, cliC = startTestServer("promote_c", addrC, t)
+	defer cliA.Close()
+	defer cliB.Close()
+	defer cliC.Close()
+
+	var ca, cb, cc = cliA.NewContext(0), cliB.NewContext(0), cliC.NewContext(0)
+	var ctrlA, ctrlB = table.CtrlContext(*ca), table.CtrlContext(*cb)
+	var ctrlC = table.CtrlContext(*cc)
+	setTestKeys(ca, 0, 100, t)
+
+	_, err := ctrlA.Promote(false)
+	if err == nil {
+		t.Fatalf("Promote master A should fail")
+	}
+
+	err = ctrlB.SlaveOf(addrA)
+	if err != nil {
+		t.Fatalf("B slaveof A failed: %s", err)
+	}
+	err = ctrlC.SlaveOf(addrA)
+	if err != nil {
+		t.Fatalf("C slaveof A failed: %s", err)
+	}
+	stA, err := ctrlA.ReplStatus()
+	if err != nil {
+		t.Fatalf("ReplStatus failed: %s", err)
+	}
+	waitSlaverReady(&ctrlB, stA.LastSeq, t)
+	waitSlaverReady(&ctrlC, stA.LastSeq, t)
+
+	// A is down, B is promoted and C is re-attached as the sentinel does
+	lastSeq, err := ctrlB.Promote(false)
+	if err != nil {
+		t.Fatalf("Promote B failed: %s", err)
+	}
+	if lastSeq != stA.LastSeq {
+		t.Fatalf("Promoted at seq %d, master seq %d", lastSeq, stA.LastSeq)
+	}
+	err = cb.Set(1, []byte("row0"), []byte("col"), []byte("b"), 0, 0)
+	if err != nil {
+		t.Fatalf("Write promoted B failed: %s", err)
+	}
+	stB, err := ctrlB.ReplStatus()
+	if err != nil {
+		t.Fatalf("ReplStatus failed: %s", err)
+	}
+	if stB.LastSeq != lastSeq+1 {
+		t.Fatalf("Seq of B not continued: %d, promoted at %d", stB.LastSeq, lastSeq)
+	}
+
+	err = ctrlC.SlaveOf(addrB)
+	if err != nil {
+		t.Fatalf("C slaveof B failed: %s", err)
+	}
+	waitSlaverReady(&ctrlC, stB.LastSeq, t)
+	value, _, _, err := cc.Get(1, []byte("row0"), []byte("col"), 0)
+	if err != nil || string(value) != "b" {
+		t.Fatalf("Write on B not synced to C: %q, %v", value, err)
+	}
+	stB, err = ctrlB.ReplStatus()
+	if err != nil {
+		t.Fatalf("ReplStatus failed: %s", err)
+	}
+	if len(stB.Slavers) != 1 || stB.Slavers[0].SyncRecords != 0 {
+		t.Fatalf("C should continue without full sync: %+v", stB.Slavers)
+	}
+
+	// Promote C while B is written
+	lastSeq = promoteWriting(cb, &ctrlC, t)
+	err = cc.Set(1, []byte("row0"), []byte("col"), []byte("c"), 0, 0)
+	if err != nil {
+		t.Fatalf("Write promoted C failed: %s", err)
+	}
+	stC, err := ctrlC.ReplStatus()
+	if err != nil {
+		t.Fatalf("ReplStatus failed: %s", err)
+	}
+	if stC.LastSeq != lastSeq+1 {
+		t.Fatalf("Seq of C not continued: %d, promoted at %d", stC.LastSeq, lastSeq)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/binlog"
+	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/store"
+	"io"
+	"log"
+	"time"
+)
+
+// Point-in-time recovery arguments
+type ReplayArgs struct {
+	BinLogDir string    // Directory of the binlog files to replay
+	BackupDir string    // If set, FromSeq is the LastSeq of the backup
+	FromSeq   uint64    // Replay records whose seq is larger than FromSeq
+	ToSeq     uint64    // Stop after the record of ToSeq, 0 means no limit
+	ToTime    time.Time // Stop before the first record written after ToTime
+}
+
+// All databases are authorized when replaying binlog
+type replayAuth struct{}
+
+func (au replayAuth) IsAuth(dbId uint8) bool {
+	return true
+}
+
+func (au replayAuth) SetAuth(dbId uint8) {
+}
+
+// Replay applies binlog records onto the data directory of conf, which should
+// be a consistent data snapshot. It works offline, the server must NOT be
+// running on the data directory. FromSeq must be the binlog seq of the
+// snapshot, or records already in it are applied twice (INCR is not
+// idempotent). Replay stops at the first record failed to apply.
+// It returns the last replayed seq.
+func Replay(conf *config.Config, args *ReplayArgs) (uint64, error) {
+	if len(args.BinLogDir) == 0 {
+		return 0, errors.New("empty binlog directory")
+	}
+
+	var fromSeq = args.FromSeq
+	if len(args.BackupDir) > 0 {
+		var info BackupInfo
+		var err = readBackupInfo(backupInfoName(args.BackupDir), &info)
+		if err != nil {
+			return 0, err
+		}
+		fromSeq = info.LastSeq
+		log.Printf("Replay after seq %d of backup %s\n", fromSeq, args.BackupDir)
+	}
+
+	r, err := binlog.NewFileReader(args.BinLogDir)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var tbl = store.NewTable(TableDirName(conf), getMaxOpenFiles(), conf)
+	if tbl == nil {
+		return 0, errors.New("failed to open table")
+	}
+	defer tbl.Close()
+
+	// Accept all data just like a replication slaver
+	var wa = store.NewWriteAccess(true, &config.MasterConfig{})
+	var head proto.PkgHead
+	var lastSeq uint64
+	var applied int64
+	var noTime bool
+	for {
+		pkg, seq, tm, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return lastSeq, err
+		}
+
+		if seq <= fromSeq {
+			continue
+		}
+		if args.ToSeq > 0 && seq > args.ToSeq {
+			break
+		}
+		if !args.ToTime.IsZero() {
+			if tm.IsZero() {
+				if !noTime {
+					noTime = true
+					log.Printf("Unknown write time from seq %d\n", seq)
+				}
+			} else if tm.After(args.ToTime) {
+				break
+			}
+		}
+
+		_, err = head.Decode(pkg)
+		if err != nil {
+			return lastSeq, err
+		}
+
+		var req = store.PkgArgs{Cmd: head.Cmd, DbId: head.DbId, Seq: head.Seq, Pkg: pkg}
+		var ok = true
+		switch head.Cmd {
+		case proto.CmdSet:
+			_, ok = tbl.Set(&req, replayAuth{}, wa)
+		case proto.CmdDel:
+			_, ok = tbl.Del(&req, replayAuth{}, wa)
+		case proto.CmdIncr:
+			_, ok = tbl.Incr(&req, replayAuth{}, wa)
+		case proto.CmdMSet:
+			_, ok = tbl.MSet(&req, replayAuth{}, wa)
+		case proto.CmdMDel:
+			_, ok = tbl.MDel(&req, replayAuth{}, wa)
+		case proto.CmdMIncr:
+			_, ok = tbl.MIncr(&req, replayAuth{}, wa)
+		case proto.CmdSync:
+			_, ok = tbl.Sync(&req)
+		case proto.CmdSyncSt:
+			// Empty OP, only seq matters
+		case proto.CmdDrop:
+			ok = applyDrop(tbl, pkg) == nil
+		case proto.CmdCatalog:
+			ok = applyCatalog(tbl, pkg) == nil
+		case proto.CmdCluster:
+			_, err = applyClusterMap(tbl, pkg)
+			ok = err == nil
+		default:
+			log.Printf("Skip unknown binlog cmd 0x%X, seq %d\n", head.Cmd, seq)
+		}
+
+		if !ok {
+			return lastSeq, fmt.Errorf("apply cmd 0x%X of seq %d failed",
+				head.Cmd, seq)
+		}
+		applied++
+		lastSeq = seq
+	}
+
+	log.Printf("Replay finished: lastSeq %d, applied %d\n", lastSeq, applied)
+	return lastSeq, nil
+}
@@ -15,6 +15,7 @@
 package server
 
 import (
+	"fmt"
 	"github.com/stevejiang/gotable/api/go/table/proto"
 	"github.com/stevejiang/gotable/binlog"
 	"github.com/stevejiang/gotable/config"
@@ -37,8 +38,21 @@ type slaver struct {
 	mtx    sync.Mutex // protects following
 	cli    *Client
 	closed bool
+
+	// SYNCACK of the current connection
+	ackSeq       uint64 // The last applied master seq
+	ackBytes     uint64 // Bytes of sync data applied
+	sentAckBytes uint64 // ackBytes sent to master
+	lastAck      time.Time
 }
 
+const (
+	// Send SYNCACK when so many bytes applied
+	syncAckBytes = 256 * 1024
+	// Send SYNCACK at this interval if anything applied
+	syncAckInterval = time.Millisecond * 100
+)
+
 func NewSlaver(reqChan *RequestChan, bin *binlog.BinLog,
 	mc *config.MasterConfig, adminPwd string) *slaver {
 	var slv = new(slaver)
@@ -105,6 +119,9 @@ func (slv *slaver) doConnectToMaster() {
 		cli := NewClient(c, false)
 		slv.mtx.Lock()
 		slv.cli = cli
+		slv.ackSeq = 0
+		slv.ackBytes = 0
+		slv.sentAckBytes = 0
 		slv.mtx.Unlock()
 		if slv.IsClosed() {
 			return
@@ -132,11 +149,66 @@ func (slv *slaver) doConnectToMaster() {
 		}
 
 		for !cli.IsClosed() {
-			time.Sleep(time.Second)
+			time.Sleep(syncAckInterval)
+			slv.sendAck()
 		}
 	}
 }
 
+// Applied is called after the sync data from master has been applied.
+// Incremental sync is acked at once if no more sync data is pending (idle),
+// so that semi-sync writes on master are replied soon.
+func (slv *slaver) Applied(cli *Client, seq uint64, pkgLen int, idle bool) {
+	slv.mtx.Lock()
+	if cli != slv.cli {
+		slv.mtx.Unlock()
+		return
+	}
+	if seq > slv.ackSeq {
+		slv.ackSeq = seq
+	}
+	slv.ackBytes += uint64(pkgLen)
+	var full = slv.ackBytes-slv.sentAckBytes >= syncAckBytes
+	slv.mtx.Unlock()
+
+	if full || (idle && seq > 0) {
+		slv.sendAck()
+	}
+}
+
+// AckStatus returns the applied seq and bytes of the current connection,
+// and the time of the last SYNCACK.
+func (slv *slaver) AckStatus() (ackSeq, ackBytes uint64, lastAck time.Time) {
+	slv.mtx.Lock()
+	ackSeq, ackBytes, lastAck = slv.ackSeq, slv.ackBytes, slv.lastAck
+	slv.mtx.Unlock()
+	return
+}
+
+// sendAck tells master the applied seq and bytes.
+func (slv *slaver) sendAck() {
+	var p proto.PkgOneOp
+	slv.mtx.Lock()
+	var cli = slv.cli
+	if cli == nil || slv.ackBytes == slv.sentAckBytes {
+		slv.mtx.Unlock()
+		return
+	}
+	p.Seq = slv.ackSeq
+	p.SetScore(int64(slv.ackBytes))
+	slv.sentAckBytes = slv.ackBytes
+	slv.lastAck = time.Now()
+	slv.mtx.Unlock()
+
+	p.Cmd = proto.CmdSyncAck
+	p.DbId = proto.AdminDbId
+	var pkg = make([]byte, p.Length())
+	_, err := p.Encode(pkg)
+	if err == nil {
+		cli.AddResp(pkg)
+	}
+}
+
 func (slv *slaver) SendSlaveOfToMaster() error {
 	var cli = slv.cli
 	if cli == nil {
@@ -150,28 +222,40 @@ func (slv *slaver) SendSlaveOfToMaster() error {
 		p.ClientReq = false
 		p.MasterAddr = slv.mi.MasterAddr
 		p.SlaverAddr = slv.mi.SlaverAddr
-		p.UnitId = slv.mi.UnitId
+		p.Units = slv.mi.Units
+		p.SyncAck = true
 
 		pkg, err = ctrl.Encode(proto.CmdMigrate, 0, 0, &p)
 		if err != nil {
 			return err
 		}
 	} else {
-		lastSeq, valid := slv.bin.GetMasterSeq()
-		if !valid {
-			slv.mc.SetStatus(ctrl.SlaverNeedClear)
-			// Any better solution?
-			log.Fatalf("Slaver lastSeq %d is out of sync, please clear old data! "+
-				"(Restart may fix this issue)", lastSeq)
-		}
-
 		var p ctrl.PkgSlaveOf
 		p.ClientReq = false
 		p.MasterAddr = slv.mi.MasterAddr
 		p.SlaverAddr = slv.mi.SlaverAddr
-		p.LastSeq = lastSeq
-		log.Printf("Connect to master %s with lastSeq %d\n",
-			slv.mi.MasterAddr, p.LastSeq)
+		p.SyncAck = true
+
+		// Resume the unfinished full sync?
+		m := slv.mc.GetMaster()
+		if m.FullSyncSeq > 0 {
+			p.FullSyncSeq = m.FullSyncSeq
+			p.FullSyncKey = m.FullSyncKey
+			log.Printf("Connect to master %s to resume full sync %d\n",
+				slv.mi.MasterAddr, p.FullSyncSeq)
+		} else {
+			lastSeq, valid := slv.bin.GetMasterSeq()
+			if !valid {
+				slv.mc.SetStatus(ctrl.SlaverNeedClear)
+				// Any better solution?
+				log.Fatalf("Slaver lastSeq %d is out of sync, please clear old data! "+
+					"(Restart may fix this issue)", lastSeq)
+			}
+
+			p.LastSeq = lastSeq
+			log.Printf("Connect to master %s with lastSeq %d\n",
+				slv.mi.MasterAddr, p.LastSeq)
+		}
 
 		pkg, err = ctrl.Encode(proto.CmdSlaveOf, 0, 0, &p)
 		if err != nil {
@@ -207,6 +291,143 @@ func (slv *slaver) SendAuthToMaster() error {
 	return nil
 }
 
+const (
+	// Keep the full sync snapshot for resuming after slaver disconnected
+	fullSyncKeepTime = time.Minute * 10
+	// Interval to tell slaver the full sync position
+	fullSyncPosInterval = time.Second
+)
+
+// Full sync snapshot bound to a binlog seq
+type syncSnapshot struct {
+	slaveAddr string
+	lastSeq   uint64          // Binlog seq of the snapshot
+	key       []byte          // Resume full sync after this raw key
+	snap      *store.Snapshot // Released after full sync finished
+	reader    *binlog.Reader  // Holds the binlog after lastSeq
+	timer     *time.Timer     // Expire timer when kept by snapshotKeeper
+}
+
+func (ss *syncSnapshot) release() {
+	if ss.snap != nil {
+		ss.snap.Release()
+		ss.snap = nil
+	}
+	if ss.reader != nil {
+		ss.reader.Close()
+		ss.reader = nil
+	}
+}
+
+// snapshotKeeper keeps the snapshots of unfinished full sync, so that
+// slavers can resume full sync after reconnected.
+type snapshotKeeper struct {
+	mtx sync.Mutex
+	sss map[string]*syncSnapshot // slaveAddr => snapshot
+}
+
+func newSnapshotKeeper() *snapshotKeeper {
+	var sk = new(snapshotKeeper)
+	sk.sss = make(map[string]*syncSnapshot)
+	return sk
+}
+
+func (sk *snapshotKeeper) Put(ss *syncSnapshot) {
+	sk.mtx.Lock()
+	if old, ok := sk.sss[ss.slaveAddr]; ok {
+		old.timer.Stop()
+		old.release()
+	}
+	sk.sss[ss.slaveAddr] = ss
+	ss.timer = time.AfterFunc(fullSyncKeepTime, func() {
+		sk.expire(ss)
+	})
+	sk.mtx.Unlock()
+
+	log.Printf("Keep full sync snapshot %d for %s\n", ss.lastSeq, ss.slaveAddr)
+}
+
+// Take returns the kept snapshot of the slaver if the seq matches.
+func (sk *snapshotKeeper) Take(slaveAddr string, lastSeq uint64) *syncSnapshot {
+	sk.mtx.Lock()
+	ss, ok := sk.sss[slaveAddr]
+	if ok {
+		delete(sk.sss, slaveAddr)
+		ss.timer.Stop()
+		if ss.lastSeq != lastSeq {
+			ss.release()
+			ss = nil
+		}
+	}
+	sk.mtx.Unlock()
+
+	return ss
+}
+
+// Clear releases all kept snapshots.
+func (sk *snapshotKeeper) Clear() {
+	sk.mtx.Lock()
+	for slaveAddr, ss := range sk.sss {
+		delete(sk.sss, slaveAddr)
+		ss.timer.Stop()
+		ss.release()
+	}
+	sk.mtx.Unlock()
+}
+
+func (sk *snapshotKeeper) expire(ss *syncSnapshot) {
+	sk.mtx.Lock()
+	if sk.sss[ss.slaveAddr] == ss {
+		delete(sk.sss, ss.slaveAddr)
+		ss.release()
+		log.Printf("Full sync snapshot %d for %s expired\n", ss.lastSeq, ss.slaveAddr)
+	}
+	sk.mtx.Unlock()
+}
+
+// lastSeq returns the last binlog seq. It's the master seq on slaver.
+func (srv *Server) lastSeq() uint64 {
+	var seq = srv.bin.GetLogSeq()
+	if seq == 0 {
+		// Slaver without new binlog since reconnected to master
+		seq, _ = srv.bin.GetMasterSeq()
+	}
+	return seq
+}
+
+// canServeSlaver checks whether the server can be master of a new slaver.
+// A normal slaver can serve its own slavers (cascading replication) after
+// full sync finished, with the binlog numbered by its master seq.
+func (srv *Server) canServeSlaver(slaverAddr string) error {
+	m := srv.mc.GetMaster()
+	if len(m.MasterAddr) == 0 {
+		return nil
+	}
+	if m.Migration {
+		return fmt.Errorf("migration slaver cannot serve slavers")
+	}
+	if m.MasterAddr == slaverAddr {
+		return fmt.Errorf("slaver %s is my master", slaverAddr)
+	}
+	if m.Status != ctrl.SlaverIncrSync && m.Status != ctrl.SlaverReady {
+		return fmt.Errorf("full sync from master %s not finished", m.MasterAddr)
+	}
+	return nil
+}
+
+// newSyncSnapshot creates a table snapshot bound to the last binlog seq.
+// Writers are stopped only for the moment of creating the snapshot.
+func (srv *Server) newSyncSnapshot(slaveAddr string) *syncSnapshot {
+	var ss = new(syncSnapshot)
+	ss.slaveAddr = slaveAddr
+	srv.wrMtx.Lock()
+	ss.lastSeq = srv.lastSeq()
+	ss.snap = srv.tbl.NewSnapshot()
+	srv.wrMtx.Unlock()
+
+	return ss
+}
+
 type master struct {
 	syncChan  chan struct{}
 	cli       *Client
@@ -214,33 +435,219 @@ type master struct {
 	reader    *binlog.Reader
 	slaveAddr string
 	lastSeq   uint64
-	migration bool   // true: Migration; false: Normal master/slaver
-	unitId    uint16 // Only meaningful for migration
+	migration bool         // true: Migration; false: Normal master/slaver
+	units     ctrl.UnitSet // Only meaningful for migration
+
+	ss     *syncSnapshot   // Snapshot for full sync, nil if cannot full sync
+	keeper *snapshotKeeper // Keep ss if disconnected, nil if cannot resume
+
+	// Flow control, disabled if window is 0
+	window  uint64        // Max bytes sent but not acked
+	rate    uint64        // Max full sync bytes per second, 0 means no limit
+	ackChan chan struct{} // Notified when receive SYNCACK
+
+	ackNotify chan struct{} // Notified when receive SYNCACK, maybe nil
+
+	smMtx   sync.Mutex // protects following
+	samples []seqTime  // Send time of records not acked, for lag seconds
 
 	// atomic
-	closed uint32
+	closed      uint32
+	incrSync    uint32 // 1 after full sync finished
+	migEnd      uint32 // 1: end migration requested; 2: migration end sent
+	syncRecords uint64 // Records sent in full sync
+	syncBytes   uint64 // Bytes sent in full sync
+	sentBytes   uint64 // Bytes sent to slaver
+	sentSeq     uint64 // The last seq sent to slaver
+	readSeq     uint64 // The last seq read from binlog, maybe not sent
+	ackSeq      uint64 // The last seq applied by slaver
+	ackBytes    uint64 // Bytes applied by slaver
+	ackTime     int64  // UnixNano of the last SYNCACK
 }
 
-func NewMaster(slaveAddr string, lastSeq uint64, migration bool, unitId uint16,
+type seqTime struct {
+	seq uint64
+	t   time.Time
+}
+
+const (
+	// Sample the send time of records at this interval
+	lagSampleInterval = time.Millisecond * 10
+	// Max number of samples, older samples are kept
+	maxLagSampleNum = 1000
+)
+
+func NewMaster(slaveAddr string, lastSeq uint64, migration bool, units ctrl.UnitSet,
+	ss *syncSnapshot, keeper *snapshotKeeper,
 	cli *Client, bin *binlog.BinLog) *master {
 	var ms = new(master)
 	ms.syncChan = make(chan struct{}, 20)
+	ms.ackChan = make(chan struct{}, 1)
 	ms.cli = cli
 	ms.bin = bin
 	ms.reader = nil
 	ms.slaveAddr = slaveAddr
 	ms.lastSeq = lastSeq
+	ms.ss = ss
+	ms.keeper = keeper
 	ms.migration = migration
 	if migration {
-		ms.unitId = unitId
-	} else {
-		ms.unitId = ctrl.TotalUnitNum
+		ms.units = units
 	}
 	ms.bin.RegisterMonitor(ms)
 
 	return ms
 }
 
+// SetAckNotify sets the channel notified when receive SYNCACK.
+func (ms *master) SetAckNotify(notify chan struct{}) {
+	ms.ackNotify = notify
+}
+
+// AckSeq returns the last seq applied by slaver.
+func (ms *master) AckSeq() uint64 {
+	return atomic.LoadUint64(&ms.ackSeq)
+}
+
+// SetFlowControl limits unacked sync data to window MB, and full sync
+// rate to rate MB/s. Only for slavers sending SYNCACK.
+func (ms *master) SetFlowControl(window, rate int) {
+	if window > 0 {
+		ms.window = uint64(window) * 1024 * 1024
+	}
+	if rate > 0 {
+		ms.rate = uint64(rate) * 1024 * 1024
+	}
+}
+
+// ack handles SYNCACK from slaver.
+func (ms *master) ack(pkg []byte) {
+	var p proto.PkgOneOp
+	_, err := p.Decode(pkg)
+	if err != nil {
+		return
+	}
+
+	if p.Seq > atomic.LoadUint64(&ms.ackSeq) {
+		atomic.StoreUint64(&ms.ackSeq, p.Seq)
+	}
+	atomic.StoreUint64(&ms.ackBytes, uint64(p.Score))
+	atomic.StoreInt64(&ms.ackTime, time.Now().UnixNano())
+
+	ms.smMtx.Lock()
+	var i = 0
+	for i < len(ms.samples) && ms.samples[i].seq <= p.Seq {
+		i++
+	}
+	ms.samples = ms.samples[i:]
+	ms.smMtx.Unlock()
+
+	select {
+	case ms.ackChan <- struct{}{}:
+	default:
+	}
+	if ms.ackNotify != nil {
+		select {
+		case ms.ackNotify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// send sends pkg to slaver, waits if too many bytes are not acked.
+func (ms *master) send(pkg []byte) {
+	for ms.window > 0 && atomic.LoadUint64(&ms.sentBytes)-
+		atomic.LoadUint64(&ms.ackBytes) >= ms.window {
+		if ms.IsClosed() || ms.cli.IsClosed() {
+			return
+		}
+		select {
+		case <-ms.ackChan:
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	atomic.AddUint64(&ms.sentBytes, uint64(len(pkg)))
+	ms.cli.AddResp(pkg)
+}
+
+// addSample records the send time of seq for lag seconds.
+func (ms *master) addSample(seq uint64) {
+	var now = time.Now()
+	ms.smMtx.Lock()
+	var n = len(ms.samples)
+	if n == 0 || (n < maxLagSampleNum &&
+		now.Sub(ms.samples[n-1].t) >= lagSampleInterval) {
+		ms.samples = append(ms.samples, seqTime{seq, now})
+	}
+	ms.smMtx.Unlock()
+}
+
+// Status reports replication status of the slaver, lastSeq is the last
+// binlog seq of master.
+func (ms *master) Status(lastSeq uint64) ctrl.SlaverSync {
+	var st ctrl.SlaverSync
+	st.SlaverAddr = ms.slaveAddr
+	st.Migration = ms.migration
+	if ms.migration {
+		st.UnitId = ms.units[0]
+		st.Units = ms.units
+	}
+	st.FullSync = atomic.LoadUint32(&ms.incrSync) == 0
+	st.SyncRecords = atomic.LoadUint64(&ms.syncRecords)
+	st.SyncBytes = atomic.LoadUint64(&ms.syncBytes)
+	st.AckSeq = atomic.LoadUint64(&ms.ackSeq)
+	st.BytesBehind = atomic.LoadUint64(&ms.sentBytes) -
+		atomic.LoadUint64(&ms.ackBytes)
+	if ackTime := atomic.LoadInt64(&ms.ackTime); ackTime > 0 {
+		st.LastAck = time.Unix(0, ackTime)
+	}
+
+	var applied = ms.AppliedSeq()
+	if !st.FullSync && lastSeq > applied {
+		st.LagRecords = lastSeq - applied
+		ms.smMtx.Lock()
+		if len(ms.samples) > 0 {
+			st.LagSeconds = time.Since(ms.samples[0].t).Seconds()
+		}
+		ms.smMtx.Unlock()
+	}
+
+	return st
+}
+
+// AppliedSeq returns the last seq applied by slaver in incremental sync.
+// Records skipped by migration are applied if all sent are applied.
+func (ms *master) AppliedSeq() uint64 {
+	var applied = atomic.LoadUint64(&ms.ackSeq)
+	if applied >= atomic.LoadUint64(&ms.sentSeq) {
+		if readSeq := atomic.LoadUint64(&ms.readSeq); readSeq > applied {
+			applied = readSeq
+		}
+	}
+	return applied
+}
+
+// EndMigration tells the migration slaver to switch to normal status after
+// all the sent records.
+func (ms *master) EndMigration() {
+	atomic.CompareAndSwapUint32(&ms.migEnd, 0, 1)
+	ms.NewLogComming()
+}
+
+// limitRate sleeps if full sync is faster than the rate limit.
+func (ms *master) limitRate(start time.Time, bytes uint64) {
+	if ms.rate == 0 {
+		return
+	}
+
+	var d = time.Duration(float64(bytes)/float64(ms.rate)*float64(time.Second)) -
+		time.Since(start)
+	if d > time.Millisecond*10 {
+		time.Sleep(d)
+	}
+}
+
 func (ms *master) doClose() {
 	atomic.AddUint32(&ms.closed, 1)
 
@@ -259,9 +666,15 @@ func (ms *master) doClose() {
 		reader.Close()
 	}
 
+	ss := ms.ss
+	if ss != nil {
+		ss.release()
+	}
+
 	ms.cli = nil
 	ms.bin = nil
 	ms.reader = nil
+	ms.ss = nil
 
 	log.Printf("Master sync to slaver %s is closed\n", ms.slaveAddr)
 }
@@ -298,107 +711,182 @@ func (ms *master) syncStatus(key string, lastSeq uint64) {
 	p.RowKey = []byte(key)
 	var pkg = make([]byte, p.Length())
 	p.Encode(pkg)
-	ms.cli.AddResp(pkg)
+	ms.send(pkg)
 }
 
-func (ms *master) fullSync(tbl *store.Table) uint64 {
-	var lastSeq uint64
+// syncProgress tells slaver the full sync snapshot seq and position.
+func (ms *master) syncProgress(key string, snapSeq uint64, rawKey []byte) {
+	var p proto.PkgOneOp
+	p.Cmd = proto.CmdSyncSt
+	p.DbId = proto.AdminDbId
+	p.RowKey = []byte(key)
+	p.SetScore(int64(snapSeq))
+	if len(rawKey) > 0 {
+		p.SetValue(rawKey)
+	}
+	var pkg = make([]byte, p.Length())
+	p.Encode(pkg)
+	ms.send(pkg)
+}
+
+func (ms *master) fullSync(tbl *store.Table) (uint64, error) {
 	if ms.lastSeq > 0 {
-		lastSeq = ms.lastSeq
 		if ms.migration {
 			log.Printf("Migration lastSeq is not 0, close now!\n")
 			ms.Close()
 		} else {
 			log.Printf("Already full synced to %s\n", ms.slaveAddr)
 		}
-		return lastSeq
+		return ms.lastSeq, nil
+	}
+
+	var ss = ms.ss
+	if ss == nil {
+		log.Printf("No full sync snapshot for %s\n", ms.slaveAddr)
+		return 0, binlog.ErrLogMissing
 	}
 
-	// Stop write globally
-	rwMtx := tbl.GetRWMutex()
-	rwMtx.Lock()
-	var valid bool
-	for lastSeq, valid = ms.bin.GetLastLogSeq(); !valid; {
-		log.Println("Stop write globally for 1ms")
-		time.Sleep(time.Millisecond)
-		lastSeq, valid = ms.bin.GetLastLogSeq()
+	// Hold the binlog after the snapshot seq before full sync
+	if ss.reader == nil {
+		for !ms.bin.IsWritten(ss.lastSeq) {
+			if ms.cli.IsClosed() {
+				return ss.lastSeq, nil
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		ss.reader = binlog.NewReader(ms.bin)
+		var err = ss.reader.Init(ss.lastSeq)
+		if err != nil {
+			return 0, err
+		}
 	}
-	var it = tbl.NewIterator(false)
-	rwMtx.Unlock()
 
-	defer it.Destroy()
+	var it = tbl.NewSnapshotIterator(ss.snap)
+
+	if len(ss.key) > 0 {
+		log.Printf("Resume full sync %d to %s\n", ss.lastSeq, ms.slaveAddr)
+		store.SeekAfter(it, ss.key)
+	} else {
+		if !ms.migration {
+			ms.syncProgress(store.KeyFullSyncStart, ss.lastSeq, nil)
+		}
+		it.SeekToFirst()
+	}
 
 	// Full sync
 	var one proto.PkgOneOp
 	one.Cmd = proto.CmdSync
-	for it.SeekToFirst(); it.Valid(); it.Next() {
+	var start = time.Now()
+	var posTime = start
+	var fullBytes uint64
+	for num := 0; it.Valid(); it.Next() {
 		unitId, ok := store.SeekAndCopySyncPkg(it, &one)
-		if !ok {
-			break
-		}
-		if ms.migration && ms.unitId != unitId {
-			if ms.unitId < unitId {
+		for ok && ms.migration && !ms.units.Has(unitId) {
+			// Skip to the next unit under migration
+			next, found := ms.units.Next(unitId)
+			if !found {
+				ok = false
+				break
+			}
+			store.SeekToUnit(it, next, 0, 0)
+			if !it.Valid() {
+				ok = false
 				break
-			} else if ms.unitId > unitId {
-				store.SeekToUnit(it, ms.unitId, 0, 0)
-				if !it.Valid() {
-					break
-				}
-				unitId, ok = store.SeekAndCopySyncPkg(it, &one)
-				if !ok || ms.unitId != unitId {
-					break
-				}
 			}
+			unitId, ok = store.SeekAndCopySyncPkg(it, &one)
+		}
+		if !ok {
+			break
 		}
 
-		if ms.cli.IsClosed() {
-			return lastSeq
+		if ms.IsClosed() || ms.cli.IsClosed() {
+			it.Destroy()
+			if ms.keeper != nil && !ms.IsClosed() {
+				ms.ss = nil
+				ms.keeper.Put(ss)
+			}
+			return ss.lastSeq, nil
 		}
 
 		one.Seq = 0
 		var pkg = make([]byte, one.Length())
 		one.Encode(pkg)
-		ms.cli.AddResp(pkg)
+		ms.send(pkg)
+		fullBytes += uint64(len(pkg))
+		atomic.AddUint64(&ms.syncRecords, 1)
+		atomic.AddUint64(&ms.syncBytes, uint64(len(pkg)))
+		ms.limitRate(start, fullBytes)
+
+		num++
+		if !ms.migration && num%1000 == 0 &&
+			time.Since(posTime) > fullSyncPosInterval {
+			posTime = time.Now()
+			ms.syncProgress(store.KeyFullSyncPos, ss.lastSeq, it.Key())
+		}
 	}
 
+	it.Destroy()
+
+	// Incremental sync starts from the snapshot seq
+	ms.ss = nil
+	ms.reader = ss.reader
+	ss.reader = nil
+	ss.release()
+
 	// Tell slaver full sync finished
 	if ms.migration {
 		ms.syncStatus(store.KeyFullSyncEnd, 0)
-		log.Printf("Full migration to %s unitId %d finished\n",
-			ms.slaveAddr, ms.unitId)
+		log.Printf("Full migration to %s units %s finished\n",
+			ms.slaveAddr, ms.units)
 	} else {
-		ms.syncStatus(store.KeyFullSyncEnd, lastSeq)
+		ms.syncStatus(store.KeyFullSyncEnd, ss.lastSeq)
 		log.Printf("Full sync to %s finished\n", ms.slaveAddr)
 	}
 
-	return lastSeq
+	return ss.lastSeq, nil
 }
 
 func (ms *master) GoAsync(tbl *store.Table) {
-	var lastSeq = ms.fullSync(tbl)
+	lastSeq, err := ms.fullSync(tbl)
+	if err != nil {
+		if err == binlog.ErrLogMissing {
+			ms.syncStatus(store.KeySyncLogMissing, 0)
+			ms.doDelayClose()
+		} else {
+			ms.doClose()
+		}
+		return
+	}
 	if ms.IsClosed() || ms.cli.IsClosed() {
 		log.Println("Master-slaver connection is closed, stop sync!")
+		ms.doClose()
 		return
 	}
 
+	// Records before lastSeq have been synced
+	atomic.StoreUint64(&ms.readSeq, lastSeq)
+	atomic.StoreUint32(&ms.incrSync, 1)
 	if ms.migration {
-		log.Printf("Start incremental migration to %s unitId %d, lastSeq=%d\n",
-			ms.slaveAddr, ms.unitId, lastSeq)
+		log.Printf("Start incremental migration to %s units %s, lastSeq=%d\n",
+			ms.slaveAddr, ms.units, lastSeq)
 	} else {
 		log.Printf("Start incremental sync to %s, lastSeq=%d",
 			ms.slaveAddr, lastSeq)
 	}
 
-	ms.reader = binlog.NewReader(ms.bin)
-	var err = ms.reader.Init(lastSeq)
-	if err != nil {
-		if err == binlog.ErrLogMissing {
-			ms.syncStatus(store.KeySyncLogMissing, 0)
-			ms.doDelayClose()
-		} else {
-			ms.doClose()
+	if ms.reader == nil {
+		ms.reader = binlog.NewReader(ms.bin)
+		err = ms.reader.Init(lastSeq)
+		if err != nil {
+			if err == binlog.ErrLogMissing {
+				ms.syncStatus(store.KeySyncLogMissing, 0)
+				ms.doDelayClose()
+			} else {
+				ms.doClose()
+			}
+			return
 		}
-		return
 	}
 
 	ms.NewLogComming()
@@ -428,11 +916,20 @@ func (ms *master) GoAsync(tbl *store.Table) {
 				if err != nil {
 					break
 				}
+				atomic.StoreUint64(&ms.readSeq, head.Seq)
 				if pkg == nil {
 					continue
 				}
 
-				ms.cli.AddResp(pkg)
+				ms.send(pkg)
+				atomic.StoreUint64(&ms.sentSeq, head.Seq)
+				ms.addSample(head.Seq)
+			}
+
+			if atomic.CompareAndSwapUint32(&ms.migEnd, 1, 2) {
+				ms.syncStatus(store.KeyMigrationEnd, 0)
+				log.Printf("End migration to %s units %s\n",
+					ms.slaveAddr, ms.units)
 			}
 
 		case <-tick:
@@ -473,7 +970,7 @@ func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error)
 		if err != nil {
 			return nil, err
 		}
-		if ms.unitId == ctrl.GetUnitId(p.DbId, p.TableId, p.RowKey) {
+		if ms.units.Has(ctrl.GetUnitId(p.DbId, p.TableId, p.RowKey)) {
 			return pkg, nil
 		} else {
 			return nil, nil
@@ -490,7 +987,7 @@ func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error)
 		}
 		var kvs []proto.KeyValue
 		for i := 0; i < len(p.Kvs); i++ {
-			if ms.unitId == ctrl.GetUnitId(p.DbId, p.Kvs[i].TableId, p.Kvs[i].RowKey) {
+			if ms.units.Has(ctrl.GetUnitId(p.DbId, p.Kvs[i].TableId, p.Kvs[i].RowKey)) {
 				kvs = append(kvs, p.Kvs[i])
 			}
 		}
@@ -505,6 +1002,22 @@ func (ms *master) convertMigPkg(pkg []byte, head *proto.PkgHead) ([]byte, error)
 			}
 			return pkg, nil
 		}
+	case proto.CmdDrop:
+		// Only drop data of the units under migration on the new server
+		var p ctrl.PkgDrop
+		err = ctrl.Decode(pkg, nil, &p)
+		if err != nil {
+			return nil, err
+		}
+		var units = ms.units
+		if len(p.Units) > 0 {
+			units = units.Remove(units.Remove(p.Units))
+		}
+		if len(units) == 0 {
+			return nil, nil
+		}
+		p.Units = units
+		return ctrl.Encode(head.Cmd, head.DbId, head.Seq, &p)
 	}
 
 	return nil, nil
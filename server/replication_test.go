@@ -0,0 +1,118 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestMaster creates a master sending to a pipe without binlog.
+func newTestMaster() *master {
+	var c1, _ = net.Pipe()
+	var ms = new(master)
+	ms.cli = NewClient(c1, false)
+	ms.ackChan = make(chan struct{}, 1)
+	return ms
+}
+
+func testSyncAck(seq uint64, ackBytes int64, t *testing.T) []byte {
+	var p proto.PkgOneOp
+	p.Cmd = proto.CmdSyncAck
+	p.Seq = seq
+	p.SetScore(ackBytes)
+	var pkg = make([]byte, p.Length())
+	_, err := p.Encode(pkg)
+	if err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	return pkg
+}
+
+func TestMasterFlowControl(t *testing.T) {
+	var ms = newTestMaster()
+	defer ms.cli.Close()
+	ms.window = 100
+
+	ms.send(make([]byte, 50))
+	ms.send(make([]byte, 50))
+
+	// The window is full until acked
+	var done = make(chan struct{})
+	go func() {
+		ms.send(make([]byte, 50))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Send should wait for SYNCACK when the window is full")
+	case <-time.After(time.Millisecond * 200):
+	}
+	if st := ms.Status(0); st.BytesBehind != 100 {
+		t.Fatalf("Invalid bytes behind %d", st.BytesBehind)
+	}
+
+	ms.ack(testSyncAck(1, 50, t))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Send not woken up by SYNCACK")
+	}
+	if n := len(ms.cli.respChan); n != 3 {
+		t.Fatalf("Invalid number of sent packages %d", n)
+	}
+	if st := ms.Status(0); st.BytesBehind != 100 {
+		t.Fatalf("Invalid bytes behind %d", st.BytesBehind)
+	}
+}
+
+func TestMasterLagStatus(t *testing.T) {
+	var ms = newTestMaster()
+	defer ms.cli.Close()
+	ms.incrSync = 1
+
+	for seq := uint64(1); seq <= 10; seq++ {
+		ms.send(make([]byte, 10))
+		ms.sentSeq = seq
+		ms.readSeq = seq
+		ms.addSample(seq)
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	var st = ms.Status(10)
+	if st.FullSync || st.LagRecords != 10 || st.LagSeconds < 0.02 ||
+		st.BytesBehind != 100 || !st.LastAck.IsZero() {
+		t.Fatalf("Invalid status before acked: %+v", st)
+	}
+
+	ms.ack(testSyncAck(4, 40, t))
+	st = ms.Status(10)
+	if st.AckSeq != 4 || st.LagRecords != 6 || st.BytesBehind != 60 ||
+		st.LastAck.IsZero() {
+		t.Fatalf("Invalid status after acked: %+v", st)
+	}
+
+	ms.ack(testSyncAck(10, 100, t))
+	st = ms.Status(10)
+	if st.LagRecords != 0 || st.LagSeconds != 0 || st.BytesBehind != 0 {
+		t.Fatalf("Invalid status after all acked: %+v", st)
+	}
+	if len(ms.samples) != 0 {
+		t.Fatalf("Lag samples not cleared: %d", len(ms.samples))
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/ctrl"
+	"log"
+	"sync"
+)
+
+// The masters syncing to connected slavers
+type masterSet struct {
+	mtx sync.Mutex
+	mss map[*master]struct{}
+}
+
+func newMasterSet() *masterSet {
+	var s = new(masterSet)
+	s.mss = make(map[*master]struct{})
+	return s
+}
+
+func (s *masterSet) Add(ms *master) {
+	s.mtx.Lock()
+	s.prune()
+	s.mss[ms] = struct{}{}
+	s.mtx.Unlock()
+}
+
+// Masters returns the masters not closed.
+func (s *masterSet) Masters() []*master {
+	s.mtx.Lock()
+	s.prune()
+	var mss = make([]*master, 0, len(s.mss))
+	for ms := range s.mss {
+		mss = append(mss, ms)
+	}
+	s.mtx.Unlock()
+	return mss
+}
+
+// CloseNormal closes the normal masters, keeps the migration ones.
+func (s *masterSet) CloseNormal() {
+	for _, ms := range s.Masters() {
+		if !ms.migration {
+			ms.Close()
+		}
+	}
+}
+
+func (s *masterSet) prune() {
+	for ms := range s.mss {
+		if ms.IsClosed() {
+			delete(s.mss, ms)
+		}
+	}
+}
+
+func (srv *Server) replStatus(req *Request) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	switch cliType {
+	case ClientTypeNormal:
+		var p ctrl.PkgReplStatus
+		var err = ctrl.Decode(req.Pkg, nil, &p)
+		p.ErrMsg = ""
+		if err != nil {
+			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
+		} else if !req.Cli.IsAuth(proto.AdminDbId) {
+			p.ErrMsg = "no priviledge"
+		} else {
+			srv.getReplStatus(&p)
+		}
+
+		pkg, err := ctrl.Encode(req.Cmd, req.DbId, req.Seq, &p)
+		if err == nil {
+			srv.sendResp(false, req, pkg)
+		}
+	case ClientTypeSlaver:
+		fallthrough
+	case ClientTypeMaster:
+		log.Printf("Invalid client type %d for ReplStatus command, close now!\n",
+			cliType)
+		req.Cli.Close()
+	}
+}
+
+func (srv *Server) getReplStatus(p *ctrl.PkgReplStatus) {
+	p.LastSeq = srv.lastSeq()
+
+	m := srv.mc.GetMaster()
+	if len(m.MasterAddr) > 0 {
+		p.MasterAddr = m.MasterAddr
+		p.Migration = m.Migration
+		p.Status = m.Status
+
+		srv.rwMtx.RLock()
+		slv := srv.slv
+		srv.rwMtx.RUnlock()
+		if slv != nil {
+			p.AppliedSeq, p.AppliedBytes, p.LastAck = slv.AckStatus()
+		}
+	}
+
+	for _, ms := range srv.masters.Masters() {
+		p.Slavers = append(p.Slavers, ms.Status(p.LastSeq))
+	}
+}
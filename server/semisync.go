@@ -0,0 +1,166 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"log"
+	"sync"
+	"time"
+)
+
+// Semi-sync write waiting for slavers to apply
+type syncReq struct {
+	cli      *Client
+	pkg      []byte // Response pkg
+	cmd      uint8
+	dbId     uint8
+	seq      uint64 // Request seq
+	logSeq   uint64 // Binlog seq of the write
+	deadline time.Time
+}
+
+// syncWaiter delays the responses of semi-sync writes until they are
+// applied by at least minNum normal slavers, or timeout.
+type syncWaiter struct {
+	masters *masterSet
+	minNum  int
+	timeout time.Duration
+	notify  chan struct{}
+
+	mtx  sync.Mutex // protects following
+	reqs []*syncReq
+}
+
+func newSyncWaiter(masters *masterSet, minNum int, timeout time.Duration) *syncWaiter {
+	var sw = new(syncWaiter)
+	sw.masters = masters
+	sw.minNum = minNum
+	sw.timeout = timeout
+	sw.notify = make(chan struct{}, 1)
+	return sw
+}
+
+// Enabled returns true if the write request asks for semi-sync.
+func (sw *syncWaiter) Enabled(req *Request) bool {
+	return sw.minNum > 0 && len(req.Pkg) > proto.HeadSize &&
+		req.Pkg[proto.HeadSize]&proto.FlagSemiSync != 0
+}
+
+// Wait queues the response until the write with logSeq is applied.
+func (sw *syncWaiter) Wait(req *Request, pkg []byte, logSeq uint64) {
+	var sr = &syncReq{req.Cli, pkg, req.Cmd, req.DbId, req.Seq, logSeq,
+		time.Now().Add(sw.timeout)}
+	sw.mtx.Lock()
+	sw.reqs = append(sw.reqs, sr)
+	sw.mtx.Unlock()
+
+	sw.Notify()
+}
+
+// Notify wakes up the waiter when slavers acked.
+func (sw *syncWaiter) Notify() {
+	select {
+	case sw.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (sw *syncWaiter) GoCheck() {
+	var tick = time.Tick(time.Millisecond * 10)
+	for {
+		select {
+		case <-sw.notify:
+		case <-tick:
+		}
+
+		sw.check()
+	}
+}
+
+func (sw *syncWaiter) check() {
+	sw.mtx.Lock()
+	var reqs = sw.reqs
+	sw.reqs = nil
+	sw.mtx.Unlock()
+	if len(reqs) == 0 {
+		return
+	}
+
+	var ackSeqs []uint64
+	for _, ms := range sw.masters.Masters() {
+		if !ms.migration {
+			ackSeqs = append(ackSeqs, ms.AckSeq())
+		}
+	}
+
+	var now = time.Now()
+	var left []*syncReq
+	for _, sr := range reqs {
+		var num = 0
+		for _, ackSeq := range ackSeqs {
+			if ackSeq >= sr.logSeq {
+				num++
+			}
+		}
+
+		if num >= sw.minNum {
+			sr.cli.AddResp(sr.pkg)
+		} else if now.After(sr.deadline) || sr.cli.IsClosed() {
+			sr.cli.AddResp(sr.errPkg(table.EcNoQuorum))
+		} else {
+			left = append(left, sr)
+		}
+	}
+
+	if len(left) > 0 {
+		sw.mtx.Lock()
+		sw.reqs = append(left, sw.reqs...)
+		sw.mtx.Unlock()
+	}
+}
+
+func (sr *syncReq) errPkg(errCode int8) []byte {
+	var pkg []byte
+	var err error
+	switch sr.cmd {
+	case proto.CmdMSet, proto.CmdMDel, proto.CmdMIncr:
+		var out proto.PkgMultiOp
+		out.Cmd = sr.cmd
+		out.DbId = sr.dbId
+		out.Seq = sr.seq
+		out.ErrCode = errCode
+		out.PkgFlag = proto.FlagLogSeq
+		out.LogSeq = sr.logSeq
+		pkg = make([]byte, out.Length())
+		_, err = out.Encode(pkg)
+	default:
+		var out proto.PkgOneOp
+		out.Cmd = sr.cmd
+		out.DbId = sr.dbId
+		out.Seq = sr.seq
+		out.ErrCode = errCode
+		out.CtrlFlag |= proto.CtrlErrCode
+		out.PkgFlag = proto.FlagLogSeq
+		out.LogSeq = sr.logSeq
+		pkg = make([]byte, out.Length())
+		_, err = out.Encode(pkg)
+	}
+	if err != nil {
+		log.Fatalf("Encode failed: %s\n", err)
+	}
+	return pkg
+}
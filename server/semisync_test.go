@@ -0,0 +1,73 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"testing"
+	"time"
+)
+
+func TestSemiSync(t *testing.T) {
+	var addrA, addrB = "127.0.0.1:26731", "127.0.0.1:26732"
+	var conf = newTestConfig("semisync_a", addrA, t)
+	conf.Repl.MinSyncSlavers = 1
+	conf.Repl.SyncTimeout = 1000
+	var _, cliA = runTestServer(conf, t)
+	var _, cliB = startTestServer("semisync_b", addrB, t)
+	defer cliA.Close()
+	defer cliB.Close()
+
+	var ca, cb = cliA.NewContext(0), cliB.NewContext(0)
+	var sa = ca.SemiSync()
+
+	// No slaver applies the write in time, but it is written on master
+	var start = time.Now()
+	err := sa.Set(1, []byte("semi"), []byte("col"), []byte("v1"), 0, 0)
+	if err != table.ErrNoQuorum {
+		t.Fatalf("Semi-sync write without slaver should fail: %v", err)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatalf("Replied before timeout: %s", time.Since(start))
+	}
+	value, _, _, err := ca.Get(1, []byte("semi"), []byte("col"), 0)
+	if err != nil || string(value) != "v1" {
+		t.Fatalf("Get from master failed: %q, %v", value, err)
+	}
+
+	// Normal writes are not delayed
+	start = time.Now()
+	setTestKeys(ca, 0, 10, t)
+	if time.Since(start) > time.Millisecond*500 {
+		t.Fatalf("Normal writes delayed: %s", time.Since(start))
+	}
+
+	var ctrlB = table.CtrlContext(*cb)
+	err = ctrlB.SlaveOf(addrA)
+	if err != nil {
+		t.Fatalf("B slaveof A failed: %s", err)
+	}
+	waitTestKeys(cb, 0, 10, t)
+
+	// Replied after applied by B
+	err = sa.Set(1, []byte("semi"), []byte("col"), []byte("v2"), 0, 0)
+	if err != nil {
+		t.Fatalf("Semi-sync write failed: %s", err)
+	}
+	value, _, _, err = cb.Get(1, []byte("semi"), []byte("col"), 0)
+	if err != nil || string(value) != "v2" {
+		t.Fatalf("Semi-sync write not applied by slaver: %q, %v", value, err)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"runtime"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type Server struct {
@@ -36,6 +37,26 @@ type Server struct {
 	conf    *config.Config
 	mc      *config.MasterConfig
 	reqChan *RequestChan
+	keeper  *snapshotKeeper
+	masters *masterSet
+	waiter  *syncWaiter
+	readers *readWaiter
+
+	// Write handlers hold the read lock until the binlog seq is assigned,
+	// so that a snapshot can be bound to a binlog seq with the write lock.
+	wrMtx sync.RWMutex
+
+	compacting uint32 // 1 if a manual compaction is running
+
+	// Cutovers not switched in time, only used in the ctrl goroutine
+	cutovers []*master
+
+	groupChan chan groupReq // Writes waiting for group commit
+
+	syncFlushed chan struct{} // Signaled when the sync flush mark is processed
+
+	link        net.Listener
+	authEnabled bool
 
 	rwMtx sync.RWMutex // protects following
 	slv   *slaver
@@ -59,12 +80,20 @@ func NewServer(conf *config.Config) *Server {
 	srv := new(Server)
 	srv.conf = conf
 	srv.mc = mc
-	srv.tbl = store.NewTable(tableDir, getMaxOpenFiles(),
-		conf.Db.WriteBufSize, conf.Db.CacheSize, conf.Db.Compression)
+	srv.tbl = store.NewTable(tableDir, getMaxOpenFiles(), conf)
 	if srv.tbl == nil {
 		return nil
 	}
 
+	if len(conf.Cluster.Address) > 0 {
+		m, err := srv.getClusterMap()
+		if err == nil {
+			srv.loadOwnedUnits(&m)
+		} else {
+			log.Printf("Serve all units before cluster map is set: %s\n", err)
+		}
+	}
+
 	srv.bin = binlog.NewBinLog(binlogDir,
 		conf.Bin.MemSize*1024*1024, conf.Bin.KeepNum)
 	if srv.bin == nil {
@@ -77,6 +106,15 @@ func NewServer(conf *config.Config) *Server {
 	srv.reqChan.SyncReqChan = make(chan *Request, 64)
 	srv.reqChan.DumpReqChan = make(chan *Request, 16)
 	srv.reqChan.CtrlReqChan = make(chan *Request, 16)
+	srv.groupChan = make(chan groupReq, 1024)
+	srv.syncFlushed = make(chan struct{})
+
+	srv.keeper = newSnapshotKeeper()
+	srv.masters = newMasterSet()
+	srv.waiter = newSyncWaiter(srv.masters, conf.Repl.MinSyncSlavers,
+		time.Duration(conf.Repl.SyncTimeout)*time.Millisecond)
+	srv.readers = newReadWaiter(srv,
+		time.Duration(conf.Repl.ReadWaitTime)*time.Millisecond)
 
 	return srv
 }
@@ -122,6 +160,11 @@ func clearSlaverOldData(conf *config.Config, mc *config.MasterConfig) error {
 			return err
 		}
 
+		err = mc.SetFullSync(0, nil)
+		if err != nil {
+			return err
+		}
+
 		err = mc.SetStatus(ctrl.SlaverInit)
 		if err != nil {
 			return err
@@ -146,23 +189,47 @@ func getMaxOpenFiles() int {
 }
 
 func (srv *Server) sendResp(write bool, req *Request, pkg []byte) {
+	var seq = srv.logWrite(write, req, pkg)
+	srv.reply(write, req, pkg, seq)
+}
+
+// logWrite adds the write to binlog, and returns the binlog seq of a write
+// from normal client.
+func (srv *Server) logWrite(write bool, req *Request, pkg []byte) uint64 {
+	if !write {
+		return 0
+	}
+
 	var cliType uint32 = ClientTypeNormal
 	if req.Cli != nil {
 		cliType = req.Cli.ClientType()
-
-		if pkg != nil {
-			req.Cli.AddResp(pkg)
-		}
 	}
 
+	var seq uint64
 	switch cliType {
 	case ClientTypeNormal:
-		if write {
-			srv.bin.AddRequest(&binlog.Request{0, req.Pkg})
-		}
+		seq = srv.bin.AddRequest(&binlog.Request{MasterSeq: 0, Pkg: req.Pkg})
+		// Tell client the binlog seq of the write
+		proto.OverWriteLogSeq(pkg, seq)
 	case ClientTypeSlaver:
-		if write {
-			srv.bin.AddRequest(&binlog.Request{req.Seq, req.Pkg})
+		srv.bin.AddRequest(&binlog.Request{MasterSeq: req.Seq, Pkg: req.Pkg})
+	}
+	srv.readers.Notify()
+	return seq
+}
+
+func (srv *Server) reply(write bool, req *Request, pkg []byte, seq uint64) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+
+	if req.Cli != nil && pkg != nil {
+		// Semi-sync write is replied after applied by slavers
+		if write && cliType == ClientTypeNormal && srv.waiter.Enabled(req) {
+			srv.waiter.Wait(req, pkg, seq)
+		} else {
+			req.Cli.AddResp(pkg)
 		}
 	}
 }
@@ -424,8 +491,14 @@ func (srv *Server) mIncr(req *Request) {
 	}
 }
 
-func (srv *Server) scan(req *Request) {
-	var pkg = srv.tbl.Scan(&req.PkgArgs, req.Cli)
+func (srv *Server) scan(req *Request, rc *store.ReadCache) {
+	var cliType uint32 = ClientTypeNormal
+	if req.Cli != nil {
+		cliType = req.Cli.ClientType()
+	}
+	var wa = store.NewWriteAccess(ClientTypeSlaver == cliType, srv.mc)
+
+	var pkg = srv.tbl.Scan(&req.PkgArgs, req.Cli, wa, rc)
 	srv.sendResp(false, req, pkg)
 }
 
@@ -466,12 +539,21 @@ func (srv *Server) syncStatus(req *Request) {
 
 		rowKey := string(in.RowKey)
 		switch rowKey {
+		case store.KeyFullSyncStart:
+			srv.mc.SetFullSync(uint64(in.Score), nil)
+		case store.KeyFullSyncPos:
+			var rawKey = make([]byte, len(in.Value))
+			copy(rawKey, in.Value)
+			srv.mc.SetFullSync(uint64(in.Score), rawKey)
 		case store.KeyFullSyncEnd:
+			srv.mc.SetFullSync(0, nil)
 			srv.mc.SetStatus(ctrl.SlaverIncrSync)
 			log.Printf("Switch sync status to SlaverIncrSync\n")
 		case store.KeyIncrSyncEnd:
 			srv.mc.SetStatus(ctrl.SlaverReady)
 			log.Printf("Switch sync status to SlaverReady")
+		case store.KeyMigrationEnd:
+			srv.endMigration()
 		case store.KeySyncLogMissing:
 			srv.mc.SetStatus(ctrl.SlaverNeedClear)
 			lastSeq, _ := srv.bin.GetMasterSeq()
@@ -513,12 +595,46 @@ func (srv *Server) newNormalMaster(req *Request, p *ctrl.PkgSlaveOf) {
 			return
 		}
 
+		if err := srv.canServeSlaver(p.SlaverAddr); err != nil {
+			// Slaver reconnects after closed, try again later
+			log.Printf("Refuse slave connection from %s: %s\n",
+				req.Cli.c.RemoteAddr(), err)
+			go func(cli *Client) {
+				time.Sleep(time.Second)
+				cli.Close()
+			}(req.Cli)
+			return
+		}
+
 		req.Cli.SetClientType(ClientTypeMaster) // switch client type
 
 		log.Printf("Receive a slave connection from %s, lastSeq=%d\n",
 			req.Cli.c.RemoteAddr(), p.LastSeq)
 
-		ms := NewMaster(p.SlaverAddr, p.LastSeq, false, 0, req.Cli, srv.bin)
+		// Full sync from a new snapshot or resume from the kept one
+		var ss *syncSnapshot
+		if p.LastSeq == 0 {
+			if p.FullSyncSeq > 0 {
+				ss = srv.keeper.Take(p.SlaverAddr, p.FullSyncSeq)
+				if ss != nil {
+					ss.key = p.FullSyncKey
+				} else {
+					log.Printf("Full sync snapshot %d for %s is missing\n",
+						p.FullSyncSeq, p.SlaverAddr)
+				}
+			} else {
+				ss = srv.newSyncSnapshot(p.SlaverAddr)
+			}
+		}
+
+		ms := NewMaster(p.SlaverAddr, p.LastSeq, false, nil, ss, srv.keeper,
+			req.Cli, srv.bin)
+		if p.SyncAck {
+			ms.SetFlowControl(srv.conf.Repl.SyncWindow, srv.conf.Repl.FullSyncRate)
+		}
+		ms.SetAckNotify(srv.waiter.notify)
+		srv.masters.Add(ms)
+		req.Cli.setMaster(ms)
 		go ms.GoAsync(srv.tbl)
 	case ClientTypeSlaver:
 		// Get response from master
@@ -553,7 +669,14 @@ func (srv *Server) newMigMaster(req *Request, p *ctrl.PkgMigrate) {
 		log.Printf("Receive a migration slave connection from %s(%s)\n",
 			req.Cli.c.RemoteAddr(), p.SlaverAddr)
 
-		ms := NewMaster(p.SlaverAddr, 0, true, p.UnitId, req.Cli, srv.bin)
+		ss := srv.newSyncSnapshot(p.SlaverAddr)
+		ms := NewMaster(p.SlaverAddr, 0, true, p.MigUnits(), ss, nil,
+			req.Cli, srv.bin)
+		if p.SyncAck {
+			ms.SetFlowControl(srv.conf.Repl.SyncWindow, srv.conf.Repl.FullSyncRate)
+		}
+		srv.masters.Add(ms)
+		req.Cli.setMaster(ms)
 		go ms.GoAsync(srv.tbl)
 	case ClientTypeSlaver:
 		// Get response from master
@@ -639,6 +762,9 @@ func (srv *Server) slaveOf(req *Request) {
 			if slv != nil {
 				slv.Close()
 			}
+			// Slavers of mine should sync again from the new data
+			srv.masters.CloseNormal()
+			srv.keeper.Clear()
 			srv.bin.AsSlaver()
 			srv.connectToMaster(srv.mc)
 		} else {
@@ -692,13 +818,25 @@ func (srv *Server) migrate(req *Request) {
 		if len(p.SlaverAddr) == 0 {
 			p.SlaverAddr = req.Cli.LocalAddr().String()
 		}
+		if p.Cancel {
+			err = srv.cancelMigration(p.UnitId)
+			if err != nil {
+				log.Printf("Failed to cancel migration: %s\n", err)
+				srv.replyMigrate(req, err.Error())
+			} else {
+				srv.replyMigrate(req, "") // Success
+			}
+			return
+		}
+
 		if p.MasterAddr == p.SlaverAddr {
 			log.Printf("Master and slaver address are the same!\n")
 			srv.replyMigrate(req, "master and slaver address are the same")
 			return
 		}
 
-		err = srv.mc.SetMigration(p.MasterAddr, p.SlaverAddr, p.UnitId)
+		var units = p.MigUnits()
+		err = srv.mc.SetMigration(p.MasterAddr, p.SlaverAddr, units)
 		if err != nil {
 			log.Printf("Failed to update migration config: %s\n", err)
 			srv.replyMigrate(req,
@@ -715,7 +853,7 @@ func (srv *Server) migrate(req *Request) {
 			if slv != nil {
 				slv.Close()
 			}
-			if srv.tbl.HasUnitData(p.UnitId) {
+			if srv.hasUnitsData(units) {
 				err = srv.mc.SetStatus(ctrl.SlaverNeedClear)
 				if err != nil {
 					log.Printf("Failed to set migration status: %s\n", err)
@@ -775,9 +913,9 @@ func (srv *Server) slaverStatus(req *Request) {
 				if p.Migration {
 					if !m.Migration {
 						p.ErrMsg = fmt.Sprintf("check migration status on normal slaver")
-					} else if m.UnitId != p.UnitId {
-						p.ErrMsg = fmt.Sprintf("unit id mismatch (%d, %d)",
-							m.UnitId, p.UnitId)
+					} else if !m.Units.Has(p.UnitId) {
+						p.ErrMsg = fmt.Sprintf("unit id mismatch (%s, %d)",
+							m.Units, p.UnitId)
 					}
 				} else {
 					if m.Migration {
@@ -803,9 +941,12 @@ func (srv *Server) slaverStatus(req *Request) {
 	}
 }
 
-func (srv *Server) deleteMigrationUnit(unitId uint16, m config.MasterInfo) error {
+// deleteMigrationUnits deletes data of the units. If all units under
+// migration are deleted, the migration config is cleared.
+func (srv *Server) deleteMigrationUnits(units ctrl.UnitSet,
+	m config.MasterInfo) error {
 	var match bool
-	if len(m.MasterAddr) > 0 && m.Migration && m.UnitId == unitId {
+	if len(m.MasterAddr) > 0 && m.Migration && len(m.Units.Remove(units)) == 0 {
 		match = true
 	}
 
@@ -817,7 +958,13 @@ func (srv *Server) deleteMigrationUnit(unitId uint16, m config.MasterInfo) error
 		}
 	}
 
-	err = srv.tbl.DeleteUnit(unitId)
+	err = srv.deleteUnits(units)
+	if err != nil {
+		return err
+	}
+
+	// The cutover is finished manually
+	err = srv.mc.SetCutoverPending(units, false)
 	if err != nil {
 		return err
 	}
@@ -847,7 +994,7 @@ func (srv *Server) deleteUnit(req *Request) {
 		if err != nil {
 			p.ErrMsg = fmt.Sprintf("decode failed %s", err)
 		} else {
-			err = srv.deleteMigrationUnit(p.UnitId, srv.mc.GetMaster())
+			err = srv.deleteMigrationUnits(p.DelUnits(), srv.mc.GetMaster())
 			if err != nil {
 				p.ErrMsg = fmt.Sprintf("delete unit failed %s", err)
 			}
@@ -867,10 +1014,13 @@ func (srv *Server) deleteUnit(req *Request) {
 }
 
 func (srv *Server) processRead() {
+	var rc = srv.tbl.NewReadCache()
+	defer rc.Destroy()
+
 	for {
 		select {
 		case req := <-srv.reqChan.ReadReqChan:
-			if !req.Cli.IsClosed() {
+			if !req.Cli.IsClosed() && !srv.delayRead(req) {
 				switch req.Cmd {
 				case proto.CmdAuth:
 					srv.auth(req)
@@ -881,7 +1031,7 @@ func (srv *Server) processRead() {
 				case proto.CmdMGet:
 					srv.mGet(req)
 				case proto.CmdScan:
-					srv.scan(req)
+					srv.scan(req, rc)
 				}
 			}
 		}
@@ -889,24 +1039,31 @@ func (srv *Server) processRead() {
 }
 
 func (srv *Server) processWrite() {
+	var gr = newGroupReplier()
 	for {
 		select {
+		case r := <-gr.replies:
+			srv.replyGroup(gr, r)
 		case req := <-srv.reqChan.WriteReqChan:
 			if !req.Cli.IsClosed() {
+				if gr.pending >= maxPendingReplies {
+					srv.replyGroup(gr, <-gr.replies)
+				}
+
+				var grouped bool
+				srv.wrMtx.RLock()
 				switch req.Cmd {
-				case proto.CmdSet:
-					srv.set(req)
-				case proto.CmdDel:
-					srv.del(req)
+				case proto.CmdSet, proto.CmdDel, proto.CmdMSet, proto.CmdMDel:
+					grouped = srv.groupWrite(req, gr)
 				case proto.CmdIncr:
 					srv.incr(req)
-				case proto.CmdMSet:
-					srv.mSet(req)
-				case proto.CmdMDel:
-					srv.mDel(req)
 				case proto.CmdMIncr:
 					srv.mIncr(req)
 				}
+				// A grouped write holds the read lock until logged
+				if !grouped {
+					srv.wrMtx.RUnlock()
+				}
 			}
 		}
 	}
@@ -916,7 +1073,14 @@ func (srv *Server) processSync() {
 	for {
 		select {
 		case req := <-srv.reqChan.SyncReqChan:
+			if req.Cli == nil {
+				// The flush mark, all requests queued before are processed
+				srv.syncFlushed <- struct{}{}
+				continue
+			}
 			if !req.Cli.IsClosed() {
+				var pkgLen = len(req.Pkg)
+				srv.wrMtx.RLock()
 				switch req.Cmd {
 				case proto.CmdSet:
 					srv.set(req)
@@ -934,6 +1098,19 @@ func (srv *Server) processSync() {
 					srv.sync(req)
 				case proto.CmdSyncSt:
 					srv.syncStatus(req)
+				case proto.CmdDrop:
+					srv.drop(req)
+				case proto.CmdCatalog:
+					srv.catalog(req)
+				case proto.CmdCluster:
+					srv.cluster(req)
+				}
+				srv.wrMtx.RUnlock()
+				srv.readers.Notify() // Sync status may change lastSeq
+
+				if req.Slv != nil {
+					idle := len(srv.reqChan.SyncReqChan) == 0
+					req.Slv.Applied(req.Cli, req.Seq, pkgLen, idle)
 				}
 			}
 		}
@@ -968,6 +1145,26 @@ func (srv *Server) processCtrl() {
 					srv.slaverStatus(req)
 				case proto.CmdDelUnit:
 					srv.deleteUnit(req)
+				case proto.CmdBackup:
+					srv.backup(req)
+				case proto.CmdReplSt:
+					srv.replStatus(req)
+				case proto.CmdPromote:
+					srv.promote(req)
+				case proto.CmdCutover:
+					srv.cutover(req)
+				case proto.CmdCluster:
+					srv.cluster(req)
+				case proto.CmdDrop:
+					srv.drop(req)
+				case proto.CmdCatalog:
+					srv.catalog(req)
+				case proto.CmdTableSt:
+					srv.tableStats(req)
+				case proto.CmdCompact:
+					srv.compact(req)
+				case proto.CmdProperty:
+					srv.property(req)
 				}
 			}
 		}
@@ -983,6 +1180,18 @@ func Run(conf *config.Config) {
 		return
 	}
 
+	err := srv.Start()
+	if err != nil {
+		log.Fatalln("Listen failed:", err)
+	}
+
+	srv.Serve()
+}
+
+// Start starts the processing goroutines, listens on the server address and
+// reconnects to master if it's a slaver. Call Serve to accept connections.
+func (srv *Server) Start() error {
+	var conf = srv.conf
 	go srv.bin.GoWriteBinLog()
 
 	var totalProcNum = runtime.NumCPU() * 2
@@ -997,17 +1206,21 @@ func Run(conf *config.Config) {
 	for i := 0; i < writeProcNum; i++ {
 		go srv.processWrite()
 	}
+	go srv.processGroup()
 	go srv.processSync() // Use 1 goroutine to make sure data consistency
 	go srv.processDump()
 	go srv.processCtrl()
+	go srv.waiter.GoCheck()
+	go srv.readers.GoCheck()
 
 	log.Printf("Goroutine distribution: read %d, write %d, %s\n",
-		readProcNum, writeProcNum, "sync 1, dump 1, ctrl 1")
+		readProcNum, writeProcNum, "group 1, sync 1, dump 1, ctrl 1")
 
 	link, err := net.Listen(conf.Db.Network, conf.Db.Address)
 	if err != nil {
-		log.Fatalln("Listen failed:", err)
+		return err
 	}
+	srv.link = link
 
 	log.Printf("GoTable %s started on %s://%s\n",
 		table.Version, conf.Db.Network, conf.Db.Address)
@@ -1016,7 +1229,7 @@ func Run(conf *config.Config) {
 	hasMaster, migration, _ := srv.mc.GetMasterUnit()
 	if hasMaster && !migration {
 		lastSeq, valid := srv.bin.GetMasterSeq()
-		if !valid {
+		if !valid && srv.mc.GetMaster().FullSyncSeq == 0 {
 			srv.mc.SetStatus(ctrl.SlaverNeedClear)
 			// Any better solution?
 			log.Fatalf("Slaver lastSeq %d is out of sync, please clear old data! "+
@@ -1027,17 +1240,21 @@ func Run(conf *config.Config) {
 		srv.connectToMaster(srv.mc)
 	}
 
-	var authEnabled bool
 	if conf.Auth.AdminPwd != "" {
-		authEnabled = true
+		srv.authEnabled = true
 		srv.tbl.SetPassword(proto.AdminDbId, conf.Auth.AdminPwd)
 	}
 
+	return nil
+}
+
+// Serve accepts client connections, it never returns.
+func (srv *Server) Serve() {
 	for {
-		if c, err := link.Accept(); err == nil {
+		if c, err := srv.link.Accept(); err == nil {
 			//log.Printf("New connection %s\t%s\n", c.RemoteAddr(), c.LocalAddr())
 
-			cli := NewClient(c, authEnabled)
+			cli := NewClient(c, srv.authEnabled)
 			go cli.GoRecvRequest(srv.reqChan, nil)
 			go cli.GoSendResponse()
 		}
@@ -0,0 +1,203 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/heap"
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"sync"
+	"time"
+)
+
+// isApplied returns true if the write with the binlog seq has been applied.
+// The binlog seq is assigned after the write is applied to table, both on
+// master and slaver.
+func (srv *Server) isApplied(logSeq uint64) bool {
+	return srv.lastSeq() >= logSeq
+}
+
+// delayRead delays the session read (Get, MGet and Scan with LogSeq) until
+// the writes of the session have been applied, so that the client can read
+// its own writes on slavers. It returns true if the request is delayed.
+func (srv *Server) delayRead(req *Request) bool {
+	switch req.Cmd {
+	case proto.CmdGet, proto.CmdMGet, proto.CmdScan:
+	default:
+		return false
+	}
+
+	var logSeq = proto.GetLogSeq(req.Pkg)
+	if logSeq == 0 || srv.isApplied(logSeq) {
+		return false
+	}
+
+	srv.readers.Wait(req, logSeq)
+	return true
+}
+
+// Session read waiting for the write with logSeq to be applied
+type readReq struct {
+	req      *Request
+	logSeq   uint64
+	deadline time.Time
+	done     bool // Put back to the read queue or replied
+}
+
+// readHeap orders the waiting reads by logSeq.
+type readHeap []*readReq
+
+func (h readHeap) Len() int            { return len(h) }
+func (h readHeap) Less(i, j int) bool  { return h[i].logSeq < h[j].logSeq }
+func (h readHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *readHeap) Push(x interface{}) { *h = append(*h, x.(*readReq)) }
+func (h *readHeap) Pop() interface{} {
+	var old = *h
+	var n = len(old)
+	var x = old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// readWaiter puts the delayed session reads back to the read queue when the
+// writes are applied, or replies EcNotApplied on timeout. One goroutine
+// waits for all of them, woken up by Notify after writes are applied.
+type readWaiter struct {
+	srv     *Server
+	timeout time.Duration
+	notify  chan struct{}
+
+	mtx    sync.Mutex // protects following
+	bySeq  readHeap
+	byTime []*readReq // In deadline order, as all reads wait for timeout
+}
+
+func newReadWaiter(srv *Server, timeout time.Duration) *readWaiter {
+	var rw = new(readWaiter)
+	rw.srv = srv
+	rw.timeout = timeout
+	rw.notify = make(chan struct{}, 1)
+	return rw
+}
+
+// Wait queues the read until the write with logSeq is applied.
+func (rw *readWaiter) Wait(req *Request, logSeq uint64) {
+	var rr = &readReq{req: req, logSeq: logSeq,
+		deadline: time.Now().Add(rw.timeout)}
+	rw.mtx.Lock()
+	heap.Push(&rw.bySeq, rr)
+	rw.byTime = append(rw.byTime, rr)
+	rw.mtx.Unlock()
+
+	rw.Notify()
+}
+
+// Notify wakes up the waiter when writes are applied.
+func (rw *readWaiter) Notify() {
+	select {
+	case rw.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Timer of the waiter when no read is waiting
+const readWaitIdle = time.Hour
+
+func (rw *readWaiter) GoCheck() {
+	var timer = time.NewTimer(readWaitIdle)
+	for {
+		select {
+		case <-rw.notify:
+		case <-timer.C:
+		}
+
+		var next = rw.check()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next.IsZero() {
+			timer.Reset(readWaitIdle)
+		} else {
+			timer.Reset(next.Sub(time.Now()))
+		}
+	}
+}
+
+// check handles the applied and timeout reads, and returns the deadline of
+// the first waiting read, or zero time if none.
+func (rw *readWaiter) check() time.Time {
+	var lastSeq = rw.srv.lastSeq()
+	var now = time.Now()
+	var applied, expired []*readReq
+
+	rw.mtx.Lock()
+	for len(rw.bySeq) > 0 && (rw.bySeq[0].done || rw.bySeq[0].logSeq <= lastSeq) {
+		var rr = heap.Pop(&rw.bySeq).(*readReq)
+		if !rr.done {
+			rr.done = true
+			applied = append(applied, rr)
+		}
+	}
+	for len(rw.byTime) > 0 && (rw.byTime[0].done || now.After(rw.byTime[0].deadline)) {
+		var rr = rw.byTime[0]
+		rw.byTime[0] = nil
+		rw.byTime = rw.byTime[1:]
+		if !rr.done {
+			rr.done = true
+			expired = append(expired, rr)
+		}
+	}
+	// Drop timeout reads still in the heap if they are the most
+	if len(rw.bySeq) > 2*len(rw.byTime) {
+		var live = rw.bySeq[:0]
+		for _, rr := range rw.bySeq {
+			if !rr.done {
+				live = append(live, rr)
+			}
+		}
+		for i := len(live); i < len(rw.bySeq); i++ {
+			rw.bySeq[i] = nil
+		}
+		rw.bySeq = live
+		heap.Init(&rw.bySeq)
+	}
+	var next time.Time
+	if len(rw.byTime) > 0 {
+		next = rw.byTime[0].deadline
+	}
+	rw.mtx.Unlock()
+
+	// The read queue is not sent to with the lock held, as the read
+	// goroutines call Wait
+	for _, rr := range applied {
+		if !rr.req.Cli.IsClosed() {
+			rw.srv.reqChan.ReadReqChan <- rr.req
+		}
+	}
+	for _, rr := range expired {
+		if rr.req.Cmd == proto.CmdGet {
+			rw.srv.replyOneOp(rr.req, table.EcNotApplied)
+		} else {
+			rw.srv.replyMultiOp(rr.req, table.EcNotApplied)
+		}
+	}
+
+	return next
+}
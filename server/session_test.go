@@ -0,0 +1,98 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"testing"
+	"time"
+)
+
+func TestSessionRead(t *testing.T) {
+	var addrA, addrB = "127.0.0.1:26741", "127.0.0.1:26742"
+	var conf = newTestConfig("session_b", addrB, t)
+	conf.Repl.ReadWaitTime = 300
+	var _, cliA = startTestServer("session_a", addrA, t)
+	var _, cliB = runTestServer(conf, t)
+	defer cliA.Close()
+	defer cliB.Close()
+
+	var ca, cb = cliA.NewContext(0), cliB.NewContext(0)
+	var ctrlB = table.CtrlContext(*cb)
+	err := ctrlB.SlaveOf(addrA)
+	if err != nil {
+		t.Fatalf("B slaveof A failed: %s", err)
+	}
+	setTestKeys(ca, 0, 10, t)
+	waitTestKeys(cb, 0, 10, t)
+
+	// Read the write of the session on the slaver
+	var s = table.NewSession()
+	var sa, sb = ca.WithSession(s), cb.WithSession(s)
+	err = sa.Set(1, []byte("sess"), []byte("col"), []byte("v1"), 0, 0)
+	if err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if s.LastSeq() == 0 {
+		t.Fatalf("Binlog seq of the write not recorded")
+	}
+	value, _, _, err := sb.Get(1, []byte("sess"), []byte("col"), 0)
+	if err != nil || string(value) != "v1" {
+		t.Fatalf("Session read failed: %q, %v", value, err)
+	}
+
+	// The read waits for the next write
+	var next = table.NewSession()
+	next.Update(s.LastSeq() + 1)
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		value, _, _, err := cb.WithSession(next).Get(1, []byte("sess"),
+			[]byte("col"), 0)
+		if err != nil || string(value) != "v2" {
+			t.Errorf("Session read not woken up: %q, %v", value, err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 100)
+	err = ca.Set(1, []byte("sess"), []byte("col"), []byte("v2"), 0, 0)
+	if err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	<-done
+
+	// The writes are never applied in time
+	var far = table.NewSession()
+	far.Update(s.LastSeq() + 1000)
+	var sf = cb.WithSession(far)
+	var start = time.Now()
+	_, _, _, err = sf.Get(1, []byte("sess"), []byte("col"), 0)
+	if err != table.ErrNotApplied {
+		t.Fatalf("Get should not be applied: %v", err)
+	}
+	if time.Since(start) < time.Millisecond*300 {
+		t.Fatalf("Replied before timeout: %s", time.Since(start))
+	}
+
+	var ma table.MGetArgs
+	ma.Add(1, []byte("sess"), []byte("col"), 0)
+	_, err = sf.MGet(ma)
+	if err != table.ErrNotApplied {
+		t.Fatalf("MGet should not be applied: %v", err)
+	}
+	_, err = sf.Scan(1, []byte("sess"), nil, true, 10)
+	if err != table.ErrNotApplied {
+		t.Fatalf("Scan should not be applied: %v", err)
+	}
+}
@@ -15,6 +15,7 @@
 package store
 
 import (
+	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/config"
 	"github.com/stevejiang/gotable/ctrl"
 )
@@ -23,12 +24,17 @@ type WriteAccess struct {
 	replication bool // Replication slaver
 	hasMaster   bool
 	migration   bool
-	unitId      uint16
+	units       ctrl.UnitSet // Units under migration
+	movedUnits  ctrl.UnitSet // Units migrated out of this server
+	cluster     bool         // Cluster mode, only ownedUnits are served
+	ownedUnits  ctrl.UnitSet // Units of this server in the cluster map
 }
 
 func NewWriteAccess(replication bool, mc *config.MasterConfig) *WriteAccess {
-	hasMaster, migration, unitId := mc.GetMasterUnit()
-	return &WriteAccess{replication, hasMaster, migration, unitId}
+	hasMaster, migration, units := mc.GetMasterUnit()
+	cluster, ownedUnits := mc.OwnedUnits()
+	return &WriteAccess{replication, hasMaster, migration, units,
+		mc.MovedUnits(), cluster, ownedUnits}
 }
 
 // Do we have right to write this key?
@@ -37,15 +43,11 @@ func (m *WriteAccess) CheckKey(dbId, tableId uint8, rowKey []byte) bool {
 		return true // Accept all replication data
 	}
 
-	if !m.hasMaster {
+	if !m.hasMaster && len(m.movedUnits) == 0 && !m.cluster {
 		return true
 	}
 
-	if m.migration {
-		return m.unitId != ctrl.GetUnitId(dbId, tableId, rowKey)
-	} else {
-		return false
-	}
+	return m.CheckUnit(ctrl.GetUnitId(dbId, tableId, rowKey))
 }
 
 // Do we have right to write this unit?
@@ -54,12 +56,16 @@ func (m *WriteAccess) CheckUnit(unitId uint16) bool {
 		return true // Accept all replication data
 	}
 
+	if m.isMovedUnit(unitId) {
+		return false
+	}
+
 	if !m.hasMaster {
 		return true
 	}
 
 	if m.migration {
-		return m.unitId != unitId
+		return !m.units.Has(unitId)
 	} else {
 		return false
 	}
@@ -78,3 +84,25 @@ func (m *WriteAccess) Check() bool {
 
 	return true
 }
+
+// Is the key in a unit migrated out of this server, or not served by this
+// server in the cluster map?
+func (m *WriteAccess) IsMoved(dbId, tableId uint8, rowKey []byte) bool {
+	if m.replication || (len(m.movedUnits) == 0 && !m.cluster) {
+		return false
+	}
+
+	return m.isMovedUnit(ctrl.GetUnitId(dbId, tableId, rowKey))
+}
+
+func (m *WriteAccess) isMovedUnit(unitId uint16) bool {
+	return m.movedUnits.Has(unitId) || (m.cluster && !m.ownedUnits.Has(unitId))
+}
+
+// Error code when we have no right to write this key
+func (m *WriteAccess) KeyErrCode(dbId, tableId uint8, rowKey []byte) int8 {
+	if m.IsMoved(dbId, tableId, rowKey) {
+		return table.EcMoved
+	}
+	return table.EcWriteSlaver
+}
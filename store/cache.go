@@ -0,0 +1,133 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"log"
+)
+
+// ReadCache keeps the scan iterators of a read worker. Creating a RocksDB
+// iterator allocates memory, while a cached iterator only needs a refresh
+// to see the latest data. Not safe for concurrent use.
+type ReadCache struct {
+	db   Engine
+	asc  rowIter
+	desc rowIter
+}
+
+type rowIter struct {
+	rOpt ReadOptions
+	it   Iterator
+}
+
+func (tbl *Table) NewReadCache() *ReadCache {
+	return &ReadCache{db: tbl.db}
+}
+
+func (rc *ReadCache) Destroy() {
+	rc.asc.destroy()
+	rc.desc.destroy()
+}
+
+func (ri *rowIter) destroy() {
+	if ri.it != nil {
+		ri.it.Destroy()
+		ri.it = nil
+	}
+	if ri.rOpt != nil {
+		ri.rOpt.Destroy()
+		ri.rOpt = nil
+	}
+}
+
+// rowIterator returns an iterator bounded by columns of the row prefix
+// (see rowPrefix). Ascending iterators use prefix bloom filters. Descending
+// ones seek in total order, as SeekToLast is not supported in prefix mode.
+// The iterator is valid until the next call.
+func (rc *ReadCache) rowIterator(prefix []byte, asc bool) (Iterator, error) {
+	var ri = &rc.desc
+	if asc {
+		ri = &rc.asc
+	}
+
+	if ri.rOpt == nil {
+		ri.rOpt = rc.db.NewReadOptions(false)
+		ri.rOpt.SetPrefixSameAsStart(asc)
+	}
+	// Bounds are changed in place for the cached iterator
+	if !asc {
+		ri.rOpt.SetLowerBound(prefix)
+	}
+	if end := prefixEnd(prefix); end != nil {
+		ri.rOpt.SetUpperBound(end)
+	}
+
+	if ri.it == nil {
+		ri.it = rc.db.NewIterator(ri.rOpt)
+		return ri.it, nil
+	}
+
+	var err = ri.it.Refresh()
+	if err != nil {
+		ri.destroy()
+		return nil, err
+	}
+	return ri.it, nil
+}
+
+// scanReply encodes KeyValues of the scan response one by one, as soon as
+// they are read. So keys and values in RocksDB memory are copied only once,
+// without building the KeyValue array.
+type scanReply struct {
+	out *proto.PkgScanResp
+	pkg []byte
+	num int
+}
+
+const scanReplyInitCap = 4096
+
+func newScanReply(out *proto.PkgScanResp) scanReply {
+	var headLen = out.HeadLength()
+	return scanReply{out: out, pkg: make([]byte, headLen, scanReplyInitCap)}
+}
+
+// add encodes the KeyValue, which can refer to RocksDB memory.
+func (r *scanReply) add(kv *proto.KeyValue) {
+	var n = kv.Length()
+	var pos = len(r.pkg)
+	if cap(r.pkg)-pos < n {
+		var pkg = make([]byte, pos, 2*cap(r.pkg)+n)
+		copy(pkg, r.pkg)
+		r.pkg = pkg
+	}
+	r.pkg = r.pkg[:pos+n]
+
+	_, err := kv.Encode(r.pkg[pos:])
+	if err != nil {
+		log.Fatalf("Encode failed: %s\n", err)
+	}
+	r.num++
+}
+
+// finish encodes the package head, with the final PkgFlag and ErrCode.
+func (r *scanReply) finish() []byte {
+	_, err := r.out.EncodeHead(r.pkg, r.num)
+	if err != nil {
+		log.Fatalf("Encode failed: %s\n", err)
+	}
+	proto.OverWriteLen(r.pkg, len(r.pkg))
+	return r.pkg
+}
@@ -0,0 +1,281 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+// #include <rocksdb/c.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/ctrl"
+	"io/ioutil"
+	"os"
+	"unsafe"
+)
+
+const (
+	kCompactionStyleLevel     = 0x0
+	kCompactionStyleUniversal = 0x1
+	kCompactionStyleFIFO      = 0x2
+	maxFIFOFilesSize          = uint64(1) << 62 // Only drop files by TTL
+
+	// The column families of the tables mapped out of the default one,
+	// saved in the DB directory when opened
+	cfMappingFile = "GOTABLE-CF-MAPPING"
+)
+
+// Options of a column family
+type CFOptions struct {
+	Name         string
+	Tables       [][2]int // dbId and tableId, tableId is -1 for a whole DB
+	Compression  int      // -1: the same as the default column family
+	WriteBufSize int      // 0: the same as the default column family
+	BlockSize    int      // 0: the same as the default column family
+	BloomBits    int      // 0: the same as the default column family
+	TTL          int64    // Seconds, 0: no TTL
+}
+
+type columnFamily struct {
+	name   string
+	handle *C.rocksdb_column_family_handle_t
+	opt    *C.rocksdb_options_t
+	fp     *C.rocksdb_filterpolicy_t
+	fifo   *C.rocksdb_fifo_compaction_options_t
+}
+
+// newColumnFamily creates the column family options. Options not set in o
+// are the same as the default column family.
+func newColumnFamily(o *CFOptions, dbOpt *Options,
+	cache *C.rocksdb_cache_t) *columnFamily {
+	var cf = &columnFamily{name: o.Name}
+	cf.opt = C.rocksdb_options_create()
+
+	var opt = *dbOpt
+	if o.WriteBufSize > 0 {
+		opt.WriteBufSize = o.WriteBufSize
+	}
+	if o.Compression >= 0 {
+		opt.Compression = o.Compression
+		opt.LevelCompression = nil
+	}
+	if o.BlockSize > 0 {
+		opt.BlockSize = o.BlockSize
+	}
+	if o.BloomBits > 0 {
+		opt.BloomBits = o.BloomBits
+	}
+	setColumnOptions(cf.opt, &opt)
+
+	var block_options *C.rocksdb_block_based_table_options_t
+	block_options, cf.fp = newBlockOptions(cache, opt.BlockSize, opt.BloomBits)
+	C.rocksdb_options_set_block_based_table_factory(cf.opt, block_options)
+
+	// Drop SST files older than TTL
+	if o.TTL > 0 {
+		cf.fifo = C.rocksdb_fifo_compaction_options_create()
+		C.rocksdb_fifo_compaction_options_set_max_table_files_size(cf.fifo,
+			C.uint64_t(maxFIFOFilesSize))
+		C.rocksdb_options_set_compaction_style(cf.opt, kCompactionStyleFIFO)
+		C.rocksdb_options_set_fifo_compaction_options(cf.opt, cf.fifo)
+		C.rocksdb_options_set_ttl(cf.opt, C.uint64_t(o.TTL))
+	}
+
+	return cf
+}
+
+func (cf *columnFamily) destroyHandle() {
+	if cf.handle != nil {
+		C.rocksdb_column_family_handle_destroy(cf.handle)
+		cf.handle = nil
+	}
+}
+
+func (cf *columnFamily) destroyOptions() {
+	if cf.opt != nil {
+		C.rocksdb_options_destroy(cf.opt)
+		cf.opt = nil
+	}
+	if cf.fp != nil {
+		C.rocksdb_filterpolicy_destroy(cf.fp)
+		cf.fp = nil
+	}
+	if cf.fifo != nil {
+		C.rocksdb_fifo_compaction_options_destroy(cf.fifo)
+		cf.fifo = nil
+	}
+}
+
+// openColumnFamilies opens the DB with all column families in db.cfs.
+// Every column family already in the DB must be configured.
+func (db *DB) openColumnFamilies(name string) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var errStr *C.char
+	var num C.size_t
+	var list = C.rocksdb_list_column_families(db.opt, cname, &num, &errStr)
+	if errStr != nil {
+		// A new DB has no column family yet
+		C.free(unsafe.Pointer(errStr))
+		errStr = nil
+	} else {
+		var names = (*[1 << 20]*C.char)(unsafe.Pointer(list))[:num:num]
+		var err error
+		for _, cn := range names {
+			var found bool
+			var cfName = C.GoString(cn)
+			for _, cf := range db.cfs {
+				if cf.name == cfName {
+					found = true
+					break
+				}
+			}
+			if !found {
+				err = fmt.Errorf("column family %s exists but not configured",
+					cfName)
+				break
+			}
+		}
+		C.rocksdb_list_column_families_destroy(list, num)
+		if err != nil {
+			return err
+		}
+	}
+
+	var n = len(db.cfs)
+	var ptrSize = C.size_t(unsafe.Sizeof((*C.char)(nil)))
+	var pNames = C.malloc(ptrSize * C.size_t(n))
+	defer C.free(pNames)
+	var pOpts = C.malloc(ptrSize * C.size_t(n))
+	defer C.free(pOpts)
+	var pHandles = C.malloc(ptrSize * C.size_t(n))
+	defer C.free(pHandles)
+
+	var cNames = (*[1 << 20]*C.char)(pNames)[:n:n]
+	var cOpts = (*[1 << 20]*C.rocksdb_options_t)(pOpts)[:n:n]
+	var cHandles = (*[1 << 20]*C.rocksdb_column_family_handle_t)(pHandles)[:n:n]
+	for i, cf := range db.cfs {
+		cNames[i] = C.CString(cf.name)
+		defer C.free(unsafe.Pointer(cNames[i]))
+		cOpts[i] = cf.opt
+	}
+
+	db.db = C.rocksdb_open_column_families(db.opt, cname, C.int(n),
+		&cNames[0], &cOpts[0], &cHandles[0], &errStr)
+	if errStr != nil {
+		defer C.free(unsafe.Pointer(errStr))
+		return errors.New(C.GoString(errStr))
+	}
+
+	for i, cf := range db.cfs {
+		cf.handle = cHandles[i]
+	}
+	return nil
+}
+
+// cfMapping returns the column family names of tables not in the default
+// column family, the key is "dbId:tableId".
+func (db *DB) cfMapping() map[string]string {
+	var m = make(map[string]string)
+	for i, c := range db.cfMap {
+		if c > 0 {
+			m[fmt.Sprintf("%d:%d", i>>8, i&0xFF)] = db.cfs[c].name
+		}
+	}
+	return m
+}
+
+// checkMapping refuses to open the DB if a table is mapped to another column
+// family, but still has data in the column family it was mapped to when
+// the DB was opened last time. Reads of the table would miss the old data.
+func (db *DB) checkMapping() error {
+	var old = make(map[string]string)
+	value, err := ioutil.ReadFile(cfMappingFileName(db.name))
+	if err == nil {
+		err = json.Unmarshal(value, &old)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read column family mapping failed: %s", err)
+	}
+
+	var cur = db.cfMapping()
+	for i := 0; i < 256*256; i++ {
+		var key = fmt.Sprintf("%d:%d", i>>8, i&0xFF)
+		if old[key] == cur[key] {
+			continue
+		}
+		var oldName = old[key]
+		if len(oldName) == 0 {
+			oldName = "default"
+		}
+		var newName = cur[key]
+		if len(newName) == 0 {
+			newName = "default"
+		}
+		for _, cf := range db.cfs {
+			if cf.name == oldName && db.cfHasTable(cf, uint8(i>>8), uint8(i)) {
+				return fmt.Errorf("table %s has data in column family %s, "+
+					"cannot map it to %s", key, oldName, newName)
+			}
+		}
+	}
+
+	return writeCFMapping(db.name, cur)
+}
+
+// cfHasTable checks whether the column family has data of the table in any
+// unit.
+func (db *DB) cfHasTable(cf *columnFamily, dbId, tableId uint8) bool {
+	var it = C.rocksdb_create_iterator_cf(db.db, db.rOpt, cf.handle)
+	defer C.rocksdb_iter_destroy(it)
+
+	for u := 0; u < ctrl.TotalUnitNum; u++ {
+		var prefix = getRawUnitKey(uint16(u), dbId, tableId)
+		C.rocksdb_iter_seek(it, (*C.char)(unsafe.Pointer(&prefix[0])),
+			C.size_t(len(prefix)))
+		if C.rocksdb_iter_valid(it) == 0 {
+			return false
+		}
+		var keyLen C.size_t
+		var key = cBytes(C.rocksdb_iter_key(it, &keyLen), keyLen)
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func cfMappingFileName(dir string) string {
+	return fmt.Sprintf("%s/%s", dir, cfMappingFile)
+}
+
+func writeCFMapping(dir string, m map[string]string) error {
+	value, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	var name = cfMappingFileName(dir)
+	var tmpName = name + ".tmp"
+	err = ioutil.WriteFile(tmpName, value, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
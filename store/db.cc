@@ -12,4 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build cgo
+// +build cgo
+
 //empty c++ file tells cgo use g++ as linker
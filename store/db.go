@@ -19,33 +19,168 @@ package store
 import "C"
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"github.com/stevejiang/gotable/config"
+	"log"
+	"strconv"
 	"unsafe"
 )
 
+const (
+	kNoCompression     = 0x0
+	kSnappyCompression = 0x1
+	kZlibCompression   = 0x2
+	kBZip2Compression  = 0x3
+	kLZ4Compression    = 0x4
+	kLZ4HCCompression  = 0x5
+)
+
 type DB struct {
+	name  string
 	db    *C.rocksdb_t
 	opt   *C.rocksdb_options_t
 	rOpt  *C.rocksdb_readoptions_t // fill cache by default
 	wOpt  *C.rocksdb_writeoptions_t
 	cache *C.rocksdb_cache_t
 	fp    *C.rocksdb_filterpolicy_t
+	rl    *C.rocksdb_ratelimiter_t
+	cfs   []*columnFamily // cfs[0] is the default column family
+	cfMap []uint8         // dbId<<8|tableId => index of cfs, nil if no cfs
 }
 
-type Iterator struct {
-	it *C.rocksdb_iterator_t
+// RocksDB options, 0 or nil means the RocksDB default value.
+type Options struct {
+	WriteBufSize     int
+	Compression      int
+	LevelCompression []int // Compression of each level, overrides Compression
+	BlockSize        int
+	BloomBits        int // 0: 10 bits per key
+	MaxBgJobs        int
+	MaxWriteBufNum   int
+	CompactionStyle  int
+	NumLevels        int
+	LevelBaseSize    int64
+	LevelMultiplier  float64
+	TargetFileSize   int64
+	RateLimit        int64 // Bytes per second
 }
 
-type ReadOptions struct {
-	rOpt *C.rocksdb_readoptions_t
-	snap *C.rocksdb_snapshot_t
-	db   *C.rocksdb_t
+// Iterator over all column families. Keys of different column families
+// never overlap, so it merges the iterators of every column family.
+type rocksIterator struct {
+	its []*C.rocksdb_iterator_t
+	cur int  // The iterator of the current key, -1 if not valid
+	fwd bool // Moving forward or backward
 }
 
-type WriteBatch struct {
+type rocksReadOptions struct {
+	rOpt  *C.rocksdb_readoptions_t
+	snap  *C.rocksdb_snapshot_t
+	db    *C.rocksdb_t
+	upper unsafe.Pointer // C copy of iterate upper bound
+	lower unsafe.Pointer // C copy of iterate lower bound
+
+	upperCap, lowerCap int
+}
+
+// Enough for most bounds: wUnitId+cDbId+cTableId+cKeyLen+sRowKey+colSpace
+const minBoundCap = 64
+
+type rocksWriteBatch struct {
 	batch *C.rocksdb_writebatch_t
 }
 
+func init() {
+	registerEngine(EngineRocksDB, openRocksDB)
+}
+
+// openRocksDB opens the RocksDB engine with the options in conf.
+func openRocksDB(dir string, maxOpenFiles int,
+	conf *config.Config) (Engine, error) {
+	var db = &conf.Db
+	var compression = db.Compression
+	comp, ok := compressionType(compression)
+	if !ok {
+		compression = "no"
+	}
+
+	var opt = Options{WriteBufSize: db.WriteBufSize, Compression: comp,
+		BlockSize: db.BlockSize, BloomBits: db.BloomBits,
+		MaxBgJobs: db.MaxBgJobs, MaxWriteBufNum: db.MaxWriteBufNum,
+		NumLevels: db.NumLevels, LevelBaseSize: db.LevelBaseSize,
+		LevelMultiplier: db.LevelMultiplier, TargetFileSize: db.TargetFileSize,
+		RateLimit: db.RateLimit}
+	if db.CompactionStyle == "universal" {
+		opt.CompactionStyle = kCompactionStyleUniversal
+	}
+	for _, c := range db.LevelCompression {
+		levelComp, _ := compressionType(c)
+		opt.LevelCompression = append(opt.LevelCompression, levelComp)
+	}
+
+	var cfOpts = make([]CFOptions, 0, len(conf.CFs))
+	for _, cf := range conf.CFs {
+		var o = CFOptions{Name: cf.Name, Compression: -1,
+			WriteBufSize: cf.WriteBufSize, BlockSize: cf.BlockSize,
+			BloomBits: cf.BloomBits, TTL: cf.TTL}
+		if c, ok := compressionType(cf.Compression); ok {
+			o.Compression = c
+		}
+		for _, t := range cf.Tables {
+			dbId, tableId, err := config.ParseTable(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid column family table: %s", err)
+			}
+			o.Tables = append(o.Tables, [2]int{dbId, tableId})
+		}
+		cfOpts = append(cfOpts, o)
+	}
+
+	var rdb = NewDB()
+	err := rdb.Open(dir, true, maxOpenFiles, db.CacheSize, &opt, cfOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Open DB with maxOpenFiles %d, writeBufSize %dMB, cacheSize %dMB, "+
+		"compression(%s, %d)\n", maxOpenFiles, db.WriteBufSize/1048576,
+		db.CacheSize/1048576, compression, comp)
+	if len(db.LevelCompression) > 0 {
+		log.Printf("Compression per level %v\n", db.LevelCompression)
+	}
+	if len(db.CompactionStyle) > 0 || db.RateLimit > 0 {
+		log.Printf("Compaction style %q, rate limit %d bytes/s\n",
+			db.CompactionStyle, db.RateLimit)
+	}
+	for _, cf := range conf.CFs {
+		log.Printf("Column family %s with tables %v, compression %q, ttl %d\n",
+			cf.Name, cf.Tables, cf.Compression, cf.TTL)
+	}
+
+	return rdb, nil
+}
+
+// compressionType converts the compression name to RocksDB type.
+func compressionType(compression string) (int, bool) {
+	switch compression {
+	case "no":
+		return kNoCompression, true
+	case "snappy":
+		return kSnappyCompression, true
+	case "zlib":
+		return kZlibCompression, true
+	case "bzip2":
+		return kBZip2Compression, true
+	case "lz4":
+		return kLZ4Compression, true
+	case "lz4hc":
+		return kLZ4HCCompression, true
+	}
+	return kNoCompression, false
+}
+
 func NewDB() *DB {
 	db := new(DB)
 
@@ -54,8 +189,15 @@ func NewDB() *DB {
 
 func (db *DB) Close() {
 	if db.db != nil {
+		for _, cf := range db.cfs {
+			cf.destroyHandle()
+		}
 		C.rocksdb_close(db.db)
 		db.db = nil
+		for _, cf := range db.cfs[1:] {
+			cf.destroyOptions() // Options of the default one is db.opt
+		}
+		db.cfs = nil
 
 		if db.opt != nil {
 			C.rocksdb_options_destroy(db.opt)
@@ -72,42 +214,137 @@ func (db *DB) Close() {
 		if db.fp != nil {
 			C.rocksdb_filterpolicy_destroy(db.fp)
 		}
+		if db.rl != nil {
+			C.rocksdb_ratelimiter_destroy(db.rl)
+		}
 	}
 }
 
+// Open the DB. Data of the tables mapped in cfs are stored in their own
+// column families, the others are in the default column family.
 func (db *DB) Open(name string, createIfMissing bool, maxOpenFiles int,
-	writeBufSize int, cacheSize int64, compression int) error {
+	cacheSize int64, o *Options, cfs []CFOptions) error {
+	db.name = name
 	db.opt = C.rocksdb_options_create()
 	C.rocksdb_options_set_create_if_missing(db.opt, boolToUchar(createIfMissing))
-	C.rocksdb_options_set_write_buffer_size(db.opt, C.size_t(writeBufSize))
+	C.rocksdb_options_set_create_missing_column_families(db.opt, 1)
 	C.rocksdb_options_set_max_open_files(db.opt, C.int(maxOpenFiles))
-	C.rocksdb_options_set_compression(db.opt, C.int(compression))
+	if o.MaxBgJobs > 0 {
+		C.rocksdb_options_set_max_background_jobs(db.opt, C.int(o.MaxBgJobs))
+	}
+	if o.RateLimit > 0 {
+		db.rl = C.rocksdb_ratelimiter_create(C.int64_t(o.RateLimit),
+			rateLimitRefillUs, rateLimitFairness)
+		C.rocksdb_options_set_ratelimiter(db.opt, db.rl)
+	}
+	setColumnOptions(db.opt, o)
 
-	var block_options = C.rocksdb_block_based_options_create()
 	db.cache = C.rocksdb_cache_create_lru(C.size_t(cacheSize))
-	C.rocksdb_block_based_options_set_block_cache(block_options, db.cache)
-	db.fp = C.rocksdb_filterpolicy_create_bloom(10)
-	C.rocksdb_block_based_options_set_filter_policy(block_options, db.fp)
-
+	var block_options *C.rocksdb_block_based_table_options_t
+	block_options, db.fp = newBlockOptions(db.cache, o.BlockSize, o.BloomBits)
 	C.rocksdb_options_set_block_based_table_factory(db.opt, block_options)
 
-	cname := C.CString(name)
-	defer C.free(unsafe.Pointer(cname))
+	db.cfs = []*columnFamily{&columnFamily{name: "default", opt: db.opt}}
+	for i := 0; i < len(cfs); i++ {
+		db.cfs = append(db.cfs, newColumnFamily(&cfs[i], o, db.cache))
+	}
 
-	var errStr *C.char
-	db.db = C.rocksdb_open(db.opt, cname, &errStr)
-	if errStr != nil {
-		defer C.free(unsafe.Pointer(errStr))
-		return errors.New(C.GoString(errStr))
+	err := db.openColumnFamilies(name)
+	if err != nil {
+		return err
+	}
+
+	if len(cfs) > 0 {
+		db.cfMap = make([]uint8, 256*256)
+		// A table mapping takes precedence over a DB mapping
+		for _, dbLevel := range []bool{true, false} {
+			for i := 0; i < len(cfs); i++ {
+				for _, t := range cfs[i].Tables {
+					if dbLevel && t[1] < 0 {
+						for tableId := 0; tableId < 256; tableId++ {
+							db.cfMap[t[0]<<8|tableId] = uint8(i + 1)
+						}
+					} else if !dbLevel && t[1] >= 0 {
+						db.cfMap[t[0]<<8|t[1]] = uint8(i + 1)
+					}
+				}
+			}
+		}
 	}
 
 	db.rOpt = C.rocksdb_readoptions_create()
+	C.rocksdb_readoptions_set_total_order_seek(db.rOpt, 1)
 	db.wOpt = C.rocksdb_writeoptions_create()
 
-	return nil
+	return db.checkMapping()
 }
 
-func (db *DB) Put(rawKey, value []byte, wb *WriteBatch) error {
+const (
+	rateLimitRefillUs = 100000 // 100ms
+	rateLimitFairness = 10
+)
+
+// setColumnOptions sets the options which can differ in column families.
+func setColumnOptions(opt *C.rocksdb_options_t, o *Options) {
+	setPrefixExtractor(opt)
+	C.rocksdb_options_set_write_buffer_size(opt, C.size_t(o.WriteBufSize))
+	C.rocksdb_options_set_compression(opt, C.int(o.Compression))
+	if len(o.LevelCompression) > 0 {
+		var levels = make([]C.int, len(o.LevelCompression))
+		for i, c := range o.LevelCompression {
+			levels[i] = C.int(c)
+		}
+		C.rocksdb_options_set_compression_per_level(opt, &levels[0],
+			C.size_t(len(levels)))
+	}
+	if o.MaxWriteBufNum > 0 {
+		C.rocksdb_options_set_max_write_buffer_number(opt,
+			C.int(o.MaxWriteBufNum))
+	}
+	C.rocksdb_options_set_compaction_style(opt, C.int(o.CompactionStyle))
+	if o.NumLevels > 0 {
+		C.rocksdb_options_set_num_levels(opt, C.int(o.NumLevels))
+	}
+	if o.LevelBaseSize > 0 {
+		C.rocksdb_options_set_max_bytes_for_level_base(opt,
+			C.uint64_t(o.LevelBaseSize))
+	}
+	if o.LevelMultiplier > 0 {
+		C.rocksdb_options_set_max_bytes_for_level_multiplier(opt,
+			C.double(o.LevelMultiplier))
+	}
+	if o.TargetFileSize > 0 {
+		C.rocksdb_options_set_target_file_size_base(opt,
+			C.uint64_t(o.TargetFileSize))
+	}
+}
+
+// newBlockOptions creates the block based table options with a bloom filter.
+func newBlockOptions(cache *C.rocksdb_cache_t, blockSize, bloomBits int) (
+	*C.rocksdb_block_based_table_options_t, *C.rocksdb_filterpolicy_t) {
+	if bloomBits <= 0 {
+		bloomBits = 10
+	}
+	var block_options = C.rocksdb_block_based_options_create()
+	C.rocksdb_block_based_options_set_block_cache(block_options, cache)
+	if blockSize > 0 {
+		C.rocksdb_block_based_options_set_block_size(block_options,
+			C.size_t(blockSize))
+	}
+	var fp = C.rocksdb_filterpolicy_create_bloom(C.int(bloomBits))
+	C.rocksdb_block_based_options_set_filter_policy(block_options, fp)
+	return block_options, fp
+}
+
+// cfOf returns the column family of the raw key.
+func (db *DB) cfOf(rawKey []byte) *C.rocksdb_column_family_handle_t {
+	if db.cfMap == nil || len(rawKey) < 4 {
+		return db.cfs[0].handle
+	}
+	return db.cfs[db.cfMap[int(rawKey[2])<<8|int(rawKey[3])]].handle
+}
+
+func (db *DB) Put(rawKey, value []byte, wb WriteBatch) error {
 	var ck, cv *C.char
 	if len(rawKey) > 0 {
 		ck = (*C.char)(unsafe.Pointer(&rawKey[0]))
@@ -118,66 +355,230 @@ func (db *DB) Put(rawKey, value []byte, wb *WriteBatch) error {
 
 	if wb == nil {
 		var errStr *C.char
-		C.rocksdb_put(db.db, db.wOpt, ck, C.size_t(len(rawKey)), cv,
-			C.size_t(len(value)), &errStr)
+		C.rocksdb_put_cf(db.db, db.wOpt, db.cfOf(rawKey), ck,
+			C.size_t(len(rawKey)), cv, C.size_t(len(value)), &errStr)
 		if errStr != nil {
 			defer C.free(unsafe.Pointer(errStr))
 			return errors.New(C.GoString(errStr))
 		}
 	} else {
-		C.rocksdb_writebatch_put(wb.batch, ck, C.size_t(len(rawKey)), cv,
-			C.size_t(len(value)))
+		C.rocksdb_writebatch_put_cf(wb.(*rocksWriteBatch).batch,
+			db.cfOf(rawKey), ck, C.size_t(len(rawKey)), cv, C.size_t(len(value)))
 	}
 
 	return nil
 }
 
-func (db *DB) Get(opt *ReadOptions, rawKey []byte) ([]byte, error) {
+func (db *DB) Get(opt ReadOptions, rawKey []byte) ([]byte, error) {
+	pv, err := db.GetPinned(opt, rawKey)
+	if err != nil || !pv.Found() {
+		return nil, err
+	}
+	defer pv.Release()
+
+	var value = pv.Data()
+	return append(make([]byte, 0, len(value)), value...), nil
+}
+
+// Value pinned in RocksDB memory, such as the block cache.
+// It fits in an interface without allocation.
+type rocksPinnedValue struct {
+	ps *C.rocksdb_pinnableslice_t
+}
+
+// GetPinned gets the value without copying it into a malloc buffer.
+// Release the value as soon as possible, as it pins RocksDB memory.
+func (db *DB) GetPinned(opt ReadOptions, rawKey []byte) (PinnedValue, error) {
 	var ck = (*C.char)(unsafe.Pointer(&rawKey[0]))
 
-	var rOpt = db.rOpt
-	if opt != nil && opt.rOpt != nil {
-		rOpt = opt.rOpt
-	}
+	var rOpt = db.readOptions(opt)
 
 	var errStr *C.char
-	var vallen C.size_t
-	var cv = C.rocksdb_get(db.db, rOpt, ck, C.size_t(len(rawKey)), &vallen, &errStr)
-
-	var err error
+	var pv rocksPinnedValue
+	pv.ps = C.rocksdb_get_pinned_cf(db.db, rOpt, db.cfOf(rawKey), ck,
+		C.size_t(len(rawKey)), &errStr)
 	if errStr != nil {
 		defer C.free(unsafe.Pointer(errStr))
-		err = errors.New(C.GoString(errStr))
+		pv.Release()
+		return rocksPinnedValue{}, errors.New(C.GoString(errStr))
 	}
 
-	if cv != nil {
-		defer C.free(unsafe.Pointer(cv))
-		return C.GoBytes(unsafe.Pointer(cv), C.int(vallen)), err
+	return pv, nil
+}
+
+func (pv rocksPinnedValue) Found() bool {
+	return pv.ps != nil
+}
+
+func (pv rocksPinnedValue) Data() []byte {
+	if pv.ps == nil {
+		return nil
 	}
+	var valueLen C.size_t
+	var cv = C.rocksdb_pinnableslice_value(pv.ps, &valueLen)
+	return cBytes(cv, valueLen)
+}
 
-	return nil, err
+func (pv rocksPinnedValue) Release() {
+	if pv.ps != nil {
+		C.rocksdb_pinnableslice_destroy(pv.ps)
+	}
 }
 
-func (db *DB) Del(rawKey []byte, wb *WriteBatch) error {
+func (db *DB) Del(rawKey []byte, wb WriteBatch) error {
 	var ck = (*C.char)(unsafe.Pointer(&rawKey[0]))
 
 	if wb == nil {
 		var errStr *C.char
-		C.rocksdb_delete(db.db, db.wOpt, ck, C.size_t(len(rawKey)), &errStr)
+		C.rocksdb_delete_cf(db.db, db.wOpt, db.cfOf(rawKey), ck,
+			C.size_t(len(rawKey)), &errStr)
 		if errStr != nil {
 			defer C.free(unsafe.Pointer(errStr))
 			return errors.New(C.GoString(errStr))
 		}
 	} else {
-		C.rocksdb_writebatch_delete(wb.batch, ck, C.size_t(len(rawKey)))
+		C.rocksdb_writebatch_delete_cf(wb.(*rocksWriteBatch).batch,
+			db.cfOf(rawKey), ck, C.size_t(len(rawKey)))
+	}
+
+	return nil
+}
+
+// DeleteRange deletes all keys in [start, end) with a single range
+// tombstone in every column family, instead of one tombstone per key.
+func (db *DB) DeleteRange(start, end []byte, wb WriteBatch) error {
+	var cs = (*C.char)(unsafe.Pointer(&start[0]))
+	var ce = (*C.char)(unsafe.Pointer(&end[0]))
+
+	var b = wb
+	if b == nil {
+		b = db.NewWriteBatch()
+		defer b.Destroy()
 	}
 
+	for _, cf := range db.cfs {
+		C.rocksdb_writebatch_delete_range_cf(b.(*rocksWriteBatch).batch,
+			cf.handle, cs, C.size_t(len(start)), ce, C.size_t(len(end)))
+	}
+
+	if wb == nil {
+		return db.Commit(b)
+	}
 	return nil
 }
 
-func (db *DB) NewReadOptions(createSnapshot bool) *ReadOptions {
-	var opt = new(ReadOptions)
+// CompactRange compacts the keys in [start, end) of every column family,
+// so that the space of the deleted data is reclaimed.
+// nil start or end means unbounded.
+func (db *DB) CompactRange(start, end []byte) {
+	var cs, ce *C.char
+	if len(start) > 0 {
+		cs = (*C.char)(unsafe.Pointer(&start[0]))
+	}
+	if len(end) > 0 {
+		ce = (*C.char)(unsafe.Pointer(&end[0]))
+	}
+	for _, cf := range db.cfs {
+		C.rocksdb_compact_range_cf(db.db, cf.handle, cs, C.size_t(len(start)),
+			ce, C.size_t(len(end)))
+	}
+}
+
+// ApproximateSizes returns the approximate file system space used by the
+// keys in every range [starts[i], ends[i]) of all column families.
+// Data in memtables is excluded.
+func (db *DB) ApproximateSizes(starts, ends [][]byte) []uint64 {
+	var num = len(starts)
+	if num == 0 || num != len(ends) {
+		return nil
+	}
+
+	// All keys are copied to C memory, and so are the key pointers
+	var bufLen int
+	for i := 0; i < num; i++ {
+		bufLen += len(starts[i]) + len(ends[i])
+	}
+	var buf = C.malloc(C.size_t(bufLen + 1))
+	defer C.free(buf)
+	var ptrSize = C.size_t(unsafe.Sizeof((*C.char)(nil)))
+	var keys = C.malloc(ptrSize * C.size_t(2*num))
+	defer C.free(keys)
+
+	var cBuf = (*[1 << 30]byte)(buf)[: bufLen+1 : bufLen+1]
+	var cKeys = (*[1 << 27]*C.char)(keys)[: 2*num : 2*num]
+	var keyLens = make([]C.size_t, 2*num)
+	var pos int
+	for i := 0; i < num; i++ {
+		for j, key := range [][]byte{starts[i], ends[i]} {
+			copy(cBuf[pos:], key)
+			cKeys[j*num+i] = (*C.char)(unsafe.Pointer(&cBuf[pos]))
+			keyLens[j*num+i] = C.size_t(len(key))
+			pos += len(key)
+		}
+	}
+
+	var sizes = make([]C.uint64_t, num)
+	var res = make([]uint64, num)
+	for _, cf := range db.cfs {
+		C.rocksdb_approximate_sizes_cf(db.db, cf.handle, C.int(num),
+			&cKeys[0], &keyLens[0], &cKeys[num], &keyLens[num], &sizes[0])
+		for i := 0; i < num; i++ {
+			res[i] += uint64(sizes[i])
+		}
+	}
+	return res
+}
+
+// GetProperty returns the value of a RocksDB property of the default column
+// family, such as "rocksdb.stats". Return empty string if not supported.
+func (db *DB) GetProperty(name string) string {
+	return db.getProperty(db.cfs[0], name)
+}
+
+// GetIntProperty returns the sum of an integer property of all column
+// families, such as "rocksdb.estimate-num-keys".
+func (db *DB) GetIntProperty(name string) uint64 {
+	var sum uint64
+	for _, cf := range db.cfs {
+		v, _ := strconv.ParseUint(db.getProperty(cf, name), 10, 64)
+		sum += v
+	}
+	return sum
+}
+
+// GetCFProperty returns the property of every column family. Column families
+// without the property are skipped.
+func (db *DB) GetCFProperty(name string) ([]string, []string) {
+	var names, values []string
+	for _, cf := range db.cfs {
+		cname := C.CString(name)
+		var value = C.rocksdb_property_value_cf(db.db, cf.handle, cname)
+		C.free(unsafe.Pointer(cname))
+		if value != nil {
+			names = append(names, cf.name)
+			values = append(values, C.GoString(value))
+			C.free(unsafe.Pointer(value))
+		}
+	}
+	return names, values
+}
+
+func (db *DB) getProperty(cf *columnFamily, name string) string {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var value = C.rocksdb_property_value_cf(db.db, cf.handle, cname)
+	if value == nil {
+		return ""
+	}
+	defer C.free(unsafe.Pointer(value))
+	return C.GoString(value)
+}
+
+func (db *DB) NewReadOptions(createSnapshot bool) ReadOptions {
+	var opt = new(rocksReadOptions)
 	opt.rOpt = C.rocksdb_readoptions_create()
+	C.rocksdb_readoptions_set_total_order_seek(opt.rOpt, 1)
 	if createSnapshot {
 		opt.snap = C.rocksdb_create_snapshot(db.db)
 		C.rocksdb_readoptions_set_snapshot(opt.rOpt, opt.snap)
@@ -186,16 +587,71 @@ func (db *DB) NewReadOptions(createSnapshot bool) *ReadOptions {
 	return opt
 }
 
-func (opt *ReadOptions) SetFillCache(fillCache bool) {
+// readOptions returns the RocksDB read options, the default one if opt is nil.
+func (db *DB) readOptions(opt ReadOptions) *C.rocksdb_readoptions_t {
+	if o, ok := opt.(*rocksReadOptions); ok && o.rOpt != nil {
+		return o.rOpt
+	}
+	return db.rOpt
+}
+
+func (opt *rocksReadOptions) SetFillCache(fillCache bool) {
 	C.rocksdb_readoptions_set_fill_cache(opt.rOpt, boolToUchar(fillCache))
 }
 
-func (opt *ReadOptions) Destroy() {
+// Prefix mode uses the prefix bloom filters, see setPrefixExtractor.
+func (opt *rocksReadOptions) SetPrefixSameAsStart(prefixSameAsStart bool) {
+	C.rocksdb_readoptions_set_prefix_same_as_start(opt.rOpt,
+		boolToUchar(prefixSameAsStart))
+	C.rocksdb_readoptions_set_total_order_seek(opt.rOpt,
+		boolToUchar(!prefixSameAsStart))
+}
+
+func (opt *rocksReadOptions) SetUpperBound(key []byte) {
+	setBound(&opt.upper, &opt.upperCap, key)
+	C.rocksdb_readoptions_set_iterate_upper_bound(opt.rOpt,
+		(*C.char)(opt.upper), C.size_t(len(key)))
+}
+
+func (opt *rocksReadOptions) SetLowerBound(key []byte) {
+	setBound(&opt.lower, &opt.lowerCap, key)
+	C.rocksdb_readoptions_set_iterate_lower_bound(opt.rOpt,
+		(*C.char)(opt.lower), C.size_t(len(key)))
+}
+
+// Copy the bound into C memory, RocksDB keeps the pointer. The buffer is
+// reused if large enough.
+func setBound(buf *unsafe.Pointer, bufCap *int, key []byte) {
+	if *bufCap < len(key) || *buf == nil {
+		var n = len(key)
+		if n < minBoundCap {
+			n = minBoundCap
+		}
+		var old = *buf
+		*buf = C.malloc(C.size_t(n))
+		*bufCap = n
+		if old != nil {
+			C.free(old)
+		}
+	}
+	copy((*[1 << 30]byte)(*buf)[:len(key):len(key)], key)
+}
+
+func (opt *rocksReadOptions) Destroy() {
 	if opt.rOpt != nil {
 		C.rocksdb_readoptions_destroy(opt.rOpt)
 		opt.rOpt = nil
 	}
 
+	if opt.upper != nil {
+		C.free(opt.upper)
+		opt.upper = nil
+	}
+	if opt.lower != nil {
+		C.free(opt.lower)
+		opt.lower = nil
+	}
+
 	if opt.snap != nil {
 		C.rocksdb_release_snapshot(opt.db, opt.snap)
 		opt.snap = nil
@@ -203,15 +659,15 @@ func (opt *ReadOptions) Destroy() {
 	}
 }
 
-func (db *DB) NewWriteBatch() *WriteBatch {
-	return &WriteBatch{C.rocksdb_writebatch_create()}
+func (db *DB) NewWriteBatch() WriteBatch {
+	return &rocksWriteBatch{C.rocksdb_writebatch_create()}
 }
 
-func (db *DB) Commit(wb *WriteBatch) error {
-	if wb != nil && wb.batch != nil {
+func (db *DB) Commit(wb WriteBatch) error {
+	if b, ok := wb.(*rocksWriteBatch); ok && b.batch != nil {
 		var errStr *C.char
-		C.rocksdb_write(db.db, db.wOpt, wb.batch, &errStr)
-		C.rocksdb_writebatch_clear(wb.batch)
+		C.rocksdb_write(db.db, db.wOpt, b.batch, &errStr)
+		C.rocksdb_writebatch_clear(b.batch)
 		if errStr != nil {
 			defer C.free(unsafe.Pointer(errStr))
 			return errors.New(C.GoString(errStr))
@@ -221,72 +677,230 @@ func (db *DB) Commit(wb *WriteBatch) error {
 	return nil
 }
 
-func (wb *WriteBatch) Destroy() {
+func (wb *rocksWriteBatch) Destroy() {
 	if wb.batch != nil {
 		C.rocksdb_writebatch_destroy(wb.batch)
 		wb.batch = nil
 	}
 }
 
-func (db *DB) NewIterator(opt *ReadOptions) *Iterator {
-	var rOpt = db.rOpt
-	if opt != nil && opt.rOpt != nil {
-		rOpt = opt.rOpt
+// Flush flushes the memtables of every column family, and waits until
+// they are written to SST files.
+func (db *DB) Flush() error {
+	var fOpt = C.rocksdb_flushoptions_create()
+	defer C.rocksdb_flushoptions_destroy(fOpt)
+	C.rocksdb_flushoptions_set_wait(fOpt, 1)
+
+	for _, cf := range db.cfs {
+		var errStr *C.char
+		C.rocksdb_flush_cf(db.db, fOpt, cf.handle, &errStr)
+		if errStr != nil {
+			defer C.free(unsafe.Pointer(errStr))
+			return errors.New(C.GoString(errStr))
+		}
 	}
 
-	var iter = new(Iterator)
-	iter.it = C.rocksdb_create_iterator(db.db, rOpt)
+	return nil
+}
+
+// Checkpoint creates an openable snapshot of the DB in dir, which must not
+// exist. SST files are hard-linked if dir is on the same file system.
+// The memtable is always flushed, so no WAL is needed for the snapshot.
+func (db *DB) Checkpoint(dir string) error {
+	var errStr *C.char
+	var cp = C.rocksdb_checkpoint_object_create(db.db, &errStr)
+	if errStr != nil {
+		defer C.free(unsafe.Pointer(errStr))
+		return errors.New(C.GoString(errStr))
+	}
+	defer C.rocksdb_checkpoint_object_destroy(cp)
+
+	cdir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cdir))
+
+	C.rocksdb_checkpoint_create(cp, cdir, 0, &errStr)
+	if errStr != nil {
+		defer C.free(unsafe.Pointer(errStr))
+		return errors.New(C.GoString(errStr))
+	}
+
+	return writeCFMapping(dir, db.cfMapping())
+}
+
+func (db *DB) NewIterator(opt ReadOptions) Iterator {
+	var rOpt = db.readOptions(opt)
+
+	var iter = new(rocksIterator)
+	iter.its = make([]*C.rocksdb_iterator_t, len(db.cfs))
+	for i, cf := range db.cfs {
+		iter.its[i] = C.rocksdb_create_iterator_cf(db.db, rOpt, cf.handle)
+	}
+	iter.cur = -1
 
 	return iter
 }
 
-func (iter *Iterator) Destroy() {
-	if iter.it != nil {
-		C.rocksdb_iter_destroy(iter.it)
-		iter.it = nil
+func (iter *rocksIterator) Destroy() {
+	for _, it := range iter.its {
+		C.rocksdb_iter_destroy(it)
 	}
+	iter.its = nil
+	iter.cur = -1
 }
 
-func (iter *Iterator) SeekToFirst() {
-	C.rocksdb_iter_seek_to_first(iter.it)
+func (iter *rocksIterator) SeekToFirst() {
+	for _, it := range iter.its {
+		C.rocksdb_iter_seek_to_first(it)
+	}
+	iter.fwd = true
+	iter.pick()
 }
 
-func (iter *Iterator) SeekToLast() {
-	C.rocksdb_iter_seek_to_last(iter.it)
+func (iter *rocksIterator) SeekToLast() {
+	for _, it := range iter.its {
+		C.rocksdb_iter_seek_to_last(it)
+	}
+	iter.fwd = false
+	iter.pick()
 }
 
-func (iter *Iterator) Seek(key []byte) {
+func (iter *rocksIterator) Seek(key []byte) {
 	var ck *C.char
 	if len(key) > 0 {
 		ck = (*C.char)(unsafe.Pointer(&key[0]))
 	}
-	C.rocksdb_iter_seek(iter.it, ck, C.size_t(len(key)))
+	for _, it := range iter.its {
+		C.rocksdb_iter_seek(it, ck, C.size_t(len(key)))
+	}
+	iter.fwd = true
+	iter.pick()
 }
 
-func (iter *Iterator) Next() {
-	C.rocksdb_iter_next(iter.it)
+func (iter *rocksIterator) Next() {
+	if !iter.fwd && len(iter.its) > 1 {
+		// Move the other iterators after the current key
+		var key = iter.Key()
+		var ck = (*C.char)(unsafe.Pointer(&key[0]))
+		for i, it := range iter.its {
+			if i != iter.cur {
+				C.rocksdb_iter_seek(it, ck, C.size_t(len(key)))
+			}
+		}
+		iter.fwd = true
+	}
+	C.rocksdb_iter_next(iter.its[iter.cur])
+	iter.pick()
 }
 
-func (iter *Iterator) Prev() {
-	C.rocksdb_iter_prev(iter.it)
+func (iter *rocksIterator) Prev() {
+	if iter.fwd && len(iter.its) > 1 {
+		// Move the other iterators before the current key
+		var key = iter.Key()
+		var ck = (*C.char)(unsafe.Pointer(&key[0]))
+		for i, it := range iter.its {
+			if i != iter.cur {
+				C.rocksdb_iter_seek(it, ck, C.size_t(len(key)))
+				if C.rocksdb_iter_valid(it) != 0 {
+					C.rocksdb_iter_prev(it)
+				} else {
+					C.rocksdb_iter_seek_to_last(it)
+				}
+			}
+		}
+		iter.fwd = false
+	}
+	C.rocksdb_iter_prev(iter.its[iter.cur])
+	iter.pick()
 }
 
-func (iter *Iterator) Valid() bool {
-	return C.rocksdb_iter_valid(iter.it) != 0
+// pick the iterator with the smallest key if moving forward,
+// or the largest key if moving backward.
+func (iter *rocksIterator) pick() {
+	iter.cur = -1
+	var curKey []byte
+	for i, it := range iter.its {
+		if C.rocksdb_iter_valid(it) == 0 {
+			continue
+		}
+		if len(iter.its) == 1 {
+			iter.cur = i
+			return
+		}
+
+		var key = iterKey(it)
+		if iter.cur < 0 {
+			iter.cur, curKey = i, key
+			continue
+		}
+		var cmp = bytes.Compare(key, curKey)
+		if (iter.fwd && cmp < 0) || (!iter.fwd && cmp > 0) {
+			iter.cur, curKey = i, key
+		}
+	}
+}
+
+func (iter *rocksIterator) Valid() bool {
+	return iter.cur >= 0 && C.rocksdb_iter_valid(iter.its[iter.cur]) != 0
 }
 
-func (iter *Iterator) Key() []byte {
+func (iter *rocksIterator) Key() []byte {
 	var keyLen C.size_t
-	var ck = C.rocksdb_iter_key(iter.it, &keyLen)
+	var ck = C.rocksdb_iter_key(iter.its[iter.cur], &keyLen)
 	return C.GoBytes(unsafe.Pointer(ck), C.int(keyLen))
 }
 
-func (iter *Iterator) Value() []byte {
+func (iter *rocksIterator) Value() []byte {
 	var valueLen C.size_t
-	var value = C.rocksdb_iter_value(iter.it, &valueLen)
+	var value = C.rocksdb_iter_value(iter.its[iter.cur], &valueLen)
 	return C.GoBytes(unsafe.Pointer(value), C.int(valueLen))
 }
 
+// KeyView returns the key in C memory without copy, only valid before the
+// iterator moves.
+func (iter *rocksIterator) KeyView() []byte {
+	return iterKey(iter.its[iter.cur])
+}
+
+// ValueView returns the value in C memory without copy, only valid before
+// the iterator moves.
+func (iter *rocksIterator) ValueView() []byte {
+	var valueLen C.size_t
+	var value = C.rocksdb_iter_value(iter.its[iter.cur], &valueLen)
+	return cBytes(value, valueLen)
+}
+
+// Refresh makes the iterator see the latest data. The iterator should be
+// seeked again after it.
+func (iter *rocksIterator) Refresh() error {
+	iter.cur = -1
+	for _, it := range iter.its {
+		var errStr *C.char
+		C.rocksdb_iter_refresh(it, &errStr)
+		if errStr != nil {
+			defer C.free(unsafe.Pointer(errStr))
+			return errors.New(C.GoString(errStr))
+		}
+	}
+	return nil
+}
+
+// iterKey returns the key in C memory without copy, only valid before the
+// iterator moves.
+func iterKey(it *C.rocksdb_iterator_t) []byte {
+	var keyLen C.size_t
+	var ck = C.rocksdb_iter_key(it, &keyLen)
+	return cBytes(ck, keyLen)
+}
+
+// cBytes makes a slice of C memory without copy. Return an empty slice if
+// length is 0.
+func cBytes(p *C.char, length C.size_t) []byte {
+	if length == 0 {
+		return []byte{}
+	}
+	return (*[1 << 30]byte)(unsafe.Pointer(p))[:length:length]
+}
+
 func boolToUchar(b bool) C.uchar {
 	if b {
 		return 1
@@ -0,0 +1,151 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"github.com/stevejiang/gotable/config"
+)
+
+// Storage engine names of the engine option in [database] config
+const (
+	EngineRocksDB = "rocksdb" // Default, only built with cgo
+	EngineMemory  = "memory"  // Pure Go, data is lost when closed
+)
+
+// Engine is the ordered key/value storage of a Table. Keys are sorted in
+// bytewise order. A nil WriteBatch writes at once, and nil ReadOptions reads
+// with the default options.
+type Engine interface {
+	Close()
+
+	Get(opt ReadOptions, rawKey []byte) ([]byte, error)
+	// GetPinned gets the value without copy. Release it as soon as possible.
+	GetPinned(opt ReadOptions, rawKey []byte) (PinnedValue, error)
+	Put(rawKey, value []byte, wb WriteBatch) error
+	Del(rawKey []byte, wb WriteBatch) error
+	// DeleteRange deletes all keys in [start, end).
+	DeleteRange(start, end []byte, wb WriteBatch) error
+
+	NewWriteBatch() WriteBatch
+	// Commit writes the batch atomically, and clears it for reuse.
+	Commit(wb WriteBatch) error
+
+	// NewReadOptions creates read options, which read from a consistent
+	// snapshot of this moment if createSnapshot is true.
+	NewReadOptions(createSnapshot bool) ReadOptions
+	NewIterator(opt ReadOptions) Iterator
+
+	// CompactRange reclaims the space of deleted keys in [start, end).
+	// nil start or end means unbounded.
+	CompactRange(start, end []byte)
+	// ApproximateSizes returns the approximate space used by the keys in
+	// every range [starts[i], ends[i]).
+	ApproximateSizes(starts, ends [][]byte) []uint64
+	// GetIntProperty returns the sum of an integer property, such as
+	// "rocksdb.estimate-num-keys", 0 if not supported.
+	GetIntProperty(name string) uint64
+	// GetCFProperty returns the names of column families with the property,
+	// and their values.
+	GetCFProperty(name string) ([]string, []string)
+	// Flush writes the data in memory to disk, and waits until finished.
+	Flush() error
+	// Checkpoint creates an openable copy of the data in dir.
+	Checkpoint(dir string) error
+}
+
+// PinnedValue is a value read without copy.
+type PinnedValue interface {
+	// Found returns false if the key does not exist.
+	Found() bool
+	// Data returns the value, only valid before Release.
+	Data() []byte
+	Release()
+}
+
+type WriteBatch interface {
+	Destroy()
+}
+
+type ReadOptions interface {
+	SetFillCache(fillCache bool)
+	// SetPrefixSameAsStart makes iterators only see keys with the same
+	// prefix (see rowPrefix) as the seek key. Seek keys must have the whole
+	// prefix, and SeekToFirst/SeekToLast are not supported.
+	// Iterators seek in total order by default.
+	SetPrefixSameAsStart(prefixSameAsStart bool)
+	// SetUpperBound sets the exclusive upper bound of iterators. It can be
+	// changed for iterators created with a bound before the next seek.
+	SetUpperBound(key []byte)
+	// SetLowerBound sets the inclusive lower bound of iterators. It can be
+	// changed for iterators created with a bound before the next seek.
+	SetLowerBound(key []byte)
+	// Destroy releases the snapshot. Iterators created with the options
+	// should be destroyed first.
+	Destroy()
+}
+
+// Iterator sees a consistent view of the data when created or refreshed.
+type Iterator interface {
+	Destroy()
+	SeekToFirst()
+	SeekToLast()
+	Seek(key []byte)
+	Next()
+	Prev()
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	// KeyView returns the key without copy, only valid before the iterator
+	// moves.
+	KeyView() []byte
+	// ValueView returns the value without copy, only valid before the
+	// iterator moves.
+	ValueView() []byte
+	// Refresh makes the iterator see the latest data. The iterator should
+	// be seeked again after it.
+	Refresh() error
+}
+
+type openEngineFunc func(dir string, maxOpenFiles int,
+	conf *config.Config) (Engine, error)
+
+var engines = make(map[string]openEngineFunc)
+
+// registerEngine is called in init of the engine files, engines built
+// with cgo are not available without cgo.
+func registerEngine(name string, open openEngineFunc) {
+	engines[name] = open
+}
+
+// HasEngine returns true if the storage engine is built in.
+func HasEngine(name string) bool {
+	_, ok := engines[name]
+	return ok
+}
+
+func openEngine(dir string, maxOpenFiles int,
+	conf *config.Config) (Engine, error) {
+	var name = conf.Db.Engine
+	if len(name) == 0 {
+		name = EngineRocksDB
+	}
+
+	open, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("storage engine %s is not built in", name)
+	}
+	return open(dir, maxOpenFiles, conf)
+}
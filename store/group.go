@@ -0,0 +1,360 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/stevejiang/gotable/api/go/table"
+	"github.com/stevejiang/gotable/api/go/table/proto"
+	"log"
+	"sort"
+)
+
+// GroupWrite is a SET, DEL, MSET or MDEL request prepared for group commit.
+// The locks of its keys are held from prepare until Done, so the writes of
+// a key are committed (and logged by the server) in the order prepared.
+type GroupWrite struct {
+	tl      *TableLock
+	isMulti bool
+	failed  bool // Nothing written, and not to be logged
+	one     proto.PkgOneOp
+	multi   proto.PkgMultiOp
+	kvs     []*proto.KeyValue // KVs to be written
+	ops     []groupOp         // Raw writes of the KVs
+	locks   []int             // Sorted indexes of the held UnitLocks
+}
+
+type groupOp struct {
+	del    bool
+	rawKey []byte
+	value  []byte // Raw value to put
+}
+
+// PrepareWrite decodes and checks a SET, DEL, MSET or MDEL request, and
+// locks its keys for CommitGroup. Zop requests are not grouped unless
+// atomic, they are written at once and Pending returns false.
+func (tbl *Table) PrepareWrite(req *PkgArgs, au Authorize,
+	wa *WriteAccess) *GroupWrite {
+	switch req.Cmd {
+	case proto.CmdSet:
+		return tbl.prepareWrite(req, au, wa, false, false)
+	case proto.CmdMSet:
+		return tbl.prepareWrite(req, au, wa, true, false)
+	case proto.CmdDel:
+		return tbl.prepareWrite(req, au, wa, false, true)
+	case proto.CmdMDel:
+		return tbl.prepareWrite(req, au, wa, true, true)
+	}
+	return nil
+}
+
+func (tbl *Table) prepareWrite(req *PkgArgs, au Authorize, wa *WriteAccess,
+	multi, del bool) *GroupWrite {
+	var gw = &GroupWrite{tl: tbl.tl, isMulti: multi}
+	var dbId uint8
+	var zop, atomic bool
+	if multi {
+		if !checkMultiOp(&gw.multi, req, au) {
+			return gw
+		}
+		dbId = gw.multi.DbId
+		zop = (gw.multi.PkgFlag&proto.FlagZop != 0)
+		atomic = (gw.multi.PkgFlag&proto.FlagAtomic != 0)
+	} else {
+		if !checkOneOp(&gw.one, req, au) {
+			return gw
+		}
+		dbId = gw.one.DbId
+		zop = (gw.one.PkgFlag&proto.FlagZop != 0)
+	}
+
+	if zop && !atomic {
+		tbl.writeZop(gw, del, dbId, wa)
+		return gw
+	}
+
+	var rawColSpace uint8 = proto.ColSpaceDefault
+	if zop {
+		rawColSpace = proto.ColSpaceScore2
+	}
+
+	var rawKeys = make([][]byte, 0, gw.kvNum())
+	var locks = make([]int, 0, gw.kvNum())
+	for i := 0; i < gw.kvNum(); i++ {
+		var kv = gw.kv(i)
+		kv.CtrlFlag &^= 0xFF // Clear all ctrl flags
+
+		var errCode = checkWriteKV(kv, del, dbId, wa)
+		if errCode != table.EcOk {
+			kv.SetErrCode(errCode)
+			if atomic {
+				gw.abort()
+				return gw
+			}
+			continue
+		}
+
+		var rawKey = getRawKey(dbId, kv.TableId, rawColSpace,
+			kv.RowKey, kv.ColKey)
+		gw.kvs = append(gw.kvs, kv)
+		rawKeys = append(rawKeys, rawKey)
+		locks = append(locks, lockIndex(rawKey))
+	}
+
+	gw.lockKeys(locks)
+
+	// CAS is checked in the order of the KVs, with all locks held.
+	// An atomic write clears CAS only if all KVs pass.
+	var n = 0
+	for i, kv := range gw.kvs {
+		var lck = tbl.tl.GetLock(rawKeys[i])
+		if !wa.replication && kv.Cas != 0 {
+			if lck.GetCas(rawKeys[i]) != kv.Cas {
+				kv.SetErrCode(table.EcCasNotMatch)
+				if atomic {
+					gw.abort()
+					return gw
+				}
+				continue
+			}
+		}
+		if !atomic {
+			lck.ClearCas(rawKeys[i])
+		}
+
+		gw.kvs[n] = kv
+		rawKeys[n] = rawKeys[i]
+		n++
+	}
+	gw.kvs = gw.kvs[:n]
+	rawKeys = rawKeys[:n]
+
+	var written map[string][]byte // Zop raw values written by former KVs
+	for i, kv := range gw.kvs {
+		if !zop {
+			var op = groupOp{del: del, rawKey: rawKeys[i]}
+			if !del {
+				op.value = getRawValue(kv.Value, kv.Score)
+			}
+			gw.ops = append(gw.ops, op)
+		} else {
+			if written == nil {
+				written = make(map[string][]byte)
+			}
+			err := tbl.addZopOps(gw, dbId, kv, rawKeys[i], del, written)
+			if err != nil {
+				log.Printf("Read zop KV failed: %s\n", err)
+				kv.SetErrCode(table.EcReadFail)
+				gw.abort()
+				return gw
+			}
+		}
+
+		kv.SetValue(nil)
+		kv.SetScore(0)
+	}
+
+	if atomic {
+		for _, rawKey := range rawKeys {
+			tbl.tl.GetLock(rawKey).ClearCas(rawKey)
+		}
+	}
+
+	if len(gw.ops) == 0 {
+		gw.Done()
+	}
+
+	return gw
+}
+
+// checkWriteKV checks the KV of a SET or DEL before locking.
+func checkWriteKV(kv *proto.KeyValue, del bool, dbId uint8,
+	wa *WriteAccess) int8 {
+	if len(kv.RowKey) == 0 {
+		return table.EcInvRowKey
+	}
+	if !del && len(kv.Value) > proto.MaxValueLen {
+		return table.EcInvValue
+	}
+	if !wa.CheckKey(dbId, kv.TableId, kv.RowKey) {
+		return wa.KeyErrCode(dbId, kv.TableId, kv.RowKey)
+	}
+	return table.EcOk
+}
+
+// addZopOps adds the raw writes of a zop KV and its score key. The old value
+// is read from written if a former KV of the request has written the key.
+func (tbl *Table) addZopOps(gw *GroupWrite, dbId uint8, kv *proto.KeyValue,
+	rawKey []byte, del bool, written map[string][]byte) error {
+	oldVal, ok := written[string(rawKey)]
+	if !ok {
+		var err error
+		oldVal, err = tbl.db.Get(nil, rawKey)
+		if err != nil {
+			return err
+		}
+	}
+	if oldVal != nil {
+		// Key exists
+		_, oldScore := parseRawValue(oldVal)
+		var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
+			kv.RowKey, newScoreColKey(oldScore, kv.ColKey))
+		gw.ops = append(gw.ops, groupOp{del: true, rawKey: scoreKey})
+	}
+
+	if del {
+		gw.ops = append(gw.ops, groupOp{del: true, rawKey: rawKey})
+		written[string(rawKey)] = nil
+		return nil
+	}
+
+	var value = getRawValue(kv.Value, kv.Score)
+	gw.ops = append(gw.ops, groupOp{rawKey: rawKey, value: value})
+
+	var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
+		kv.RowKey, newScoreColKey(kv.Score, kv.ColKey))
+	gw.ops = append(gw.ops, groupOp{rawKey: scoreKey,
+		value: getRawValue(kv.Value, 0)})
+	written[string(rawKey)] = value
+	return nil
+}
+
+func (tbl *Table) writeZop(gw *GroupWrite, del bool, dbId uint8,
+	wa *WriteAccess) {
+	var wb = tbl.db.NewWriteBatch()
+	defer wb.Destroy()
+
+	tbl.rwMtx.RLock()
+	defer tbl.rwMtx.RUnlock()
+	for i := 0; i < gw.kvNum(); i++ {
+		if del {
+			err := tbl.delKV(wb, dbId, gw.kv(i), wa)
+			if err != nil {
+				log.Printf("delKV failed: %s\n", err)
+				break
+			}
+		} else {
+			err := tbl.setKV(wb, dbId, gw.kv(i), wa)
+			if err != nil {
+				log.Printf("setKV failed: %s\n", err)
+				break
+			}
+		}
+	}
+}
+
+// writeNow commits a prepared write alone.
+func (tbl *Table) writeNow(gw *GroupWrite) ([]byte, bool) {
+	if gw.Pending() {
+		tbl.CommitGroup([]*GroupWrite{gw})
+		gw.Done()
+	}
+	return gw.Reply()
+}
+
+// CommitGroup writes all prepared writes of the group with one RocksDB
+// write. If it fails, all KVs of the group fail with EcWriteFail.
+// The keys stay locked until Done.
+func (tbl *Table) CommitGroup(group []*GroupWrite) {
+	var wb = tbl.db.NewWriteBatch()
+	defer wb.Destroy()
+
+	for _, gw := range group {
+		for _, op := range gw.ops {
+			if op.del {
+				tbl.db.Del(op.rawKey, wb)
+			} else {
+				tbl.db.Put(op.rawKey, op.value, wb)
+			}
+		}
+	}
+
+	tbl.rwMtx.RLock()
+	err := tbl.db.Commit(wb)
+	tbl.rwMtx.RUnlock()
+	if err != nil {
+		log.Printf("Commit write group failed: %s\n", err)
+		for _, gw := range group {
+			for _, kv := range gw.kvs {
+				kv.SetErrCode(table.EcWriteFail)
+			}
+			gw.failed = true
+		}
+	}
+}
+
+// Pending returns true if the write should be committed by CommitGroup.
+func (gw *GroupWrite) Pending() bool {
+	return len(gw.ops) > 0
+}
+
+// Reply returns the response package, and true if the write succeeded and
+// should be logged.
+func (gw *GroupWrite) Reply() ([]byte, bool) {
+	if gw.isMulti {
+		return replyMulti(&gw.multi),
+			!gw.failed && table.EcOk == gw.multi.ErrCode
+	}
+	return replyHandle(&gw.one), !gw.failed && table.EcOk == gw.one.ErrCode
+}
+
+// Done releases the locks of the keys.
+func (gw *GroupWrite) Done() {
+	for i := len(gw.locks) - 1; i >= 0; i-- {
+		gw.tl.ul[gw.locks[i]].Unlock()
+	}
+	gw.locks = nil
+	gw.kvs = nil
+	gw.ops = nil
+}
+
+// abort fails all KVs of an atomic write. KVs without their own error fail
+// with EcAborted.
+func (gw *GroupWrite) abort() {
+	for i := 0; i < gw.kvNum(); i++ {
+		var kv = gw.kv(i)
+		if kv.ErrCode == table.EcOk {
+			kv.CtrlFlag &^= 0xFF // Clear all ctrl flags
+			kv.SetErrCode(table.EcAborted)
+		}
+	}
+	gw.failed = true
+	gw.Done()
+}
+
+// lockKeys locks the UnitLocks in index order to avoid deadlock between
+// multi-key writes.
+func (gw *GroupWrite) lockKeys(locks []int) {
+	sort.Ints(locks)
+	for _, idx := range locks {
+		if len(gw.locks) == 0 || idx != gw.locks[len(gw.locks)-1] {
+			gw.tl.ul[idx].Lock()
+			gw.locks = append(gw.locks, idx)
+		}
+	}
+}
+
+func (gw *GroupWrite) kvNum() int {
+	if gw.isMulti {
+		return len(gw.multi.Kvs)
+	}
+	return 1
+}
+
+func (gw *GroupWrite) kv(i int) *proto.KeyValue {
+	if gw.isMulti {
+		return &gw.multi.Kvs[i]
+	}
+	return &gw.one.KeyValue
+}
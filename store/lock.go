@@ -49,8 +49,11 @@ func NewTableLock() *TableLock {
 }
 
 func (tl *TableLock) GetLock(key []byte) *UnitLock {
-	var idx = crc32.Checksum(key, castagnoliTab) % dbLockUnitNum
-	return &tl.ul[idx]
+	return &tl.ul[lockIndex(key)]
+}
+
+func lockIndex(key []byte) int {
+	return int(crc32.Checksum(key, castagnoliTab) % dbLockUnitNum)
 }
 
 func (tl *TableLock) goRollDeamon() {
@@ -0,0 +1,521 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stevejiang/gotable/config"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+func init() {
+	registerEngine(EngineMemory, openMemDB)
+}
+
+// MemDB is the pure Go storage engine. Data is kept in a persistent treap:
+// a write copies the path to the changed node and never modifies nodes
+// already in the tree, so a snapshot is just the root of the moment.
+// Nothing is written to disk.
+type MemDB struct {
+	mtx  sync.RWMutex // protects root
+	root *memNode
+}
+
+type memNode struct {
+	key, value  []byte
+	prio        uint32 // Heap order of the treap, keeps it balanced
+	left, right *memNode
+}
+
+const (
+	memOpPut = iota
+	memOpDel
+	memOpDelRange
+)
+
+type memOp struct {
+	op    int
+	key   []byte
+	value []byte // Put value, or DeleteRange end
+}
+
+type memWriteBatch struct {
+	ops []memOp
+}
+
+type memReadOptions struct {
+	snap   bool     // Read from root instead of the latest data
+	root   *memNode // The snapshot
+	prefix bool     // Prefix same as start
+	upper  []byte
+	lower  []byte
+}
+
+type memIterator struct {
+	db     *MemDB
+	opt    *memReadOptions // Bounds can be changed before the next seek
+	root   *memNode
+	cur    *memNode // nil if not valid
+	prefix []byte   // Row prefix of the seek key in prefix mode
+}
+
+func openMemDB(dir string, maxOpenFiles int,
+	conf *config.Config) (Engine, error) {
+	log.Println("Open memory DB, data is lost when the server stops")
+	return NewMemDB(), nil
+}
+
+func NewMemDB() *MemDB {
+	return new(MemDB)
+}
+
+func (db *MemDB) Close() {
+	db.mtx.Lock()
+	db.root = nil
+	db.mtx.Unlock()
+}
+
+func (db *MemDB) latest() *memNode {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.root
+}
+
+func (db *MemDB) Get(opt ReadOptions, rawKey []byte) ([]byte, error) {
+	var root *memNode
+	if o, ok := opt.(*memReadOptions); ok && o.snap {
+		root = o.root
+	} else {
+		root = db.latest()
+	}
+
+	var n = memCeil(root, rawKey)
+	if n == nil || !bytes.Equal(n.key, rawKey) {
+		return nil, nil
+	}
+	return append([]byte{}, n.value...), nil
+}
+
+// Nodes are never changed, the value is pinned by the node.
+type memPinnedValue struct {
+	n *memNode
+}
+
+func (db *MemDB) GetPinned(opt ReadOptions, rawKey []byte) (PinnedValue, error) {
+	var root *memNode
+	if o, ok := opt.(*memReadOptions); ok && o.snap {
+		root = o.root
+	} else {
+		root = db.latest()
+	}
+
+	var n = memCeil(root, rawKey)
+	if n == nil || !bytes.Equal(n.key, rawKey) {
+		return memPinnedValue{}, nil
+	}
+	return memPinnedValue{n}, nil
+}
+
+func (pv memPinnedValue) Found() bool {
+	return pv.n != nil
+}
+
+func (pv memPinnedValue) Data() []byte {
+	if pv.n == nil {
+		return nil
+	}
+	return pv.n.value
+}
+
+func (pv memPinnedValue) Release() {
+}
+
+func (db *MemDB) Put(rawKey, value []byte, wb WriteBatch) error {
+	return db.write(memOp{memOpPut, append([]byte{}, rawKey...),
+		append([]byte{}, value...)}, wb)
+}
+
+func (db *MemDB) Del(rawKey []byte, wb WriteBatch) error {
+	return db.write(memOp{memOpDel, append([]byte{}, rawKey...), nil}, wb)
+}
+
+func (db *MemDB) DeleteRange(start, end []byte, wb WriteBatch) error {
+	return db.write(memOp{memOpDelRange, append([]byte{}, start...),
+		append([]byte{}, end...)}, wb)
+}
+
+func (db *MemDB) write(op memOp, wb WriteBatch) error {
+	if wb != nil {
+		var b = wb.(*memWriteBatch)
+		b.ops = append(b.ops, op)
+		return nil
+	}
+
+	db.mtx.Lock()
+	db.root = db.root.apply(op)
+	db.mtx.Unlock()
+	return nil
+}
+
+func (db *MemDB) NewWriteBatch() WriteBatch {
+	return new(memWriteBatch)
+}
+
+// Commit applies all writes of the batch with one root change, so readers
+// see all of them or none.
+func (db *MemDB) Commit(wb WriteBatch) error {
+	var b = wb.(*memWriteBatch)
+	db.mtx.Lock()
+	var root = db.root
+	for _, op := range b.ops {
+		root = root.apply(op)
+	}
+	db.root = root
+	db.mtx.Unlock()
+
+	b.ops = b.ops[:0]
+	return nil
+}
+
+func (wb *memWriteBatch) Destroy() {
+	wb.ops = nil
+}
+
+func (db *MemDB) NewReadOptions(createSnapshot bool) ReadOptions {
+	var opt = new(memReadOptions)
+	if createSnapshot {
+		opt.snap = true
+		opt.root = db.latest()
+	}
+	return opt
+}
+
+func (opt *memReadOptions) SetFillCache(fillCache bool) {
+}
+
+func (opt *memReadOptions) SetPrefixSameAsStart(prefixSameAsStart bool) {
+	opt.prefix = prefixSameAsStart
+}
+
+func (opt *memReadOptions) SetUpperBound(key []byte) {
+	opt.upper = append(opt.upper[:0], key...)
+}
+
+func (opt *memReadOptions) SetLowerBound(key []byte) {
+	opt.lower = append(opt.lower[:0], key...)
+}
+
+func (opt *memReadOptions) Destroy() {
+	opt.root = nil
+}
+
+func (db *MemDB) NewIterator(opt ReadOptions) Iterator {
+	var iter = &memIterator{db: db, opt: &memReadOptions{}}
+	if o, ok := opt.(*memReadOptions); ok {
+		iter.opt = o
+	}
+	if iter.opt.snap {
+		iter.root = iter.opt.root
+	} else {
+		iter.root = db.latest()
+	}
+	return iter
+}
+
+// CompactRange does nothing, deleted keys are released at once.
+func (db *MemDB) CompactRange(start, end []byte) {
+}
+
+// ApproximateSizes returns the exact sizes of keys and values.
+func (db *MemDB) ApproximateSizes(starts, ends [][]byte) []uint64 {
+	var root = db.latest()
+	var sizes = make([]uint64, len(starts))
+	for i := 0; i < len(starts); i++ {
+		var n = memCeil(root, starts[i])
+		for n != nil && bytes.Compare(n.key, ends[i]) < 0 {
+			sizes[i] += uint64(len(n.key) + len(n.value))
+			n = memHigher(root, n.key)
+		}
+	}
+	return sizes
+}
+
+// GetIntProperty supports "rocksdb.estimate-num-keys" only.
+func (db *MemDB) GetIntProperty(name string) uint64 {
+	if name != "rocksdb.estimate-num-keys" {
+		return 0
+	}
+	return db.latest().count()
+}
+
+// GetCFProperty returns nothing, the engine has no column families.
+func (db *MemDB) GetCFProperty(name string) ([]string, []string) {
+	return nil, nil
+}
+
+// Flush does nothing, all data is in memory.
+func (db *MemDB) Flush() error {
+	return nil
+}
+
+func (db *MemDB) Checkpoint(dir string) error {
+	return errors.New("checkpoint is not supported by memory engine")
+}
+
+func (iter *memIterator) Destroy() {
+	iter.root = nil
+	iter.cur = nil
+}
+
+func (iter *memIterator) SeekToFirst() {
+	iter.prefix = nil
+	if iter.opt.lower != nil {
+		iter.cur = memCeil(iter.root, iter.opt.lower)
+	} else {
+		iter.cur = iter.root.first()
+	}
+	iter.check()
+}
+
+func (iter *memIterator) SeekToLast() {
+	iter.prefix = nil
+	if iter.opt.upper != nil {
+		iter.cur = memLower(iter.root, iter.opt.upper)
+	} else {
+		iter.cur = iter.root.last()
+	}
+	iter.check()
+}
+
+func (iter *memIterator) Seek(key []byte) {
+	iter.prefix = nil
+	if iter.opt.prefix && len(key) >= 6 && len(key) >= 6+int(key[4]) {
+		iter.prefix = append([]byte{}, key[:6+int(key[4])]...)
+	}
+	if iter.opt.lower != nil && bytes.Compare(key, iter.opt.lower) < 0 {
+		key = iter.opt.lower
+	}
+	iter.cur = memCeil(iter.root, key)
+	iter.check()
+}
+
+func (iter *memIterator) Next() {
+	iter.cur = memHigher(iter.root, iter.cur.key)
+	iter.check()
+}
+
+func (iter *memIterator) Prev() {
+	iter.cur = memLower(iter.root, iter.cur.key)
+	iter.check()
+}
+
+// check invalidates the iterator if the current key is out of the bounds.
+func (iter *memIterator) check() {
+	if iter.cur == nil {
+		return
+	}
+	var key = iter.cur.key
+	if (iter.opt.upper != nil && bytes.Compare(key, iter.opt.upper) >= 0) ||
+		(iter.opt.lower != nil && bytes.Compare(key, iter.opt.lower) < 0) ||
+		(iter.prefix != nil && !bytes.HasPrefix(key, iter.prefix)) {
+		iter.cur = nil
+	}
+}
+
+func (iter *memIterator) Valid() bool {
+	return iter.cur != nil
+}
+
+func (iter *memIterator) Key() []byte {
+	return append([]byte{}, iter.cur.key...)
+}
+
+func (iter *memIterator) Value() []byte {
+	return append([]byte{}, iter.cur.value...)
+}
+
+// KeyView returns the key without copy. Nodes are never modified, so it is
+// valid even after the iterator moves.
+func (iter *memIterator) KeyView() []byte {
+	return iter.cur.key
+}
+
+func (iter *memIterator) ValueView() []byte {
+	return iter.cur.value
+}
+
+func (iter *memIterator) Refresh() error {
+	iter.root = iter.db.latest()
+	iter.cur = nil
+	return nil
+}
+
+// apply returns the new root after the write, n is not changed.
+func (n *memNode) apply(op memOp) *memNode {
+	switch op.op {
+	case memOpPut:
+		return n.insert(op.key, op.value, rand.Uint32())
+	case memOpDel:
+		return n.remove(op.key)
+	case memOpDelRange:
+		for d := memCeil(n, op.key); d != nil &&
+			bytes.Compare(d.key, op.value) < 0; d = memHigher(n, d.key) {
+			n = n.remove(d.key)
+		}
+	}
+	return n
+}
+
+func (n *memNode) insert(key, value []byte, prio uint32) *memNode {
+	if n == nil {
+		return &memNode{key: key, value: value, prio: prio}
+	}
+
+	var c = *n
+	switch cmp := bytes.Compare(key, n.key); {
+	case cmp == 0:
+		c.value = value
+	case cmp < 0:
+		c.left = n.left.insert(key, value, prio)
+		if c.left.prio > c.prio {
+			// Rotate right
+			var l = *c.left
+			c.left = l.right
+			l.right = &c
+			return &l
+		}
+	default:
+		c.right = n.right.insert(key, value, prio)
+		if c.right.prio > c.prio {
+			// Rotate left
+			var r = *c.right
+			c.right = r.left
+			r.left = &c
+			return &r
+		}
+	}
+	return &c
+}
+
+func (n *memNode) remove(key []byte) *memNode {
+	if n == nil {
+		return nil
+	}
+
+	switch cmp := bytes.Compare(key, n.key); {
+	case cmp == 0:
+		return memMerge(n.left, n.right)
+	case cmp < 0:
+		var left = n.left.remove(key)
+		if left == n.left {
+			return n // Not found
+		}
+		var c = *n
+		c.left = left
+		return &c
+	default:
+		var right = n.right.remove(key)
+		if right == n.right {
+			return n
+		}
+		var c = *n
+		c.right = right
+		return &c
+	}
+}
+
+// memMerge joins two treaps, all keys in a are less than keys in b.
+func memMerge(a, b *memNode) *memNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.prio > b.prio {
+		var c = *a
+		c.right = memMerge(a.right, b)
+		return &c
+	}
+	var c = *b
+	c.left = memMerge(a, b.left)
+	return &c
+}
+
+func (n *memNode) first() *memNode {
+	for n != nil && n.left != nil {
+		n = n.left
+	}
+	return n
+}
+
+func (n *memNode) last() *memNode {
+	for n != nil && n.right != nil {
+		n = n.right
+	}
+	return n
+}
+
+func (n *memNode) count() uint64 {
+	if n == nil {
+		return 0
+	}
+	return 1 + n.left.count() + n.right.count()
+}
+
+// memCeil finds the first node with key >= the key.
+func memCeil(n *memNode, key []byte) *memNode {
+	var found *memNode
+	for n != nil {
+		if bytes.Compare(n.key, key) >= 0 {
+			found = n
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return found
+}
+
+// memHigher finds the first node with key > the key.
+func memHigher(n *memNode, key []byte) *memNode {
+	var found *memNode
+	for n != nil {
+		if bytes.Compare(n.key, key) > 0 {
+			found = n
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return found
+}
+
+// memLower finds the last node with key < the key.
+func memLower(n *memNode, key []byte) *memNode {
+	var found *memNode
+	for n != nil {
+		if bytes.Compare(n.key, key) < 0 {
+			found = n
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return found
+}
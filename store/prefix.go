@@ -0,0 +1,67 @@
+// Copyright 2015 stevejiang. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+/*
+#include <rocksdb/c.h>
+#include <stdlib.h>
+
+// Prefix of raw key: wUnitId+cDbId+cTableId+cKeyLen+sRowKey+colSpace
+static size_t gotable_prefix_len(const char* key) {
+	return 6 + (unsigned char)key[4];
+}
+
+static char* gotable_prefix_transform(void* state, const char* key,
+		size_t length, size_t* dst_length) {
+	*dst_length = gotable_prefix_len(key);
+	return (char*)key;
+}
+
+static unsigned char gotable_prefix_in_domain(void* state, const char* key,
+		size_t length) {
+	return length >= 6 && length >= gotable_prefix_len(key);
+}
+
+static unsigned char gotable_prefix_in_range(void* state, const char* key,
+		size_t length) {
+	return 0;
+}
+
+static const char* gotable_prefix_name(void* state) {
+	return "gotable.RowColSpacePrefix";
+}
+
+static void gotable_prefix_destroy(void* state) {
+}
+
+static rocksdb_slicetransform_t* gotable_new_prefix_extractor() {
+	return rocksdb_slicetransform_create(NULL, gotable_prefix_destroy,
+		gotable_prefix_transform, gotable_prefix_in_domain,
+		gotable_prefix_in_range, gotable_prefix_name);
+}
+*/
+import "C"
+
+// Ratio of write buffer size used by memtable prefix bloom filter
+const memtablePrefixBloomRatio = 0.1
+
+// setPrefixExtractor extracts unitId+dbId+tableId+rowKey+colSpace as key
+// prefix, so that bloom filters can skip SST files and memtables without
+// the row when scanning columns of a row. The options own the extractor.
+func setPrefixExtractor(opt *C.rocksdb_options_t) {
+	C.rocksdb_options_set_prefix_extractor(opt, C.gotable_new_prefix_extractor())
+	C.rocksdb_options_set_memtable_prefix_bloom_size_ratio(opt,
+		memtablePrefixBloomRatio)
+}
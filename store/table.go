@@ -20,6 +20,7 @@ import (
 	"errors"
 	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/api/go/table/proto"
+	"github.com/stevejiang/gotable/config"
 	"github.com/stevejiang/gotable/ctrl"
 	"log"
 	"os"
@@ -32,18 +33,14 @@ const (
 
 // AdminDB keys, reserved tableId=0(no migration on this table)
 const (
+	KeyFullSyncStart  = "full-sync-start"
+	KeyFullSyncPos    = "full-sync-pos"
 	KeyFullSyncEnd    = "full-sync-end"
 	KeyIncrSyncEnd    = "incr-sync-end"
 	KeySyncLogMissing = "sync-log-missing"
-)
-
-const (
-	kNoCompression     = 0x0
-	kSnappyCompression = 0x1
-	kZlibCompression   = 0x2
-	kBZip2Compression  = 0x3
-	kLZ4Compression    = 0x4
-	kLZ4HCCompression  = 0x5
+	KeyMigrationEnd   = "migration-end"
+	KeyClusterMap     = "cluster-map"
+	KeyCatalog        = "catalog"
 )
 
 type PkgArgs struct {
@@ -62,7 +59,7 @@ type Authorize interface {
 }
 
 type Table struct {
-	db    *DB
+	db    Engine
 	tl    *TableLock
 	rwMtx sync.RWMutex // stop write to NewIterator
 
@@ -70,45 +67,29 @@ type Table struct {
 	authPwd []string
 }
 
-func NewTable(tableDir string, maxOpenFiles int,
-	writeBufSize int, cacheSize int64, compression string) *Table {
+func NewTable(tableDir string, maxOpenFiles int, conf *config.Config) *Table {
 	os.MkdirAll(tableDir, os.ModeDir|os.ModePerm)
 
-	var comp int = kNoCompression
-	switch compression {
-	case "no":
-		comp = kNoCompression
-	case "snappy":
-		comp = kSnappyCompression
-	case "zlib":
-		comp = kZlibCompression
-	case "bzip2":
-		comp = kBZip2Compression
-	case "lz4":
-		comp = kLZ4Compression
-	case "lz4hc":
-		comp = kLZ4HCCompression
-	default:
-		compression = "no"
-	}
-
-	tbl := new(Table)
-	tbl.tl = NewTableLock()
-
-	tbl.db = NewDB()
-	err := tbl.db.Open(tableDir, true, maxOpenFiles, writeBufSize, cacheSize, comp)
+	db, err := openEngine(tableDir, maxOpenFiles, conf)
 	if err != nil {
 		log.Println("Open DB failed: ", err)
 		return nil
 	}
 
-	log.Printf("Open DB with maxOpenFiles %d, writeBufSize %dMB, cacheSize %dMB, "+
-		"compression(%s, %d)\n",
-		maxOpenFiles, writeBufSize/1048576, cacheSize/1048576, compression, comp)
+	tbl := new(Table)
+	tbl.tl = NewTableLock()
+	tbl.db = db
 
 	return tbl
 }
 
+func (tbl *Table) Close() {
+	if tbl.db != nil {
+		tbl.db.Close()
+		tbl.db = nil
+	}
+}
+
 func (tbl *Table) GetRWMutex() *sync.RWMutex {
 	return &tbl.rwMtx
 }
@@ -171,13 +152,20 @@ func (tbl *Table) Auth(req *PkgArgs, au Authorize) []byte {
 	return replyHandle(&in)
 }
 
-func (tbl *Table) getKV(rOpt *ReadOptions, zop bool, dbId uint8,
-	kv *proto.KeyValue, wa *WriteAccess) error {
+// getKV reads the value without copy. kv.Value is only valid before the
+// returned value is released, which is nil if not read.
+func (tbl *Table) getKV(rOpt ReadOptions, zop bool, dbId uint8,
+	kv *proto.KeyValue, wa *WriteAccess) (PinnedValue, error) {
 	kv.CtrlFlag &^= 0xFF // Clear all ctrl flags
 
+	if wa.IsMoved(dbId, kv.TableId, kv.RowKey) {
+		kv.SetErrCode(table.EcMoved)
+		return nil, nil
+	}
+
 	if kv.Cas > 0 && !wa.CheckKey(dbId, kv.TableId, kv.RowKey) {
 		kv.SetErrCode(table.EcSlaverCas)
-		return nil
+		return nil, nil
 	}
 
 	var rawColSpace uint8 = proto.ColSpaceDefault
@@ -186,17 +174,16 @@ func (tbl *Table) getKV(rOpt *ReadOptions, zop bool, dbId uint8,
 	}
 	var rawKey = getRawKey(dbId, kv.TableId, rawColSpace, kv.RowKey, kv.ColKey)
 
-	var err error
-	kv.Value, err = tbl.db.Get(rOpt, rawKey)
+	pv, err := tbl.db.GetPinned(rOpt, rawKey)
 	if err != nil {
 		kv.SetErrCode(table.EcReadFail)
-		return err
-	} else if kv.Value == nil {
+		return nil, err
+	} else if !pv.Found() {
 		// Key not exist
 		kv.SetErrCode(table.EcNotExist)
 	} else {
 		// Key exists
-		kv.Value, kv.Score = parseRawValue(kv.Value)
+		kv.Value, kv.Score = parseRawValue(pv.Data())
 		if len(kv.Value) > 0 {
 			kv.CtrlFlag |= proto.CtrlValue
 		}
@@ -214,10 +201,12 @@ func (tbl *Table) getKV(rOpt *ReadOptions, zop bool, dbId uint8,
 		lck.Unlock()
 	}
 
-	return nil
+	return pv, nil
 }
 
-func (tbl *Table) setKV(wb *WriteBatch, zop bool, dbId uint8,
+// setKV writes a zop KV and its score key. Other KVs are written by
+// prepareWrite and CommitGroup.
+func (tbl *Table) setKV(wb WriteBatch, dbId uint8,
 	kv *proto.KeyValue, wa *WriteAccess) error {
 	kv.CtrlFlag &^= 0xFF // Clear all ctrl flags
 
@@ -230,16 +219,12 @@ func (tbl *Table) setKV(wb *WriteBatch, zop bool, dbId uint8,
 		return nil
 	}
 	if !wa.CheckKey(dbId, kv.TableId, kv.RowKey) {
-		kv.SetErrCode(table.EcWriteSlaver)
+		kv.SetErrCode(wa.KeyErrCode(dbId, kv.TableId, kv.RowKey))
 		return nil
 	}
 
-	var rawColSpace uint8 = proto.ColSpaceDefault
-	if zop {
-		rawColSpace = proto.ColSpaceScore2
-	}
-
-	var rawKey = getRawKey(dbId, kv.TableId, rawColSpace, kv.RowKey, kv.ColKey)
+	var rawKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore2,
+		kv.RowKey, kv.ColKey)
 	var lck = tbl.tl.GetLock(rawKey)
 	lck.Lock()
 	defer lck.Unlock()
@@ -252,44 +237,28 @@ func (tbl *Table) setKV(wb *WriteBatch, zop bool, dbId uint8,
 	}
 	lck.ClearCas(rawKey)
 
-	var err error
-	if zop {
-		if wb == nil {
-			wb = tbl.db.NewWriteBatch()
-			defer wb.Destroy()
-		}
-
-		var oldVal []byte
-		var oldScore int64
-		oldVal, err = tbl.db.Get(nil, rawKey)
-		if err != nil {
-			kv.SetErrCode(table.EcReadFail)
-			return err
-		} else if oldVal != nil {
-			// Key exists
-			oldVal, oldScore = parseRawValue(oldVal)
-			var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
-				kv.RowKey, newScoreColKey(oldScore, kv.ColKey))
-			tbl.db.Del(scoreKey, wb)
-		}
+	oldVal, err := tbl.db.Get(nil, rawKey)
+	if err != nil {
+		kv.SetErrCode(table.EcReadFail)
+		return err
+	} else if oldVal != nil {
+		// Key exists
+		_, oldScore := parseRawValue(oldVal)
+		var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
+			kv.RowKey, newScoreColKey(oldScore, kv.ColKey))
+		tbl.db.Del(scoreKey, wb)
+	}
 
-		tbl.db.Put(rawKey, getRawValue(kv.Value, kv.Score), wb)
+	tbl.db.Put(rawKey, getRawValue(kv.Value, kv.Score), wb)
 
-		var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
-			kv.RowKey, newScoreColKey(kv.Score, kv.ColKey))
-		tbl.db.Put(scoreKey, getRawValue(kv.Value, 0), wb)
+	var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
+		kv.RowKey, newScoreColKey(kv.Score, kv.ColKey))
+	tbl.db.Put(scoreKey, getRawValue(kv.Value, 0), wb)
 
-		err = tbl.db.Commit(wb)
-		if err != nil {
-			kv.SetErrCode(table.EcWriteFail)
-			return err
-		}
-	} else {
-		err = tbl.db.Put(rawKey, getRawValue(kv.Value, kv.Score), nil)
-		if err != nil {
-			kv.SetErrCode(table.EcWriteFail)
-			return err
-		}
+	err = tbl.db.Commit(wb)
+	if err != nil {
+		kv.SetErrCode(table.EcWriteFail)
+		return err
 	}
 
 	kv.SetValue(nil)
@@ -334,7 +303,8 @@ func (tbl *Table) setSyncKV(zop bool, dbId uint8, kv *proto.KeyValue) error {
 	return nil
 }
 
-func (tbl *Table) delKV(wb *WriteBatch, zop bool, dbId uint8,
+// delKV deletes a zop KV and its score key.
+func (tbl *Table) delKV(wb WriteBatch, dbId uint8,
 	kv *proto.KeyValue, wa *WriteAccess) error {
 	kv.CtrlFlag &^= 0xFF // Clear all ctrl flags
 
@@ -343,16 +313,12 @@ func (tbl *Table) delKV(wb *WriteBatch, zop bool, dbId uint8,
 		return nil
 	}
 	if !wa.CheckKey(dbId, kv.TableId, kv.RowKey) {
-		kv.SetErrCode(table.EcWriteSlaver)
+		kv.SetErrCode(wa.KeyErrCode(dbId, kv.TableId, kv.RowKey))
 		return nil
 	}
 
-	var rawColSpace uint8 = proto.ColSpaceDefault
-	if zop {
-		rawColSpace = proto.ColSpaceScore2
-	}
-
-	var rawKey = getRawKey(dbId, kv.TableId, rawColSpace, kv.RowKey, kv.ColKey)
+	var rawKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore2,
+		kv.RowKey, kv.ColKey)
 	var lck = tbl.tl.GetLock(rawKey)
 	lck.Lock()
 	defer lck.Unlock()
@@ -365,40 +331,24 @@ func (tbl *Table) delKV(wb *WriteBatch, zop bool, dbId uint8,
 	}
 	lck.ClearCas(rawKey)
 
-	var err error
-	if zop {
-		if wb == nil {
-			wb = tbl.db.NewWriteBatch()
-			defer wb.Destroy()
-		}
-
-		var oldVal []byte
-		var oldScore int64
-		oldVal, err = tbl.db.Get(nil, rawKey)
-		if err != nil {
-			kv.SetErrCode(table.EcReadFail)
-			return err
-		} else if oldVal != nil {
-			// Key exists
-			oldVal, oldScore = parseRawValue(oldVal)
-			var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
-				kv.RowKey, newScoreColKey(oldScore, kv.ColKey))
-			tbl.db.Del(scoreKey, wb)
-		}
+	oldVal, err := tbl.db.Get(nil, rawKey)
+	if err != nil {
+		kv.SetErrCode(table.EcReadFail)
+		return err
+	} else if oldVal != nil {
+		// Key exists
+		_, oldScore := parseRawValue(oldVal)
+		var scoreKey = getRawKey(dbId, kv.TableId, proto.ColSpaceScore1,
+			kv.RowKey, newScoreColKey(oldScore, kv.ColKey))
+		tbl.db.Del(scoreKey, wb)
+	}
 
-		tbl.db.Del(rawKey, wb)
+	tbl.db.Del(rawKey, wb)
 
-		err = tbl.db.Commit(wb)
-		if err != nil {
-			kv.SetErrCode(table.EcWriteFail)
-			return err
-		}
-	} else {
-		err = tbl.db.Del(rawKey, nil)
-		if err != nil {
-			kv.SetErrCode(table.EcWriteFail)
-			return err
-		}
+	err = tbl.db.Commit(wb)
+	if err != nil {
+		kv.SetErrCode(table.EcWriteFail)
+		return err
 	}
 
 	kv.SetValue(nil)
@@ -407,7 +357,7 @@ func (tbl *Table) delKV(wb *WriteBatch, zop bool, dbId uint8,
 	return nil
 }
 
-func (tbl *Table) incrKV(wb *WriteBatch, zop bool, dbId uint8,
+func (tbl *Table) incrKV(wb WriteBatch, zop bool, dbId uint8,
 	kv *proto.KeyValue, wa *WriteAccess) error {
 	kv.CtrlFlag &^= 0xFF // Clear all ctrl flags
 
@@ -416,7 +366,7 @@ func (tbl *Table) incrKV(wb *WriteBatch, zop bool, dbId uint8,
 		return nil
 	}
 	if !wa.CheckKey(dbId, kv.TableId, kv.RowKey) {
-		kv.SetErrCode(table.EcWriteSlaver)
+		kv.SetErrCode(wa.KeyErrCode(dbId, kv.TableId, kv.RowKey))
 		return nil
 	}
 
@@ -502,10 +452,13 @@ func (tbl *Table) Get(req *PkgArgs, au Authorize, wa *WriteAccess) []byte {
 	var in proto.PkgOneOp
 	if checkOneOp(&in, req, au) {
 		zop := (in.PkgFlag&proto.FlagZop != 0)
-		err := tbl.getKV(nil, zop, in.DbId, &in.KeyValue, wa)
+		pv, err := tbl.getKV(nil, zop, in.DbId, &in.KeyValue, wa)
 		if err != nil {
 			log.Printf("getKV failed: %s\n", err)
 		}
+		if pv != nil {
+			defer pv.Release() // After encoded
+		}
 	}
 
 	return replyHandle(&in)
@@ -517,12 +470,22 @@ func (tbl *Table) MGet(req *PkgArgs, au Authorize, wa *WriteAccess) []byte {
 		var rOpt = tbl.db.NewReadOptions(true)
 		defer rOpt.Destroy()
 		zop := (in.PkgFlag&proto.FlagZop != 0)
+		var pvs = make([]PinnedValue, 0, len(in.Kvs))
+		defer func() {
+			// After encoded
+			for _, pv := range pvs {
+				pv.Release()
+			}
+		}()
 		for i := 0; i < len(in.Kvs); i++ {
-			err := tbl.getKV(rOpt, zop, in.DbId, &in.Kvs[i], wa)
+			pv, err := tbl.getKV(rOpt, zop, in.DbId, &in.Kvs[i], wa)
 			if err != nil {
 				log.Printf("getKV failed: %s\n", err)
 				break
 			}
+			if pv != nil {
+				pvs = append(pvs, pv)
+			}
 		}
 	}
 
@@ -553,75 +516,19 @@ func (tbl *Table) Sync(req *PkgArgs) ([]byte, bool) {
 }
 
 func (tbl *Table) Set(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
-	var in proto.PkgOneOp
-	if checkOneOp(&in, req, au) {
-		zop := (in.PkgFlag&proto.FlagZop != 0)
-		tbl.rwMtx.RLock()
-		err := tbl.setKV(nil, zop, in.DbId, &in.KeyValue, wa)
-		tbl.rwMtx.RUnlock()
-
-		if err != nil {
-			log.Printf("setKV failed: %s\n", err)
-		}
-	}
-
-	return replyHandle(&in), table.EcOk == in.ErrCode
+	return tbl.writeNow(tbl.prepareWrite(req, au, wa, false, false))
 }
 
 func (tbl *Table) MSet(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
-	var in proto.PkgMultiOp
-	if checkMultiOp(&in, req, au) {
-		var wb = tbl.db.NewWriteBatch()
-		defer wb.Destroy()
-		zop := (in.PkgFlag&proto.FlagZop != 0)
-		tbl.rwMtx.RLock()
-		for i := 0; i < len(in.Kvs); i++ {
-			err := tbl.setKV(wb, zop, in.DbId, &in.Kvs[i], wa)
-			if err != nil {
-				log.Printf("setKV failed: %s\n", err)
-				break
-			}
-		}
-		tbl.rwMtx.RUnlock()
-	}
-
-	return replyMulti(&in), table.EcOk == in.ErrCode
+	return tbl.writeNow(tbl.prepareWrite(req, au, wa, true, false))
 }
 
 func (tbl *Table) Del(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
-	var in proto.PkgOneOp
-	if checkOneOp(&in, req, au) {
-		zop := (in.PkgFlag&proto.FlagZop != 0)
-		tbl.rwMtx.RLock()
-		err := tbl.delKV(nil, zop, in.DbId, &in.KeyValue, wa)
-		tbl.rwMtx.RUnlock()
-
-		if err != nil {
-			log.Printf("delKV failed: %s\n", err)
-		}
-	}
-
-	return replyHandle(&in), table.EcOk == in.ErrCode
+	return tbl.writeNow(tbl.prepareWrite(req, au, wa, false, true))
 }
 
 func (tbl *Table) MDel(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
-	var in proto.PkgMultiOp
-	if checkMultiOp(&in, req, au) {
-		var wb = tbl.db.NewWriteBatch()
-		defer wb.Destroy()
-		zop := (in.PkgFlag&proto.FlagZop != 0)
-		tbl.rwMtx.RLock()
-		for i := 0; i < len(in.Kvs); i++ {
-			err := tbl.delKV(wb, zop, in.DbId, &in.Kvs[i], wa)
-			if err != nil {
-				log.Printf("delKV failed: %s\n", err)
-				break
-			}
-		}
-		tbl.rwMtx.RUnlock()
-	}
-
-	return replyMulti(&in), table.EcOk == in.ErrCode
+	return tbl.writeNow(tbl.prepareWrite(req, au, wa, true, true))
 }
 
 func (tbl *Table) Incr(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bool) {
@@ -660,7 +567,7 @@ func (tbl *Table) MIncr(req *PkgArgs, au Authorize, wa *WriteAccess) ([]byte, bo
 	return replyMulti(&in), table.EcOk == in.ErrCode
 }
 
-func iterMove(it *Iterator, asc bool) {
+func iterMove(it Iterator, asc bool) {
 	if asc {
 		it.Next()
 	} else {
@@ -668,13 +575,18 @@ func iterMove(it *Iterator, asc bool) {
 	}
 }
 
-func (tbl *Table) zScanSortScore(in *proto.PkgScanReq, out *proto.PkgScanResp) {
-	var it = tbl.db.NewIterator(nil)
-	defer it.Destroy()
-
+func (tbl *Table) zScanSortScore(in *proto.PkgScanReq, out *proto.PkgScanResp,
+	rc *ReadCache) []byte {
 	var scanAsc = (in.PkgFlag&proto.FlagScanAsc != 0)
 	var startSeek = (in.PkgFlag&proto.FlagScanKeyStart != 0)
 	var scanColSpace uint8 = proto.ColSpaceScore1
+	it, err := rc.rowIterator(rowPrefix(in.DbId, in.TableId, scanColSpace,
+		in.RowKey), scanAsc)
+	if err != nil {
+		log.Printf("Create iterator failed: %s\n", err)
+		return errorHandle(out, table.EcReadFail)
+	}
+
 	if scanAsc {
 		if startSeek {
 			// Seek to the first element
@@ -699,11 +611,12 @@ func (tbl *Table) zScanSortScore(in *proto.PkgScanReq, out *proto.PkgScanResp) {
 	}
 
 	out.PkgFlag |= proto.FlagScanEnd
+	var reply = newScanReply(out)
 	var first = true
 	var scanNum = int(in.Num)
 	var pkgLen = proto.HeadSize + 1000
 	for i := 0; it.Valid() && i < scanNum+1; iterMove(it, scanAsc) {
-		_, dbId, tableId, colSpace, rowKey, colKey := parseRawKey(it.Key())
+		_, dbId, tableId, colSpace, rowKey, colKey := parseRawKey(it.KeyView())
 		if dbId != in.DbId || tableId != in.TableId ||
 			colSpace != scanColSpace || bytes.Compare(rowKey, in.RowKey) != 0 {
 			if first {
@@ -745,7 +658,7 @@ func (tbl *Table) zScanSortScore(in *proto.PkgScanReq, out *proto.PkgScanResp) {
 			kv.TableId = in.TableId
 			kv.RowKey = in.RowKey
 			kv.ColKey = zColKey
-			kv.Value, _ = parseRawValue(it.Value())
+			kv.Value, _ = parseRawValue(it.ValueView())
 			kv.Score = zScore
 			if len(kv.Value) > 0 {
 				kv.CtrlFlag |= proto.CtrlValue
@@ -753,7 +666,7 @@ func (tbl *Table) zScanSortScore(in *proto.PkgScanReq, out *proto.PkgScanResp) {
 			if kv.Score != 0 {
 				kv.CtrlFlag |= proto.CtrlScore
 			}
-			out.Kvs = append(out.Kvs, kv)
+			reply.add(&kv)
 
 			pkgLen += kv.Length()
 			if pkgLen > proto.MaxPkgLen/2 {
@@ -766,9 +679,13 @@ func (tbl *Table) zScanSortScore(in *proto.PkgScanReq, out *proto.PkgScanResp) {
 		}
 		i++
 	}
+
+	return reply.finish()
 }
 
-func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
+// Scan columns of a row. Iterators are cached in rc if it is not nil.
+func (tbl *Table) Scan(req *PkgArgs, au Authorize, wa *WriteAccess,
+	rc *ReadCache) []byte {
 	var out proto.PkgScanResp
 	out.Cmd = req.Cmd
 	out.DbId = req.DbId
@@ -790,20 +707,32 @@ func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
 		return errorHandle(&out, table.EcNoPrivilege)
 	}
 
+	if wa.IsMoved(in.DbId, in.TableId, in.RowKey) {
+		return errorHandle(&out, table.EcMoved)
+	}
+
+	if rc == nil {
+		rc = tbl.NewReadCache()
+		defer rc.Destroy()
+	}
+
 	if in.ColSpace == proto.ColSpaceScore1 {
-		tbl.zScanSortScore(&in, &out)
-		return replyHandle(&out)
+		return tbl.zScanSortScore(&in, &out, rc)
 	}
 
 	var scanColSpace uint8 = proto.ColSpaceDefault
 	if in.ColSpace == proto.ColSpaceScore2 {
 		scanColSpace = proto.ColSpaceScore2
 	}
-	var it = tbl.db.NewIterator(nil)
-	defer it.Destroy()
-
 	var scanAsc = (in.PkgFlag&proto.FlagScanAsc != 0)
 	var startSeek = (in.PkgFlag&proto.FlagScanKeyStart != 0)
+	it, err := rc.rowIterator(rowPrefix(in.DbId, in.TableId, scanColSpace,
+		in.RowKey), scanAsc)
+	if err != nil {
+		log.Printf("Create iterator failed: %s\n", err)
+		return errorHandle(&out, table.EcReadFail)
+	}
+
 	if scanAsc {
 		if startSeek {
 			// Seek to the first element
@@ -826,11 +755,12 @@ func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
 	}
 
 	out.PkgFlag |= proto.FlagScanEnd
+	var reply = newScanReply(&out)
 	var first = true
 	var scanNum = int(in.Num)
 	var pkgLen = proto.HeadSize + 1000
 	for i := 0; it.Valid() && i < scanNum+1; iterMove(it, scanAsc) {
-		_, dbId, tableId, colSpace, rowKey, colKey := parseRawKey(it.Key())
+		_, dbId, tableId, colSpace, rowKey, colKey := parseRawKey(it.KeyView())
 		if dbId != in.DbId || tableId != in.TableId ||
 			colSpace != scanColSpace || bytes.Compare(rowKey, in.RowKey) != 0 {
 			if first {
@@ -862,7 +792,7 @@ func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
 			kv.TableId = in.TableId
 			kv.RowKey = in.RowKey
 			kv.ColKey = colKey
-			kv.Value, kv.Score = parseRawValue(it.Value())
+			kv.Value, kv.Score = parseRawValue(it.ValueView())
 			if len(kv.Value) > 0 {
 				kv.CtrlFlag |= proto.CtrlValue
 			}
@@ -870,7 +800,7 @@ func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
 				kv.CtrlFlag |= proto.CtrlScore
 			}
 
-			out.Kvs = append(out.Kvs, kv)
+			reply.add(&kv)
 
 			pkgLen += kv.Length()
 			if pkgLen > proto.MaxPkgLen/2 {
@@ -884,7 +814,7 @@ func (tbl *Table) Scan(req *PkgArgs, au Authorize) []byte {
 		i++
 	}
 
-	return replyHandle(&out)
+	return reply.finish()
 }
 
 func (tbl *Table) Dump(req *PkgArgs, au Authorize) []byte {
@@ -916,6 +846,9 @@ func (tbl *Table) Dump(req *PkgArgs, au Authorize) []byte {
 	var onlyOneTable = (out.PkgFlag&proto.FlagDumpTable != 0)
 	var rOpt = tbl.db.NewReadOptions(false)
 	rOpt.SetFillCache(false)
+	if in.EndUnitId < proto.MaxUint16 {
+		rOpt.SetUpperBound(getRawUnitKey(in.EndUnitId+1, 0, 0))
+	}
 	defer rOpt.Destroy()
 	var it = tbl.db.NewIterator(rOpt)
 	defer it.Destroy()
@@ -1038,57 +971,62 @@ func (tbl *Table) Dump(req *PkgArgs, au Authorize) []byte {
 	return pkg
 }
 
-func (tbl *Table) DeleteUnit(unitId uint16) error {
-	var rOpt = tbl.db.NewReadOptions(false)
-	rOpt.SetFillCache(false)
-	defer rOpt.Destroy()
+func (tbl *Table) DeleteUnit(unitId uint16, compact bool) error {
+	return tbl.DeleteUnitRange(unitId, unitId, compact)
+}
 
-	const maxDelNum = 1000000
-	var count, endTimes int
-	for {
-		var it = tbl.db.NewIterator(rOpt)
-		it.Seek(getRawUnitKey(unitId, 0, 0))
-		for count = 0; it.Valid() && count < maxDelNum; it.Next() {
-			curUnitId, dbId, tableId := parseRawKeyUnitId(it.Key())
-			if curUnitId != unitId {
-				break
-			}
-			if dbId == proto.AdminDbId && tableId == 0 {
-				continue // Reserved admin table
-			}
-			err := tbl.db.Del(it.Key(), nil)
-			if err != nil {
-				it.Destroy()
-				return err
-			}
-			count++
+// DeleteUnitRange deletes data of units [startUnitId, endUnitId] with range
+// deletion, the reserved admin table is kept. Compact the key range after
+// deleting if compact is true.
+func (tbl *Table) DeleteUnitRange(startUnitId, endUnitId uint16,
+	compact bool) error {
+	var wb = tbl.db.NewWriteBatch()
+	defer wb.Destroy()
+
+	for u := uint32(startUnitId); u <= uint32(endUnitId); u++ {
+		var unitId = uint16(u)
+		// [unitId, AdminDbId+0) and [AdminDbId+1, unitId+1)
+		var adminKey = getRawUnitKey(unitId, proto.AdminDbId, 0)
+		var err = tbl.db.DeleteRange(getRawUnitKey(unitId, 0, 0), adminKey, wb)
+		if err != nil {
+			return err
 		}
-		it.Destroy()
 
-		if count < maxDelNum {
-			endTimes++
-		}
-		if endTimes > 1 {
-			if count > 0 {
-				return errors.New("the unit deleting still has new data written")
-			}
-			return nil
+		err = tbl.db.DeleteRange(getRawUnitKey(unitId, proto.AdminDbId, 1),
+			getRawUnitKey(unitId+1, 0, 0), wb)
+		if err != nil {
+			return err
 		}
 	}
 
+	err := tbl.db.Commit(wb)
+	if err != nil {
+		return err
+	}
+
+	if compact {
+		tbl.CompactUnitRange(startUnitId, endUnitId)
+	}
+
 	return nil
 }
 
 func (tbl *Table) HasUnitData(unitId uint16) bool {
+	return tbl.HasUnitRangeData(unitId, unitId)
+}
+
+// HasUnitRangeData checks whether any of units [startUnitId, endUnitId]
+// has data.
+func (tbl *Table) HasUnitRangeData(startUnitId, endUnitId uint16) bool {
 	var rOpt = tbl.db.NewReadOptions(false)
 	rOpt.SetFillCache(false)
 	defer rOpt.Destroy()
 	var it = tbl.db.NewIterator(rOpt)
 	defer it.Destroy()
 
-	for it.Seek(getRawUnitKey(unitId, 0, 0)); it.Valid(); it.Next() {
+	for it.Seek(getRawUnitKey(startUnitId, 0, 0)); it.Valid(); it.Next() {
 		curUnitId, dbId, tableId := parseRawKeyUnitId(it.Key())
-		if curUnitId != unitId {
+		if curUnitId > endUnitId {
 			break
 		}
 		if dbId == proto.AdminDbId && tableId == 0 {
@@ -1099,7 +1037,239 @@ func (tbl *Table) HasUnitData(unitId uint16) bool {
 	return false
 }
 
-func (tbl *Table) NewIterator(fillCache bool) *Iterator {
+// DropTable deletes all data of the table with range deletion.
+// Only the units in units are touched, or all units if units is empty.
+func (tbl *Table) DropTable(dbId, tableId uint8, units ctrl.UnitSet) error {
+	var endDbId, endTableId = dbId, tableId + 1
+	if tableId == proto.MaxUint8 {
+		endDbId, endTableId = dbId+1, 0
+	}
+	return tbl.dropRange(dbId, tableId, endDbId, endTableId, units)
+}
+
+// DropDB deletes all data of the DB with range deletion.
+// Only the units in units are touched, or all units if units is empty.
+func (tbl *Table) DropDB(dbId uint8, units ctrl.UnitSet) error {
+	return tbl.dropRange(dbId, 0, dbId+1, 0, units)
+}
+
+// Delete [unitId+dbId+tableId, unitId+endDbId+endTableId) of every unit.
+func (tbl *Table) dropRange(dbId, tableId, endDbId, endTableId uint8,
+	units ctrl.UnitSet) error {
+	if dbId == proto.AdminDbId {
+		return errors.New("cannot drop the admin DB")
+	}
+
+	var wb = tbl.db.NewWriteBatch()
+	defer wb.Destroy()
+
+	for u := 0; u < ctrl.TotalUnitNum; u++ {
+		var unitId = uint16(u)
+		if len(units) > 0 && !units.Has(unitId) {
+			continue
+		}
+		err := tbl.db.DeleteRange(getRawUnitKey(unitId, dbId, tableId),
+			getRawUnitKey(unitId, endDbId, endTableId), wb)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tbl.db.Commit(wb)
+}
+
+// CompactDB compacts all data of the DB in every unit.
+func (tbl *Table) CompactDB(dbId uint8) {
+	tbl.compactPrefix([]byte{dbId})
+}
+
+// CompactTable compacts all data of the table in every unit.
+func (tbl *Table) CompactTable(dbId, tableId uint8) {
+	tbl.compactPrefix([]byte{dbId, tableId})
+}
+
+// CompactUnitRange compacts data of units [startUnitId, endUnitId].
+func (tbl *Table) CompactUnitRange(startUnitId, endUnitId uint16) {
+	tbl.db.CompactRange(getRawUnitKey(startUnitId, 0, 0),
+		getRawUnitKey(endUnitId+1, 0, 0))
+}
+
+// CompactAll compacts the whole key space.
+func (tbl *Table) CompactAll() {
+	tbl.db.CompactRange(nil, nil)
+}
+
+// Compact keys starting with unitId+prefix of every unit. Units are checked
+// by approximate sizes, as keys deleted by range deletion are not seen by
+// iterators but take space until compacted. Adjacent units with data are
+// compacted in one call, SST files span many units anyway.
+func (tbl *Table) compactPrefix(prefix []byte) {
+	// Memtables are not counted in approximate sizes
+	var err = tbl.db.Flush()
+	if err != nil {
+		log.Printf("Flush before compaction failed: %s\n", err)
+	}
+
+	var starts = make([][]byte, ctrl.TotalUnitNum)
+	var ends = make([][]byte, ctrl.TotalUnitNum)
+	for u := 0; u < ctrl.TotalUnitNum; u++ {
+		var start = make([]byte, 2+len(prefix))
+		binary.BigEndian.PutUint16(start, uint16(u))
+		copy(start[2:], prefix)
+		starts[u] = start
+		ends[u] = prefixEnd(start)
+	}
+
+	var sizes = tbl.db.ApproximateSizes(starts, ends)
+	for u := 0; u < len(sizes); u++ {
+		if sizes[u] == 0 {
+			continue
+		}
+		var first = u
+		for u+1 < len(sizes) && sizes[u+1] > 0 {
+			u++
+		}
+		tbl.db.CompactRange(starts[first], ends[u])
+	}
+}
+
+// prefixEnd returns the smallest key greater than all keys with the prefix.
+func prefixEnd(prefix []byte) []byte {
+	var end = append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// GetProperty returns the RocksDB property of every column family.
+func (tbl *Table) GetProperty(name string) []ctrl.PropertyValue {
+	var names, values = tbl.db.GetCFProperty(name)
+	var props = make([]ctrl.PropertyValue, 0, len(names))
+	for i := 0; i < len(names); i++ {
+		props = append(props, ctrl.PropertyValue{CF: names[i], Value: values[i]})
+	}
+	return props
+}
+
+// ListTables finds the tables with data in the DB, or in all DBs if allDB
+// is true. It seeks table by table in every unit, so it is fast only when
+// not too many tables are used. The result is sorted by dbId and tableId.
+func (tbl *Table) ListTables(dbId uint8, allDB bool) []ctrl.TableStats {
+	var rOpt = tbl.db.NewReadOptions(false)
+	rOpt.SetFillCache(false)
+	defer rOpt.Destroy()
+	var it = tbl.db.NewIterator(rOpt)
+	defer it.Destroy()
+
+	var used [256][256]bool
+	for u := 0; u < ctrl.TotalUnitNum; u++ {
+		var unitId = uint16(u)
+		var start = getRawUnitKey(unitId, dbId, 0)
+		if allDB {
+			start = getRawUnitKey(unitId, 0, 0)
+		}
+
+		for it.Seek(start); it.Valid(); {
+			curUnitId, curDbId, tableId := parseRawKeyUnitId(it.Key())
+			if curUnitId != unitId || (!allDB && curDbId != dbId) {
+				break
+			}
+			if curDbId != proto.AdminDbId || tableId != 0 {
+				used[curDbId][tableId] = true // Not the reserved admin table
+			}
+
+			// Skip to the next table
+			if tableId < proto.MaxUint8 {
+				it.Seek(getRawUnitKey(unitId, curDbId, tableId+1))
+			} else if curDbId < proto.MaxUint8 {
+				it.Seek(getRawUnitKey(unitId, curDbId+1, 0))
+			} else {
+				break
+			}
+		}
+	}
+
+	var tables []ctrl.TableStats
+	for d := 0; d < len(used); d++ {
+		for t := 0; t < len(used[d]); t++ {
+			if used[d][t] {
+				tables = append(tables,
+					ctrl.TableStats{DbId: uint8(d), TableId: uint8(t)})
+			}
+		}
+	}
+	return tables
+}
+
+// GetTableStats estimates the size and number of keys of the tables with
+// RocksDB approximate sizes over the unit key ranges.
+func (tbl *Table) GetTableStats(tables []ctrl.TableStats) {
+	if len(tables) == 0 {
+		return
+	}
+
+	var starts = make([][]byte, 0, len(tables)*ctrl.TotalUnitNum+1)
+	var ends = make([][]byte, 0, cap(starts))
+	for _, t := range tables {
+		var endDbId, endTableId = t.DbId, t.TableId + 1
+		if t.TableId == proto.MaxUint8 {
+			endDbId, endTableId = t.DbId+1, 0
+		}
+		for u := 0; u < ctrl.TotalUnitNum; u++ {
+			starts = append(starts, getRawUnitKey(uint16(u), t.DbId, t.TableId))
+			ends = append(ends, getRawUnitKey(uint16(u), endDbId, endTableId))
+		}
+	}
+	// The whole DB, to get the average key size
+	starts = append(starts, getRawUnitKey(0, 0, 0))
+	ends = append(ends, getRawUnitKey(ctrl.TotalUnitNum, 0, 0))
+
+	var sizes = tbl.db.ApproximateSizes(starts, ends)
+	if len(sizes) != len(starts) {
+		return
+	}
+
+	var totalSize = sizes[len(sizes)-1]
+	var totalKeys = tbl.db.GetIntProperty("rocksdb.estimate-num-keys")
+	for i := 0; i < len(tables); i++ {
+		var size uint64
+		for _, s := range sizes[i*ctrl.TotalUnitNum : (i+1)*ctrl.TotalUnitNum] {
+			size += s
+		}
+		tables[i].Size = size
+		if totalSize > 0 {
+			tables[i].Keys = uint64(float64(totalKeys) *
+				float64(size) / float64(totalSize))
+		}
+	}
+}
+
+// GetAdminValue reads the value of rowKey in the reserved admin table.
+// Return nil if not exist.
+func (tbl *Table) GetAdminValue(rowKey string) ([]byte, error) {
+	var rawKey = getRawKey(proto.AdminDbId, 0, proto.ColSpaceDefault,
+		[]byte(rowKey), nil)
+	value, err := tbl.db.Get(nil, rawKey)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	value, _ = parseRawValue(value)
+	return value, nil
+}
+
+// SetAdminValue writes the value of rowKey in the reserved admin table.
+func (tbl *Table) SetAdminValue(rowKey string, value []byte) error {
+	var rawKey = getRawKey(proto.AdminDbId, 0, proto.ColSpaceDefault,
+		[]byte(rowKey), nil)
+	return tbl.db.Put(rawKey, getRawValue(value, 0), nil)
+}
+
+func (tbl *Table) NewIterator(fillCache bool) Iterator {
 	if fillCache {
 		return tbl.db.NewIterator(nil)
 	} else {
@@ -1110,7 +1280,50 @@ func (tbl *Table) NewIterator(fillCache bool) *Iterator {
 	}
 }
 
-func SeekAndCopySyncPkg(it *Iterator, p *proto.PkgOneOp) (uint16, bool) {
+// Snapshot is a consistent read-only view of the table.
+type Snapshot struct {
+	rOpt ReadOptions
+}
+
+func (tbl *Table) NewSnapshot() *Snapshot {
+	var rOpt = tbl.db.NewReadOptions(true)
+	rOpt.SetFillCache(false)
+	return &Snapshot{rOpt}
+}
+
+func (snap *Snapshot) Release() {
+	if snap.rOpt != nil {
+		snap.rOpt.Destroy()
+		snap.rOpt = nil
+	}
+}
+
+// NewSnapshotIterator creates an iterator reading from the snapshot,
+// without filling cache. The snapshot should be released after the iterator.
+func (tbl *Table) NewSnapshotIterator(snap *Snapshot) Iterator {
+	return tbl.db.NewIterator(snap.rOpt)
+}
+
+// SeekAfter seeks to the first key after rawKey.
+func SeekAfter(it Iterator, rawKey []byte) {
+	it.Seek(rawKey)
+	if it.Valid() && bytes.Equal(it.Key(), rawKey) {
+		it.Next()
+	}
+}
+
+// Flush writes the memory data of the table to disk.
+func (tbl *Table) Flush() error {
+	return tbl.db.Flush()
+}
+
+// Checkpoint creates an openable snapshot of the table in dir.
+// Caller should stop write to get a consistent binlog seq.
+func (tbl *Table) Checkpoint(dir string) error {
+	return tbl.db.Checkpoint(dir)
+}
+
+func SeekAndCopySyncPkg(it Iterator, p *proto.PkgOneOp) (uint16, bool) {
 	p.PkgFlag &^= 0xFF
 	p.CtrlFlag &^= 0xFF
 
@@ -1143,7 +1356,7 @@ func SeekAndCopySyncPkg(it *Iterator, p *proto.PkgOneOp) (uint16, bool) {
 	return unitId, true
 }
 
-func SeekToUnit(it *Iterator, unitId uint16, dbId, tableId uint8) {
+func SeekToUnit(it Iterator, unitId uint16, dbId, tableId uint8) {
 	it.Seek(getRawUnitKey(unitId, dbId, tableId))
 }
 
@@ -1175,6 +1388,11 @@ func getRawKey(dbId, tableId, colSpace uint8, rowKey, colKey []byte) []byte {
 	return rawKey
 }
 
+// rowPrefix returns the prefix of the raw key for the row and colSpace.
+func rowPrefix(dbId, tableId, colSpace uint8, rowKey []byte) []byte {
+	return getRawKey(dbId, tableId, colSpace, rowKey, nil)
+}
+
 func parseRawKey(rawKey []byte) (unitId uint16, dbId, tableId, colSpace uint8,
 	rowKey, colKey []byte) {
 	unitId = binary.BigEndian.Uint16(rawKey)
@@ -20,6 +20,7 @@ import (
 	"github.com/stevejiang/gotable/api/go/table"
 	"github.com/stevejiang/gotable/api/go/table/proto"
 	"github.com/stevejiang/gotable/config"
+	"github.com/stevejiang/gotable/ctrl"
 	"os"
 	"sync"
 	"testing"
@@ -44,7 +45,14 @@ func getTestTable() *Table {
 	f := func() {
 		tblDir := "/tmp/test_gotable/table"
 		os.RemoveAll(tblDir)
-		testTbl = NewTable(tblDir, 1024, 1024*1024, 1024*1024, "snappy")
+		var conf config.Config
+		conf.Db.WriteBufSize = 1024 * 1024
+		conf.Db.CacheSize = 1024 * 1024
+		conf.Db.Compression = "snappy"
+		if !HasEngine(EngineRocksDB) {
+			conf.Db.Engine = EngineMemory
+		}
+		testTbl = NewTable(tblDir, 1024, &conf)
 	}
 
 	testTblOnce.Do(f)
@@ -56,7 +64,7 @@ func getTestWA() *WriteAccess {
 }
 
 func myGet(in proto.PkgOneOp, au Authorize, wa *WriteAccess,
-	t *testing.T) proto.PkgOneOp {
+	t testing.TB) proto.PkgOneOp {
 	var pkg = make([]byte, in.Length())
 	_, err := in.Encode(pkg)
 	if err != nil {
@@ -89,7 +97,7 @@ func myGet(in proto.PkgOneOp, au Authorize, wa *WriteAccess,
 }
 
 func mySet(in proto.PkgOneOp, au Authorize, wa *WriteAccess, expected bool,
-	t *testing.T) proto.PkgOneOp {
+	t testing.TB) proto.PkgOneOp {
 	var pkg = make([]byte, in.Length())
 	_, err := in.Encode(pkg)
 	if err != nil {
@@ -346,13 +354,19 @@ func myMIncr(in proto.PkgMultiOp, au Authorize, wa *WriteAccess, expected bool,
 }
 
 func myScan(in proto.PkgScanReq, au Authorize, t *testing.T) proto.PkgScanResp {
+	return myScanCache(in, au, nil, t)
+}
+
+func myScanCache(in proto.PkgScanReq, au Authorize, rc *ReadCache,
+	t testing.TB) proto.PkgScanResp {
 	var pkg = make([]byte, in.Length())
 	_, err := in.Encode(pkg)
 	if err != nil {
 		t.Fatalf("Encode failed: ", err)
 	}
 
-	pkg = testTbl.Scan(&PkgArgs{in.Cmd, in.DbId, in.Seq, pkg}, au)
+	pkg = testTbl.Scan(&PkgArgs{in.Cmd, in.DbId, in.Seq, pkg}, au, getTestWA(),
+		rc)
 
 	var out proto.PkgScanResp
 	_, err = out.Decode(pkg)
@@ -716,6 +730,109 @@ func TestTableMGetAfterDel(t *testing.T) {
 	}
 }
 
+func getTestPkgArgs(in proto.PkgEncoding, cmd, dbId uint8, t *testing.T) *PkgArgs {
+	var pkg = make([]byte, in.Length())
+	_, err := in.Encode(pkg)
+	if err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	return &PkgArgs{cmd, dbId, 0, pkg}
+}
+
+func TestTableGroupWrite(t *testing.T) {
+	var tbl = getTestTable()
+	var ms proto.PkgMultiOp
+	ms.Cmd = proto.CmdMSet
+	ms.DbId = 3
+	ms.Kvs = append(ms.Kvs, getTestKV(3, []byte("row5"), []byte("col1"), []byte("v51"), 0, 0))
+	ms.Kvs = append(ms.Kvs, getTestKV(3, []byte("row5"), []byte("col2"), []byte("v52"), 0, 0))
+
+	var one proto.PkgOneOp
+	one.Cmd = proto.CmdSet
+	one.DbId = 3
+	one.KeyValue = getTestKV(3, []byte("row6"), []byte("col1"), []byte("v61"), 0, 0)
+
+	// The keys must use different locks to be pending at the same time,
+	// otherwise the second PrepareWrite waits for the first one forever
+	var oneLock = lockIndex(getRawKey(3, 3, 0, []byte("row6"), []byte("col1")))
+	for _, col := range []string{"col1", "col2"} {
+		if lockIndex(getRawKey(3, 3, 0, []byte("row5"), []byte(col))) == oneLock {
+			t.Fatalf("Keys of the group share a lock, choose other keys")
+		}
+	}
+
+	var group = []*GroupWrite{
+		tbl.PrepareWrite(getTestPkgArgs(&ms, ms.Cmd, ms.DbId, t), testAuth, getTestWA()),
+		tbl.PrepareWrite(getTestPkgArgs(&one, one.Cmd, one.DbId, t), testAuth, getTestWA()),
+	}
+	for _, gw := range group {
+		if !gw.Pending() {
+			t.Fatalf("Write should be pending")
+		}
+	}
+
+	tbl.CommitGroup(group)
+	for _, gw := range group {
+		_, ok := gw.Reply()
+		gw.Done()
+		if !ok {
+			t.Fatalf("Group write failed")
+		}
+	}
+
+	var in proto.PkgOneOp
+	in.Cmd = proto.CmdGet
+	in.DbId = 3
+	in.KeyValue = getTestKV(3, []byte("row5"), []byte("col2"), nil, 0, 0)
+	out := myGet(in, testAuth, getTestWA(), t)
+	if bytes.Compare(out.Value, []byte("v52")) != 0 {
+		t.Fatalf("Value mismatch: %q", out.Value)
+	}
+
+	in.KeyValue = getTestKV(3, []byte("row6"), []byte("col1"), nil, 0, 0)
+	out = myGet(in, testAuth, getTestWA(), t)
+	if bytes.Compare(out.Value, []byte("v61")) != 0 {
+		t.Fatalf("Value mismatch: %q", out.Value)
+	}
+}
+
+func TestTableMSetAtomic(t *testing.T) {
+	var in proto.PkgMultiOp
+	in.Cmd = proto.CmdMSet
+	in.DbId = 3
+	in.Seq = 20
+	in.PkgFlag |= proto.FlagAtomic
+	in.Kvs = append(in.Kvs, getTestKV(4, []byte("row7"), []byte("col1"), []byte("v71"), 0, 0))
+	in.Kvs = append(in.Kvs, getTestKV(4, []byte("row7"), []byte("col2"), []byte("v72"), 0, 600))
+
+	out := myMSet(in, testAuth, getTestWA(), false, t)
+	if len(out.Kvs) != 2 {
+		t.Fatalf("Invalid KV number: %d", len(out.Kvs))
+	}
+	if out.Kvs[0].ErrCode != table.EcAborted {
+		t.Fatalf("Should fail with EcAborted")
+	}
+	if out.Kvs[1].ErrCode != table.EcCasNotMatch {
+		t.Fatalf("Should fail with EcCasNotMatch")
+	}
+
+	var get proto.PkgOneOp
+	get.Cmd = proto.CmdGet
+	get.DbId = 3
+	get.KeyValue = getTestKV(4, []byte("row7"), []byte("col1"), nil, 0, 0)
+	if r := myGet(get, testAuth, getTestWA(), t); r.ErrCode != table.EcNotExist {
+		t.Fatalf("Aborted KV should not be written")
+	}
+
+	in.Kvs[1].SetCas(0)
+	out = myMSet(in, testAuth, getTestWA(), true, t)
+	for i := 0; i < len(out.Kvs); i++ {
+		if out.Kvs[i].ErrCode != 0 {
+			t.Fatalf("ErrCode is not 0")
+		}
+	}
+}
+
 func TestTableScan(t *testing.T) {
 	// MSET
 	{
@@ -805,6 +922,39 @@ func TestTableScan(t *testing.T) {
 	}
 }
 
+func TestTableScanCache(t *testing.T) {
+	var rc = getTestTable().NewReadCache()
+	defer rc.Destroy()
+
+	var in proto.PkgScanReq
+	in.Cmd = proto.CmdScan
+	in.DbId = 3
+	in.Seq = 21
+	in.Num = 10
+	in.TableId = 2
+	in.RowKey = []byte("row2")
+	in.PkgFlag |= proto.FlagScanAsc
+
+	for i := 0; i < 3; i++ {
+		var set proto.PkgOneOp
+		set.Cmd = proto.CmdSet
+		set.DbId = 3
+		set.Seq = 21
+		set.KeyValue = getTestKV(2, []byte("row2"),
+			[]byte(fmt.Sprintf("col%d", i)), []byte("v"), 0, 0)
+		mySet(set, testAuth, getTestWA(), true, t)
+
+		// The cached iterators see the new column
+		for _, flag := range []uint8{proto.FlagScanAsc, proto.FlagScanKeyStart} {
+			in.PkgFlag = flag
+			out := myScanCache(in, testAuth, rc, t)
+			if len(out.Kvs) != i+1 {
+				t.Fatalf("Invalid KV number: %d", len(out.Kvs))
+			}
+		}
+	}
+}
+
 func TestTableZScan(t *testing.T) {
 	// MZSET
 	{
@@ -957,3 +1107,370 @@ func TestTableDump(t *testing.T) {
 		}
 	}
 }
+
+func TestTableSnapshot(t *testing.T) {
+	var snap = testTbl.NewSnapshot()
+	defer snap.Release()
+
+	var in proto.PkgOneOp
+	in.Cmd = proto.CmdSet
+	in.DbId = 1
+	in.Seq = 10
+	in.KeyValue = getTestKV(2, []byte("row1"), []byte("col-snap"), []byte("v1"), 0, 0)
+	mySet(in, testAuth, getTestWA(), true, t)
+
+	var it = testTbl.NewSnapshotIterator(snap)
+	defer it.Destroy()
+
+	var rawKey = getRawKey(1, 2, 0, []byte("row1"), []byte("col-snap"))
+	it.Seek(rawKey)
+	if it.Valid() && bytes.Compare(it.Key(), rawKey) == 0 {
+		t.Fatalf("Key written after snapshot is visible")
+	}
+
+	rawKey = getRawKey(1, 2, 0, []byte("row1"), []byte("col1"))
+	it.Seek(rawKey)
+	if !it.Valid() || bytes.Compare(it.Key(), rawKey) != 0 {
+		t.Fatalf("Key written before snapshot is missing")
+	}
+
+	SeekAfter(it, rawKey)
+	if it.Valid() && bytes.Compare(it.Key(), rawKey) <= 0 {
+		t.Fatalf("SeekAfter should skip the key")
+	}
+}
+
+func TestTableDeleteUnit(t *testing.T) {
+	err := testTbl.SetAdminValue("test-unit-del", []byte("admin"))
+	if err != nil {
+		t.Fatalf("SetAdminValue failed: %s", err)
+	}
+
+	// Find a row key in the same unit as the admin key
+	var unitId = ctrl.GetUnitId(proto.AdminDbId, 0, []byte("test-unit-del"))
+	var rowKey []byte
+	for i := 0; ; i++ {
+		rowKey = []byte(fmt.Sprintf("unit-del-%d", i))
+		if ctrl.GetUnitId(1, 2, rowKey) == unitId {
+			break
+		}
+	}
+
+	var in proto.PkgOneOp
+	in.Cmd = proto.CmdSet
+	in.DbId = 1
+	in.Seq = 10
+	in.KeyValue = getTestKV(2, rowKey, []byte("col1"), []byte("v1"), 0, 0)
+	mySet(in, testAuth, getTestWA(), true, t)
+
+	if !testTbl.HasUnitData(unitId) {
+		t.Fatalf("Unit %d should have data", unitId)
+	}
+
+	err = testTbl.DeleteUnit(unitId, true)
+	if err != nil {
+		t.Fatalf("DeleteUnit failed: %s", err)
+	}
+
+	if testTbl.HasUnitData(unitId) {
+		t.Fatalf("Unit %d still has data", unitId)
+	}
+
+	value, err := testTbl.GetAdminValue("test-unit-del")
+	if err != nil || bytes.Compare(value, []byte("admin")) != 0 {
+		t.Fatalf("Admin value should be kept: %q, %v", value, err)
+	}
+}
+
+func putTestRawKV(dbId, tableId uint8, rowKey string, t *testing.T) {
+	var rawKey = getRawKey(dbId, tableId, 0, []byte(rowKey), []byte("col"))
+	err := testTbl.db.Put(rawKey, getRawValue([]byte("v"), 0), nil)
+	if err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+}
+
+func hasTestRawKV(dbId, tableId uint8, rowKey string, t *testing.T) bool {
+	var rawKey = getRawKey(dbId, tableId, 0, []byte(rowKey), []byte("col"))
+	value, err := testTbl.db.Get(nil, rawKey)
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	return value != nil
+}
+
+// getTestRowsOfUnits returns two row keys of different units.
+func getTestRowsOfUnits(dbId, tableId uint8) (string, string) {
+	var row1 = "row0"
+	var unit1 = ctrl.GetUnitId(dbId, tableId, []byte(row1))
+	for i := 1; ; i++ {
+		var row2 = fmt.Sprintf("row%d", i)
+		if ctrl.GetUnitId(dbId, tableId, []byte(row2)) != unit1 {
+			return row1, row2
+		}
+	}
+}
+
+// compactCounter records the ranges compacted.
+type compactCounter struct {
+	Engine
+	ranges [][2][]byte
+}
+
+func (c *compactCounter) CompactRange(start, end []byte) {
+	c.ranges = append(c.ranges, [2][]byte{start, end})
+}
+
+func TestTableCompactTable(t *testing.T) {
+	var db = &compactCounter{Engine: NewMemDB()}
+	var tbl = &Table{db: db}
+	for _, u := range []uint16{5, 6, 100} {
+		db.Put(append(getRawUnitKey(u, 1, 2), "row"...), []byte("v"), nil)
+		db.Put(append(getRawUnitKey(u, 1, 3), "row"...), []byte("v"), nil)
+	}
+
+	// Units 5 and 6 are compacted in one range, units without data skipped
+	tbl.CompactTable(1, 2)
+	var want = [][2][]byte{
+		{getRawUnitKey(5, 1, 2), getRawUnitKey(6, 1, 3)},
+		{getRawUnitKey(100, 1, 2), getRawUnitKey(100, 1, 3)},
+	}
+	if len(db.ranges) != len(want) {
+		t.Fatalf("Compacted ranges mismatch: %q", db.ranges)
+	}
+	for i := range want {
+		if !bytes.Equal(db.ranges[i][0], want[i][0]) ||
+			!bytes.Equal(db.ranges[i][1], want[i][1]) {
+			t.Fatalf("Range %d mismatch: %q", i, db.ranges[i])
+		}
+	}
+
+	db.ranges = nil
+	tbl.CompactTable(1, 4)
+	if len(db.ranges) != 0 {
+		t.Fatalf("Empty table should not be compacted: %q", db.ranges)
+	}
+}
+
+func TestTableDropTable(t *testing.T) {
+	var tbl = getTestTable()
+	for _, tableId := range []uint8{3, 4, 5} {
+		putTestRawKV(20, tableId, "row1", t)
+	}
+	err := tbl.DropTable(20, 4, nil)
+	if err != nil {
+		t.Fatalf("DropTable failed: %s", err)
+	}
+	if hasTestRawKV(20, 4, "row1", t) {
+		t.Fatalf("Table not dropped")
+	}
+	if !hasTestRawKV(20, 3, "row1", t) || !hasTestRawKV(20, 5, "row1", t) {
+		t.Fatalf("Neighbouring table dropped")
+	}
+
+	// Table 255 ends before table 0 of the next DB
+	putTestRawKV(21, 255, "row1", t)
+	putTestRawKV(22, 0, "row1", t)
+	err = tbl.DropTable(21, 255, nil)
+	if err != nil {
+		t.Fatalf("DropTable failed: %s", err)
+	}
+	if hasTestRawKV(21, 255, "row1", t) {
+		t.Fatalf("Table not dropped")
+	}
+	if !hasTestRawKV(22, 0, "row1", t) {
+		t.Fatalf("Table of the next DB dropped")
+	}
+
+	// The admin table follows table 255 of DB 254
+	err = tbl.SetAdminValue("drop-test", []byte("v"))
+	if err != nil {
+		t.Fatalf("SetAdminValue failed: %s", err)
+	}
+	err = tbl.DropTable(254, 255, nil)
+	if err != nil {
+		t.Fatalf("DropTable failed: %s", err)
+	}
+	if v, _ := tbl.GetAdminValue("drop-test"); v == nil {
+		t.Fatalf("Admin table dropped")
+	}
+	if tbl.DropTable(proto.AdminDbId, 0, nil) == nil {
+		t.Fatalf("Admin table should not be dropped")
+	}
+
+	// Only the units given are dropped
+	var row1, row2 = getTestRowsOfUnits(20, 6)
+	putTestRawKV(20, 6, row1, t)
+	putTestRawKV(20, 6, row2, t)
+	var units = ctrl.NewUnitSet([]uint16{ctrl.GetUnitId(20, 6, []byte(row1))})
+	err = tbl.DropTable(20, 6, units)
+	if err != nil {
+		t.Fatalf("DropTable failed: %s", err)
+	}
+	if hasTestRawKV(20, 6, row1, t) {
+		t.Fatalf("Table not dropped in the unit")
+	}
+	if !hasTestRawKV(20, 6, row2, t) {
+		t.Fatalf("Table dropped out of the units")
+	}
+}
+
+func TestTableDropDB(t *testing.T) {
+	var tbl = getTestTable()
+	putTestRawKV(22, 255, "row1", t)
+	putTestRawKV(23, 0, "row1", t)
+	putTestRawKV(23, 255, "row1", t)
+	putTestRawKV(24, 0, "row1", t)
+	err := tbl.DropDB(23, nil)
+	if err != nil {
+		t.Fatalf("DropDB failed: %s", err)
+	}
+	if hasTestRawKV(23, 0, "row1", t) || hasTestRawKV(23, 255, "row1", t) {
+		t.Fatalf("DB not dropped")
+	}
+	if !hasTestRawKV(22, 255, "row1", t) || !hasTestRawKV(24, 0, "row1", t) {
+		t.Fatalf("Neighbouring DB dropped")
+	}
+
+	err = tbl.SetAdminValue("drop-test", []byte("v"))
+	if err != nil {
+		t.Fatalf("SetAdminValue failed: %s", err)
+	}
+	if tbl.DropDB(proto.AdminDbId, nil) == nil {
+		t.Fatalf("Admin DB should not be dropped")
+	}
+	err = tbl.DropDB(254, nil)
+	if err != nil {
+		t.Fatalf("DropDB failed: %s", err)
+	}
+	if v, _ := tbl.GetAdminValue("drop-test"); v == nil {
+		t.Fatalf("Admin table dropped")
+	}
+
+	var row1, row2 = getTestRowsOfUnits(25, 1)
+	putTestRawKV(25, 1, row1, t)
+	putTestRawKV(25, 1, row2, t)
+	var units = ctrl.NewUnitSet([]uint16{ctrl.GetUnitId(25, 1, []byte(row1))})
+	err = tbl.DropDB(25, units)
+	if err != nil {
+		t.Fatalf("DropDB failed: %s", err)
+	}
+	if hasTestRawKV(25, 1, row1, t) {
+		t.Fatalf("DB not dropped in the unit")
+	}
+	if !hasTestRawKV(25, 1, row2, t) {
+		t.Fatalf("DB dropped out of the units")
+	}
+}
+
+// Columns col0 ~ col9 of row "bench"
+func setBenchRow(b *testing.B) {
+	for i := 0; i < 10; i++ {
+		var in proto.PkgOneOp
+		in.Cmd = proto.CmdSet
+		in.DbId = 2
+		in.KeyValue = getTestKV(2, []byte("bench"),
+			[]byte(fmt.Sprintf("col%d", i)), []byte("value"), 0, 0)
+		mySet(in, testAuth, getTestWA(), true, b)
+	}
+}
+
+func benchmarkTableScan(b *testing.B, cached bool) {
+	getTestTable()
+	var rc *ReadCache
+	if cached {
+		rc = getTestTable().NewReadCache()
+		defer rc.Destroy()
+	}
+	setBenchRow(b)
+
+	var in proto.PkgScanReq
+	in.Cmd = proto.CmdScan
+	in.DbId = 2
+	in.Num = 10
+	in.TableId = 2
+	in.RowKey = []byte("bench")
+	in.PkgFlag |= proto.FlagScanAsc
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		myScanCache(in, testAuth, rc, b)
+	}
+}
+
+func BenchmarkTableScan(b *testing.B) {
+	benchmarkTableScan(b, false)
+}
+
+func BenchmarkTableScanCache(b *testing.B) {
+	benchmarkTableScan(b, true)
+}
+
+func benchmarkTableGet(b *testing.B, valueLen int) {
+	getTestTable()
+
+	var in proto.PkgOneOp
+	in.Cmd = proto.CmdSet
+	in.DbId = 2
+	in.KeyValue = getTestKV(2, []byte("bench"), []byte("get"),
+		bytes.Repeat([]byte("v"), valueLen), 0, 0)
+	mySet(in, testAuth, getTestWA(), true, b)
+
+	in.Cmd = proto.CmdGet
+	in.KeyValue = getTestKV(2, []byte("bench"), []byte("get"), nil, 0, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		myGet(in, testAuth, getTestWA(), b)
+	}
+}
+
+func BenchmarkTableGet(b *testing.B) {
+	benchmarkTableGet(b, 5)
+}
+
+func BenchmarkTableGet4K(b *testing.B) {
+	benchmarkTableGet(b, 4096)
+}
+
+func TestTableListTables(t *testing.T) {
+	var tbl = getTestTable()
+	for _, tableId := range []uint8{7, 2, 9, 7} {
+		putTestRawKV(30, tableId, fmt.Sprintf("row%d", tableId), t)
+	}
+	putTestRawKV(31, 1, "row1", t)
+	err := tbl.SetAdminValue("list-test", []byte("v"))
+	if err != nil {
+		t.Fatalf("SetAdminValue failed: %s", err)
+	}
+
+	var tables = tbl.ListTables(30, false)
+	if len(tables) != 3 {
+		t.Fatalf("Invalid table number: %d", len(tables))
+	}
+	for i, tableId := range []uint8{2, 7, 9} {
+		if tables[i].DbId != 30 || tables[i].TableId != tableId {
+			t.Fatalf("Table mismatch: %d %d", tables[i].DbId, tables[i].TableId)
+		}
+	}
+
+	tables = tbl.ListTables(0, true)
+	var found = 0
+	for i, ts := range tables {
+		if ts.DbId == proto.AdminDbId && ts.TableId == 0 {
+			t.Fatalf("Admin table should be skipped")
+		}
+		if i > 0 && (tables[i-1].DbId > ts.DbId || (tables[i-1].DbId ==
+			ts.DbId && tables[i-1].TableId >= ts.TableId)) {
+			t.Fatalf("Tables not sorted")
+		}
+		if ts.DbId == 30 || ts.DbId == 31 {
+			found++
+		}
+	}
+	if found != 4 {
+		t.Fatalf("Tables of all DBs should be listed: %d", found)
+	}
+}